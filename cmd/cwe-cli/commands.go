@@ -9,10 +9,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
 )
 
 // Client wraps HTTP client for API calls.
@@ -69,11 +72,13 @@ func getClient(cmd *cobra.Command) *Client {
 
 func newSubmitCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "submit <workflow.cwl> <job.yaml>",
+		Use:   "submit <workflow.cwl[#entry]> <job.yaml>",
 		Short: "Submit a CWL workflow",
-		Long:  `Submit a CWL workflow document with a job file containing inputs`,
-		Args:  cobra.ExactArgs(2),
-		RunE:  runSubmit,
+		Long: `Submit a CWL workflow document with a job file containing inputs.
+For a packed $graph document (cwltool --pack), "#entry" selects the process
+to run; "#main" is used by default.`,
+		Args: cobra.ExactArgs(2),
+		RunE: runSubmit,
 	}
 
 	cmd.Flags().StringP("output", "o", "", "Output path in Workspace")
@@ -83,24 +88,14 @@ func newSubmitCmd() *cobra.Command {
 }
 
 func runSubmit(cmd *cobra.Command, args []string) error {
-	workflowPath := args[0]
+	workflowPath, entryPoint := splitEntryPoint(args[0])
 	jobPath := args[1]
 
-	// Read workflow document
-	workflowData, err := os.ReadFile(workflowPath)
+	// Read the workflow document, resolving its references to other files
+	// here since the server won't read files on the submitter's behalf
+	workflow, err := cwl.NewParser().PackFile(workflowPath)
 	if err != nil {
-		return fmt.Errorf("failed to read workflow file: %w", err)
-	}
-
-	var workflow interface{}
-	if filepath.Ext(workflowPath) == ".json" {
-		if err := json.Unmarshal(workflowData, &workflow); err != nil {
-			return fmt.Errorf("failed to parse workflow JSON: %w", err)
-		}
-	} else {
-		if err := yaml.Unmarshal(workflowData, &workflow); err != nil {
-			return fmt.Errorf("failed to parse workflow YAML: %w", err)
-		}
+		return fmt.Errorf("failed to load workflow: %w", err)
 	}
 
 	// Read job file (inputs)
@@ -134,6 +129,9 @@ func runSubmit(cmd *cobra.Command, args []string) error {
 	if name != "" {
 		reqBody["name"] = name
 	}
+	if entryPoint != "" {
+		reqBody["entry_point"] = entryPoint
+	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -382,7 +380,7 @@ func runUpload(cmd *cobra.Command, args []string) error {
 
 func newValidateCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "validate <workflow.cwl>",
+		Use:   "validate <workflow.cwl[#entry]>",
 		Short: "Validate a CWL document",
 		Args:  cobra.ExactArgs(1),
 		RunE:  runValidate,
@@ -390,27 +388,19 @@ func newValidateCmd() *cobra.Command {
 }
 
 func runValidate(cmd *cobra.Command, args []string) error {
-	workflowPath := args[0]
+	workflowPath, entryPoint := splitEntryPoint(args[0])
 
-	// Read and parse workflow
-	workflowData, err := os.ReadFile(workflowPath)
+	// Read and pack workflow
+	workflow, err := cwl.NewParser().PackFile(workflowPath)
 	if err != nil {
-		return fmt.Errorf("failed to read workflow: %w", err)
-	}
-
-	var workflow interface{}
-	if filepath.Ext(workflowPath) == ".json" {
-		if err := json.Unmarshal(workflowData, &workflow); err != nil {
-			return fmt.Errorf("failed to parse JSON: %w", err)
-		}
-	} else {
-		if err := yaml.Unmarshal(workflowData, &workflow); err != nil {
-			return fmt.Errorf("failed to parse YAML: %w", err)
-		}
+		return fmt.Errorf("failed to load workflow: %w", err)
 	}
 
 	// Validate
-	reqBody, _ := json.Marshal(map[string]interface{}{"document": workflow})
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"document":    workflow,
+		"entry_point": entryPoint,
+	})
 	client := getClient(cmd)
 
 	resp, err := client.doRequest("POST", "/api/v1/validate", bytes.NewReader(reqBody))
@@ -527,3 +517,12 @@ func runSteps(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// splitEntryPoint splits "workflow.cwl#entry" into the file path and the
+// $graph entry point.
+func splitEntryPoint(arg string) (string, string) {
+	if i := strings.LastIndex(arg, "#"); i >= 0 {
+		return arg[:i], arg[i+1:]
+	}
+	return arg, ""
+}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 
 	"github.com/BV-BRC/cwe-cwl/internal/config"
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
 	"github.com/BV-BRC/cwe-cwl/internal/dag"
 	"github.com/BV-BRC/cwe-cwl/internal/events"
 	"github.com/BV-BRC/cwe-cwl/internal/executor"
@@ -24,6 +26,9 @@ import (
 )
 
 func main() {
+	// The expression sandbox starts this binary as its worker processes
+	sandbox.RunWorkerIfRequested()
+
 	// Parse command line flags
 	configPath := flag.String("config", "", "Path to configuration file")
 	flag.Parse()
@@ -71,8 +76,19 @@ func main() {
 		exec = executor.NewAppServiceExecutor(cfg)
 	} else {
 		// Local executor for development
-		exec = executor.NewLocalExecutor("/tmp/cwe-cwl-work")
+		local := executor.NewLocalExecutor("/tmp/cwe-cwl-work")
+		local.SetChecksums(cfg.Executor.Checksums)
+		exec = local
+	}
+
+	// Create expression evaluator for ExpressionTool steps and for every
+	// other expression the scheduler evaluates (valueFrom, when, glob, ...)
+	evaluator, err := sandbox.NewEvaluator(cfg.Sandbox)
+	if err != nil {
+		log.Fatalf("Failed to create expression evaluator: %v", err)
 	}
+	defer evaluator.Close()
+	cwl.SetDefaultSandbox(evaluator, cfg.Sandbox.Timeout())
 
 	// Create event publisher
 	publisher := events.NewPublisher(redisClient)
@@ -86,10 +102,11 @@ func main() {
 
 	// Create scheduler instance
 	schedulerRunner := &SchedulerRunner{
-		config:    cfg,
-		store:     store,
-		executor:  exec,
-		publisher: publisher,
+		config:     cfg,
+		store:      store,
+		executor:   exec,
+		exprRunner: dag.NewExpressionToolRunner(evaluator, cfg.Sandbox.Timeout()),
+		publisher:  publisher,
 	}
 
 	// Create context for graceful shutdown
@@ -124,10 +141,11 @@ func main() {
 
 // SchedulerRunner manages workflow execution.
 type SchedulerRunner struct {
-	config    *config.Config
-	store     *state.Store
-	executor  dag.Executor
-	publisher *events.Publisher
+	config     *config.Config
+	store      *state.Store
+	executor   dag.Executor
+	exprRunner *dag.ExpressionToolRunner
+	publisher  *events.Publisher
 }
 
 // Run starts the scheduler loop.
@@ -198,7 +216,7 @@ func (sr *SchedulerRunner) startWorkflow(ctx context.Context, runID string) erro
 	// Parse the workflow
 	parser := cwl.NewParser()
 	docBytes, _ := json.Marshal(workflow.Document)
-	doc, err := parser.ParseBytes(docBytes)
+	doc, err := parser.ParseBytesEntryPoint(docBytes, workflow.EntryPoint)
 	if err != nil {
 		return sr.store.UpdateWorkflowRunError(ctx, runID, fmt.Sprintf("failed to parse workflow: %v", err))
 	}
@@ -265,7 +283,7 @@ func (sr *SchedulerRunner) processRunningWorkflow(ctx context.Context, runID str
 	// Rebuild DAG from state
 	parser := cwl.NewParser()
 	docBytes, _ := json.Marshal(workflow.Document)
-	doc, err := parser.ParseBytes(docBytes)
+	doc, err := parser.ParseBytesEntryPoint(docBytes, workflow.EntryPoint)
 	if err != nil {
 		return err
 	}
@@ -279,25 +297,101 @@ func (sr *SchedulerRunner) processRunningWorkflow(ctx context.Context, runID str
 	// Restore DAG state
 	restoreDAG(workflowDAG, run.DAGState)
 
+	// Record the tasks that finished since the last pass
+	sr.checkRunningNodes(ctx, workflowDAG, run.ID)
+
+	// Finish the run once every node is done
+	if workflowDAG.IsComplete() {
+		return sr.finishWorkflow(ctx, builder, workflowDAG, run)
+	}
+
 	// Schedule ready nodes
 	return sr.scheduleReadyNodes(ctx, workflowDAG, run)
 }
 
+// finishWorkflow records the final status of a run whose DAG is complete,
+// collecting the workflow outputs if it succeeded.
+func (sr *SchedulerRunner) finishWorkflow(ctx context.Context, builder *dag.Builder, workflowDAG *dag.DAG, run *state.WorkflowRun) error {
+	status := state.WorkflowCompleted
+	if workflowDAG.HasFailed() {
+		status = state.WorkflowFailed
+	} else {
+		outputs, err := builder.CollectOutputs(workflowDAG)
+		if err != nil {
+			return sr.store.UpdateWorkflowRunError(ctx, run.ID, fmt.Sprintf("failed to collect outputs: %v", err))
+		}
+		if err := sr.store.UpdateWorkflowRunOutputs(ctx, run.ID, outputs); err != nil {
+			return err
+		}
+	}
+
+	if err := sr.store.UpdateWorkflowRunStatus(ctx, run.ID, status); err != nil {
+		return err
+	}
+
+	sr.publisher.PublishWorkflowEvent(ctx, events.WorkflowEvent{
+		Type:       "workflow_completed",
+		WorkflowID: run.ID,
+		Status:     string(status),
+	})
+
+	return nil
+}
+
 // scheduleReadyNodes schedules ready nodes for execution.
 func (sr *SchedulerRunner) scheduleReadyNodes(ctx context.Context, workflowDAG *dag.DAG, run *state.WorkflowRun) error {
 	readyNodes := workflowDAG.GetReadyNodes()
 
 	for _, node := range readyNodes {
-		// Prepare inputs from completed dependencies
-		inputs, err := dag.PrepareNodeInputs(workflowDAG, node, run.Inputs)
+		// Nodes retried after a temporary failure wait out the retry delay
+		if time.Now().Before(node.RetryAt) {
+			continue
+		}
+
+		// Resolve inputs from completed dependencies
+		inputs, err := dag.ResolveStepInputs(workflowDAG, node, run.Inputs)
+		if err != nil {
+			log.Printf("Error resolving inputs for node %s: %v", node.ID, err)
+			sr.failNode(ctx, workflowDAG, node, run.ID, err.Error())
+			continue
+		}
+
+		// Skip steps whose when condition is false before touching their
+		// files
+		shouldRun, err := dag.EvaluateWhen(node, inputs)
 		if err != nil {
+			log.Printf("Error evaluating condition for node %s: %v", node.ID, err)
+			sr.failNode(ctx, workflowDAG, node, run.ID, err.Error())
+			continue
+		}
+		if !shouldRun {
+			sr.skipNode(ctx, workflowDAG, node, run.ID)
+			continue
+		}
+
+		// Load the secondary files, listings and contents the tool needs
+		if err := dag.PrepareToolInputs(node.Tool, inputs); err != nil {
 			log.Printf("Error preparing inputs for node %s: %v", node.ID, err)
+			sr.failNode(ctx, workflowDAG, node, run.ID, err.Error())
 			continue
 		}
 		node.Inputs = inputs
 		node.Owner = run.Owner
 		node.OutputPath = run.OutputPath
 
+		// Subworkflow gate steps complete here; the steps inlined from the
+		// subworkflow do its work
+		if node.RunsSubworkflow() {
+			sr.completeSubworkflowStep(ctx, workflowDAG, node, run.ID)
+			continue
+		}
+
+		// ExpressionTools are evaluated here rather than submitted as tasks
+		if node.IsExpressionTool() {
+			sr.runExpressionTool(ctx, workflowDAG, node, run.ID)
+			continue
+		}
+
 		// Execute the node
 		if err := sr.executor.Execute(ctx, node); err != nil {
 			log.Printf("Error executing node %s: %v", node.ID, err)
@@ -313,6 +407,168 @@ func (sr *SchedulerRunner) scheduleReadyNodes(ctx context.Context, workflowDAG *
 	return sr.store.UpdateWorkflowRunDAGState(ctx, run.ID, dagState)
 }
 
+// checkRunningNodes polls the executor for the status of every running
+// node, recording the outputs of completed ones. A node that failed
+// temporarily goes back to ready, to be resubmitted once the retry delay
+// has passed; any other failure fails it.
+func (sr *SchedulerRunner) checkRunningNodes(ctx context.Context, workflowDAG *dag.DAG, runID string) {
+	for _, node := range workflowDAG.Nodes {
+		taskID := node.GetTaskID()
+		if node.GetStatus() != dag.StatusRunning || taskID == "" {
+			continue
+		}
+
+		status, err := sr.executor.GetStatus(ctx, taskID)
+		if err != nil {
+			log.Printf("Error getting status of node %s: %v", node.ID, err)
+			continue
+		}
+
+		switch status {
+		case dag.StatusCompleted:
+			outputs, err := sr.executor.GetOutputs(ctx, taskID)
+			if err != nil {
+				sr.failNode(ctx, workflowDAG, node, runID, fmt.Sprintf("failed to get outputs: %v", err))
+				continue
+			}
+			node.SetOutputs(outputs)
+			workflowDAG.UpdateNodeStatus(node.ID, dag.StatusCompleted)
+			sr.updateStepExecution(ctx, runID, node, &state.StepExecutionUpdate{
+				Status:       state.StepCompleted,
+				Outputs:      outputs,
+				SetCompleted: true,
+			})
+
+		case dag.StatusFailed:
+			if sr.retryNode(ctx, node, taskID) {
+				log.Printf("Retrying node %s after temporary failure (%d of %d)", node.ID, node.Retries, sr.config.Executor.MaxRetries)
+				workflowDAG.UpdateNodeStatus(node.ID, dag.StatusReady)
+				sr.updateStepExecution(ctx, runID, node, &state.StepExecutionUpdate{
+					Status:    state.StepPending,
+					IncrRetry: true,
+				})
+				continue
+			}
+			sr.failNode(ctx, workflowDAG, node, runID, fmt.Sprintf("task %s failed", taskID))
+		}
+	}
+}
+
+// retryNode prepares a failed node for another attempt if its task failed
+// temporarily and it has retries left, reporting whether it will be retried.
+func (sr *SchedulerRunner) retryNode(ctx context.Context, node *dag.Node, taskID string) bool {
+	if node.Retries >= sr.config.Executor.MaxRetries {
+		return false
+	}
+	classifier, ok := sr.executor.(dag.FailureClassifier)
+	if !ok {
+		return false
+	}
+	temporary, err := classifier.IsTemporaryFailure(ctx, taskID)
+	if err != nil {
+		log.Printf("Error classifying failure of node %s: %v", node.ID, err)
+		return false
+	}
+	if !temporary {
+		return false
+	}
+
+	node.Retries++
+	node.RetryAt = time.Now().Add(sr.config.Executor.RetryDelay)
+	node.SetTaskID("")
+	return true
+}
+
+// failNode marks a node failed with message, on both the DAG and its step
+// execution.
+func (sr *SchedulerRunner) failNode(ctx context.Context, workflowDAG *dag.DAG, node *dag.Node, runID, message string) {
+	node.SetError(message)
+	workflowDAG.UpdateNodeStatus(node.ID, dag.StatusFailed)
+	sr.updateStepExecution(ctx, runID, node, &state.StepExecutionUpdate{
+		Status:       state.StepFailed,
+		ErrorMessage: message,
+		SetCompleted: true,
+	})
+}
+
+// runExpressionTool evaluates an ExpressionTool node in-process and records
+// the result on both the DAG and its step execution.
+func (sr *SchedulerRunner) runExpressionTool(ctx context.Context, workflowDAG *dag.DAG, node *dag.Node, runID string) {
+	update := &state.StepExecutionUpdate{
+		SetStarted:   true,
+		SetCompleted: true,
+	}
+
+	outputs, err := sr.exprRunner.Run(ctx, node)
+	if err != nil {
+		log.Printf("Error evaluating ExpressionTool node %s: %v", node.ID, err)
+		node.SetError(err.Error())
+		workflowDAG.UpdateNodeStatus(node.ID, dag.StatusFailed)
+		update.Status = state.StepFailed
+		update.ErrorMessage = err.Error()
+	} else {
+		node.SetOutputs(outputs)
+		workflowDAG.UpdateNodeStatus(node.ID, dag.StatusCompleted)
+		update.Status = state.StepCompleted
+		update.Outputs = outputs
+	}
+
+	sr.updateStepExecution(ctx, runID, node, update)
+}
+
+// completeSubworkflowStep completes the gate node of a subworkflow step
+// with its resolved inputs as outputs, on both the DAG and its step
+// execution.
+func (sr *SchedulerRunner) completeSubworkflowStep(ctx context.Context, workflowDAG *dag.DAG, node *dag.Node, runID string) {
+	outputs := dag.SubworkflowOutputs(node, node.Inputs)
+	node.SetOutputs(outputs)
+	workflowDAG.UpdateNodeStatus(node.ID, dag.StatusCompleted)
+	sr.updateStepExecution(ctx, runID, node, &state.StepExecutionUpdate{
+		Status:       state.StepCompleted,
+		Outputs:      outputs,
+		SetStarted:   true,
+		SetCompleted: true,
+	})
+}
+
+// skipNode marks a node whose when condition is false as skipped, with
+// null outputs, on both the DAG and its step execution. Skipping a
+// subworkflow gate node skips the steps inlined from it too.
+func (sr *SchedulerRunner) skipNode(ctx context.Context, workflowDAG *dag.DAG, node *dag.Node, runID string) {
+	if err := workflowDAG.SkipNode(node.ID); err != nil {
+		log.Printf("Error skipping node %s: %v", node.ID, err)
+		return
+	}
+
+	skipped := []*dag.Node{node}
+	if node.RunsSubworkflow() {
+		for _, inner := range workflowDAG.Nodes {
+			if strings.HasPrefix(inner.StepID, node.StepID+dag.SubworkflowSeparator) {
+				skipped = append(skipped, inner)
+			}
+		}
+	}
+	for _, n := range skipped {
+		sr.updateStepExecution(ctx, runID, n, &state.StepExecutionUpdate{
+			Status:       state.StepSkipped,
+			Outputs:      n.Outputs,
+			SetCompleted: true,
+		})
+	}
+}
+
+// updateStepExecution applies an update to the step execution for a node.
+func (sr *SchedulerRunner) updateStepExecution(ctx context.Context, runID string, node *dag.Node, update *state.StepExecutionUpdate) {
+	stepExec, err := sr.store.GetStepExecutionByStep(ctx, runID, node.StepID, node.ScatterIndex)
+	if err != nil || stepExec == nil {
+		log.Printf("Error finding step execution for node %s: %v", node.ID, err)
+		return
+	}
+	if err := sr.store.UpdateStepExecution(ctx, stepExec.ID, update); err != nil {
+		log.Printf("Error updating step execution for node %s: %v", node.ID, err)
+	}
+}
+
 // serializeDAG serializes a DAG to state.
 func serializeDAG(d *dag.DAG) *state.DAGState {
 	dagState := &state.DAGState{
@@ -329,6 +585,8 @@ func serializeDAG(d *dag.DAG) *state.DAGState {
 			Inputs:       node.Inputs,
 			Outputs:      node.Outputs,
 			Error:        node.Error,
+			Retries:      node.Retries,
+			RetryAt:      node.RetryAt,
 		}
 	}
 
@@ -343,6 +601,8 @@ func restoreDAG(d *dag.DAG, dagState *state.DAGState) {
 			node.SetTaskID(nodeState.TaskID)
 			node.Outputs = nodeState.Outputs
 			node.Error = nodeState.Error
+			node.Retries = nodeState.Retries
+			node.RetryAt = nodeState.RetryAt
 		}
 	}
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BV-BRC/cwe-cwl/internal/config"
+	"github.com/BV-BRC/cwe-cwl/internal/dag"
+)
+
+// failingExecutor reports every task failed, temporarily or not.
+type failingExecutor struct {
+	temporary bool
+}
+
+func (e *failingExecutor) Execute(ctx context.Context, node *dag.Node) error { return nil }
+func (e *failingExecutor) GetStatus(ctx context.Context, taskID string) (dag.NodeStatus, error) {
+	return dag.StatusFailed, nil
+}
+func (e *failingExecutor) GetOutputs(ctx context.Context, taskID string) (map[string]interface{}, error) {
+	return nil, nil
+}
+func (e *failingExecutor) Cancel(ctx context.Context, taskID string) error { return nil }
+func (e *failingExecutor) IsTemporaryFailure(ctx context.Context, taskID string) (bool, error) {
+	return e.temporary, nil
+}
+
+func TestSchedulerRunner_RetryNode(t *testing.T) {
+	cfg := &config.Config{Executor: config.ExecutorConfig{MaxRetries: 2, RetryDelay: time.Minute}}
+	sr := &SchedulerRunner{config: cfg, executor: &failingExecutor{temporary: true}}
+	node := &dag.Node{ID: "align"}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		node.SetTaskID("task")
+		if !sr.retryNode(context.Background(), node, "task") {
+			t.Fatalf("Expected retry %d", attempt)
+		}
+		if node.Retries != attempt || node.GetTaskID() != "" || !node.RetryAt.After(time.Now()) {
+			t.Errorf("Unexpected node after retry %d: retries=%d task=%q retryAt=%v", attempt, node.Retries, node.GetTaskID(), node.RetryAt)
+		}
+	}
+	if sr.retryNode(context.Background(), node, "task") {
+		t.Error("Expected no retry once max_retries is reached")
+	}
+
+	sr.executor = &failingExecutor{temporary: false}
+	if sr.retryNode(context.Background(), &dag.Node{ID: "align"}, "task") {
+		t.Error("Expected no retry for a permanent failure")
+	}
+}
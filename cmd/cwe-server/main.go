@@ -14,10 +14,15 @@ import (
 
 	"github.com/BV-BRC/cwe-cwl/internal/api"
 	"github.com/BV-BRC/cwe-cwl/internal/config"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
 	"github.com/BV-BRC/cwe-cwl/internal/state"
 )
 
 func main() {
+	// The expression sandbox starts this binary as its worker processes
+	sandbox.RunWorkerIfRequested()
+
 	configPath := flag.String("config", "", "Path to configuration file")
 	devMode := flag.Bool("dev", false, "Enable development mode (no auth, in-memory store)")
 	port := flag.Int("port", 8080, "Server port")
@@ -58,6 +63,15 @@ func main() {
 	}()
 	log.Printf("Connected to MongoDB: %s", cfg.MongoDB.Database)
 
+	// Run the expressions the API evaluates, such as input format
+	// expressions, in the configured sandbox
+	evaluator, err := sandbox.NewEvaluator(cfg.Sandbox)
+	if err != nil {
+		log.Fatalf("Failed to create expression evaluator: %v", err)
+	}
+	defer evaluator.Close()
+	cwl.SetDefaultSandbox(evaluator, cfg.Sandbox.Timeout())
+
 	// Create server
 	server := api.NewServer(cfg, store)
 
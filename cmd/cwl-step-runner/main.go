@@ -10,15 +10,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/BV-BRC/cwe-cwl/internal/config"
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/process"
+	"github.com/BV-BRC/cwe-cwl/internal/staging"
 )
 
 // StepParams are the parameters passed to cwl-step-runner.
@@ -47,21 +54,69 @@ type StepParams struct {
 	// WorkDir is the working directory.
 	WorkDir string `json:"cwl_workdir,omitempty"`
 
+	// InitialWorkDir lists files to create in the working directory.
+	InitialWorkDir []cwl.WorkDirEntry `json:"cwl_initial_workdir,omitempty"`
+
+	// Runtime is the runtime object for output expressions. Its paths, like
+	// those in the command, are placeholders for the directories chosen here.
+	Runtime map[string]interface{} `json:"cwl_runtime,omitempty"`
+
+	// ExpressionLib is loaded before evaluating output expressions.
+	ExpressionLib []string `json:"cwl_expression_lib,omitempty"`
+
+	// TimeLimit is the ToolTimeLimit in seconds; 0 means no limit.
+	TimeLimit int `json:"cwl_time_limit,omitempty"`
+
+	// Exit codes that mean success, temporary failure and permanent
+	// failure. By default only 0 succeeds.
+	SuccessCodes       []int `json:"cwl_success_codes,omitempty"`
+	TemporaryFailCodes []int `json:"cwl_temporary_fail_codes,omitempty"`
+	PermanentFailCodes []int `json:"cwl_permanent_fail_codes,omitempty"`
+
 	// StepID for logging.
 	StepID string `json:"cwl_step_id,omitempty"`
 
 	// NodeID for logging.
 	NodeID string `json:"cwl_node_id,omitempty"`
+
+	// Storage locates the backends inputs are staged from and outputs
+	// uploaded to.
+	Storage StorageParams `json:"cwl_storage,omitempty"`
+
+	// OutputPath is where outputs are uploaded. When empty they are left
+	// in the working directory.
+	OutputPath string `json:"cwl_output_path,omitempty"`
+}
+
+// StorageParams configure the step runner's stager.
+type StorageParams struct {
+	LocalPath    string `json:"local_path,omitempty"`
+	WorkspaceURL string `json:"workspace_url,omitempty"`
+	ShockURL     string `json:"shock_url,omitempty"`
 }
 
 // OutputBinding specifies how to collect an output.
 type OutputBinding struct {
-	ID           string `json:"id"`
-	Type         string `json:"type"`
-	Glob         string `json:"glob,omitempty"`
-	LoadContents bool   `json:"loadContents,omitempty"`
-	LoadListing  string `json:"loadListing,omitempty"`
-	OutputEval   string `json:"outputEval,omitempty"`
+	ID           string      `json:"id"`
+	Type         string      `json:"type"`
+	Glob         interface{} `json:"glob,omitempty"` // string or list of strings
+	LoadContents bool        `json:"loadContents,omitempty"`
+	LoadListing  string      `json:"loadListing,omitempty"`
+	OutputEval   string      `json:"outputEval,omitempty"`
+
+	// Format is the format IRI, or an expression for one, recorded on
+	// each collected File.
+	Format string `json:"format,omitempty"`
+
+	// Checksum adds a sha1 checksum to each collected File.
+	Checksum bool `json:"checksum,omitempty"`
+
+	// SecondaryFiles are collected alongside each matched file.
+	SecondaryFiles []cwl.SecondaryFileSpec `json:"secondaryFiles,omitempty"`
+
+	// Fields are the bindings of a record output's fields; the record is
+	// collected field by field into an object.
+	Fields []OutputBinding `json:"fields,omitempty"`
 }
 
 // StepResult is written to cwl_outputs.json.
@@ -70,6 +125,9 @@ type StepResult struct {
 	ExitCode int                    `json:"exit_code"`
 	Outputs  map[string]interface{} `json:"outputs"`
 	Error    string                 `json:"error,omitempty"`
+	// Reason classifies a failure: "timeout" when the time limit expired,
+	// otherwise "temporary_failure" or "permanent_failure" by exit code.
+	Reason string `json:"reason,omitempty"`
 }
 
 func main() {
@@ -92,19 +150,69 @@ func main() {
 		workDir, _ = os.Getwd()
 	}
 
+	// Create the temporary directory and substitute both into the command
+	tmpDir, err := os.MkdirTemp("", "cwl-tmp-")
+	if err != nil {
+		writeError(fmt.Sprintf("failed to create temporary directory: %v", err))
+		os.Exit(1)
+	}
+	resolveRuntimePaths(params, workDir, tmpDir)
+
+	// Stage the Files and Directories the scheduler placed in the working
+	// directory
+	stager := newStager(params.Storage)
+	inputs, err := stager.StageInputs(context.Background(), params.Inputs, workDir)
+	if err != nil {
+		writeError(fmt.Sprintf("failed to stage inputs: %v", err))
+		os.Exit(1)
+	}
+	params.Inputs = inputs
+
+	// Stage InitialWorkDirRequirement entries
+	if err := staging.StageWorkDir(workDir, params.InitialWorkDir); err != nil {
+		writeError(fmt.Sprintf("failed to stage working directory: %v", err))
+		os.Exit(1)
+	}
+
 	// Execute the command
 	exitCode, err := executeCommand(params, workDir)
+	os.RemoveAll(tmpDir)
 	if err != nil {
-		writeResult(StepResult{
+		result := StepResult{
 			Status:   "failed",
 			ExitCode: exitCode,
 			Error:    err.Error(),
-		})
+		}
+		if errors.Is(err, process.ErrTimeLimit) {
+			result.Reason = "timeout"
+		}
+		writeResult(result)
 		os.Exit(exitCode)
 	}
 
+	// Interpret the exit code; temporary failures exit with
+	// cwl.TemporaryFailExitCode so the scheduler retries them
+	switch cwl.ClassifyExitCode(exitCode, params.SuccessCodes, params.TemporaryFailCodes, params.PermanentFailCodes) {
+	case cwl.ExitTemporaryFailure:
+		writeResult(StepResult{
+			Status:   "failed",
+			ExitCode: exitCode,
+			Error:    fmt.Sprintf("command failed with temporary failure code %d", exitCode),
+			Reason:   "temporary_failure",
+		})
+		os.Exit(cwl.TemporaryFailExitCode)
+	case cwl.ExitPermanentFailure:
+		writeResult(StepResult{
+			Status:   "failed",
+			ExitCode: exitCode,
+			Error:    fmt.Sprintf("command failed with exit code %d", exitCode),
+			Reason:   "permanent_failure",
+		})
+		os.Exit(permanentFailExitStatus(exitCode))
+	}
+
 	// Collect outputs
-	outputs, err := collectOutputs(params.Outputs, params.Inputs, workDir)
+	outputs, err := collectOutputs(params.Outputs, outputEvaluator(params, exitCode), workDir)
 	if err != nil {
 		writeResult(StepResult{
 			Status:   "failed",
@@ -114,6 +222,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Upload outputs
+	if params.OutputPath != "" {
+		outputs, err = uploadOutputs(context.Background(), stager, outputs, workDir, params.OutputPath)
+		if err != nil {
+			writeResult(StepResult{
+				Status:   "failed",
+				ExitCode: exitCode,
+				Error:    fmt.Sprintf("failed to upload outputs: %v", err),
+			})
+			os.Exit(1)
+		}
+	}
+
 	// Write success result
 	writeResult(StepResult{
 		Status:   "completed",
@@ -146,6 +267,12 @@ func loadParams() (*StepParams, error) {
 		}
 	}
 
+	if runtimeJSON := os.Getenv("CWL_RUNTIME"); runtimeJSON != "" {
+		if err := json.Unmarshal([]byte(runtimeJSON), &params.Runtime); err != nil {
+			return nil, fmt.Errorf("failed to parse CWL_RUNTIME: %w", err)
+		}
+	}
+
 	if inputsJSON := os.Getenv("CWL_INPUTS"); inputsJSON != "" {
 		if err := json.Unmarshal([]byte(inputsJSON), &params.Inputs); err != nil {
 			return nil, fmt.Errorf("failed to parse CWL_INPUTS: %w", err)
@@ -168,12 +295,112 @@ func loadParams() (*StepParams, error) {
 	params.Stdout = os.Getenv("CWL_STDOUT")
 	params.Stderr = os.Getenv("CWL_STDERR")
 	params.WorkDir = os.Getenv("CWL_WORKDIR")
+	if limitStr := os.Getenv("CWL_TIME_LIMIT"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CWL_TIME_LIMIT: %w", err)
+		}
+		params.TimeLimit = limit
+	}
 	params.StepID = os.Getenv("CWL_STEP_ID")
 	params.NodeID = os.Getenv("CWL_NODE_ID")
 
 	return &params, nil
 }
 
+// newStager creates the stager for inputs and outputs, authenticated with
+// the task's token.
+func newStager(storage StorageParams) *staging.Stager {
+	stager := staging.NewStager(&config.StorageConfig{
+		LocalPath:    storage.LocalPath,
+		WorkspaceURL: storage.WorkspaceURL,
+		ShockURL:     storage.ShockURL,
+	})
+	stager.SetToken(os.Getenv("P3_AUTH_TOKEN"))
+	return stager
+}
+
+// resolveValuePaths applies resolve to every string in an input value.
+func resolveValuePaths(value interface{}, resolve func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return resolve(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = resolveValuePaths(item, resolve)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = resolveValuePaths(item, resolve)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// permanentFailExitStatus returns the status to exit with after a
+// permanent failure: the tool's own, unless it would read as success or as
+// a temporary failure.
+func permanentFailExitStatus(exitCode int) int {
+	if exitCode == 0 || exitCode == cwl.TemporaryFailExitCode {
+		return 1
+	}
+	return exitCode
+}
+
+// resolveRuntimePaths replaces the runtime.outdir and runtime.tmpdir
+// placeholders in the parameters with the step's directories.
+func resolveRuntimePaths(params *StepParams, outdir, tmpdir string) {
+	resolve := func(s string) string {
+		return cwl.ReplaceRuntimePaths(s, outdir, tmpdir)
+	}
+
+	for i, arg := range params.Command {
+		params.Command[i] = resolve(arg)
+	}
+	for k, v := range params.Environment {
+		params.Environment[k] = resolve(v)
+	}
+	params.Inputs, _ = resolveValuePaths(params.Inputs, resolve).(map[string]interface{})
+	params.Stdin = resolve(params.Stdin)
+	params.Stdout = resolve(params.Stdout)
+	params.Stderr = resolve(params.Stderr)
+	for i := range params.InitialWorkDir {
+		entry := &params.InitialWorkDir[i]
+		entry.Entryname = resolve(entry.Entryname)
+		if entry.Contents != nil {
+			contents := resolve(*entry.Contents)
+			entry.Contents = &contents
+		}
+	}
+
+	if params.Runtime == nil {
+		params.Runtime = cwl.DefaultResources().RuntimeContext(outdir, tmpdir)
+	}
+	params.Runtime["outdir"] = outdir
+	params.Runtime["tmpdir"] = tmpdir
+}
+
+// outputEvaluator returns the evaluator for output expressions, which see
+// the step's inputs and its runtime including the command's exit code.
+func outputEvaluator(params *StepParams, exitCode int) *cwl.ExpressionEvaluator {
+	runtime := make(map[string]interface{}, len(params.Runtime)+1)
+	for k, v := range params.Runtime {
+		runtime[k] = v
+	}
+	runtime["exitCode"] = exitCode
+
+	evaluator := cwl.NewExpressionEvaluator()
+	evaluator.SetInputs(params.Inputs)
+	evaluator.SetRuntime(runtime)
+	evaluator.SetExpressionLib(params.ExpressionLib)
+	return evaluator
+}
+
 // executeCommand runs the CWL command.
 func executeCommand(params *StepParams, workDir string) (int, error) {
 	cmd := exec.Command(params.Command[0], params.Command[1:]...)
@@ -223,8 +450,8 @@ func executeCommand(params *StepParams, workDir string) (int, error) {
 		cmd.Stderr = os.Stderr
 	}
 
-	// Run the command
-	err := cmd.Run()
+	// Run the command, killing it if it exceeds the time limit
+	err := process.Run(cmd, time.Duration(params.TimeLimit)*time.Second)
 	exitCode := 0
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -238,11 +465,11 @@ func executeCommand(params *StepParams, workDir string) (int, error) {
 }
 
 // collectOutputs collects outputs according to CWL output bindings.
-func collectOutputs(bindings []OutputBinding, inputs map[string]interface{}, workDir string) (map[string]interface{}, error) {
+func collectOutputs(bindings []OutputBinding, evaluator *cwl.ExpressionEvaluator, workDir string) (map[string]interface{}, error) {
 	outputs := make(map[string]interface{})
 
 	for _, binding := range bindings {
-		value, err := collectOutput(binding, inputs, workDir)
+		value, err := collectOutput(binding, evaluator, workDir)
 		if err != nil {
 			return nil, fmt.Errorf("failed to collect output %s: %w", binding.ID, err)
 		}
@@ -253,43 +480,71 @@ func collectOutputs(bindings []OutputBinding, inputs map[string]interface{}, wor
 }
 
 // collectOutput collects a single output.
-func collectOutput(binding OutputBinding, inputs map[string]interface{}, workDir string) (interface{}, error) {
-	if binding.Glob == "" {
+func collectOutput(binding OutputBinding, evaluator *cwl.ExpressionEvaluator, workDir string) (interface{}, error) {
+	if len(binding.Fields) > 0 {
+		return collectOutputs(binding.Fields, evaluator, workDir)
+	}
+	if binding.Glob == nil && binding.OutputEval == "" {
 		return nil, nil
 	}
 
-	// Evaluate glob pattern (may contain expressions)
-	globPattern := binding.Glob
-	if strings.Contains(globPattern, "$(") || strings.Contains(globPattern, "${") {
-		// Evaluate expression
-		evaluator := cwl.NewExpressionEvaluator()
-		evaluator.SetInputs(inputs)
-		result, err := evaluator.Evaluate(globPattern)
+	// Build file objects for the glob matches
+	files := []interface{}{}
+	if binding.Glob != nil {
+		evaluator.SetSelf(nil)
+		format, err := evaluator.EvaluateFormat(binding.Format)
 		if err != nil {
-			return nil, fmt.Errorf("failed to evaluate glob expression: %w", err)
+			return nil, fmt.Errorf("failed to evaluate format: %w", err)
+		}
+		opts := cwl.FileObjectOptions{
+			Checksum:     binding.Checksum,
+			LoadContents: binding.LoadContents,
+			LoadListing:  binding.LoadListing,
+			Format:       format,
 		}
-		globPattern = fmt.Sprintf("%v", result)
-	}
 
-	// Find matching files
-	pattern := filepath.Join(workDir, globPattern)
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("invalid glob pattern: %w", err)
+		// Evaluate glob patterns (may contain expressions)
+		patterns, err := evaluator.EvaluateGlob(binding.Glob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate glob: %w", err)
+		}
+
+		for _, pattern := range patterns {
+			// Find matching files
+			matches, err := filepath.Glob(filepath.Join(workDir, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob pattern: %w", err)
+			}
+
+			for _, match := range matches {
+				fileObj, err := buildFileObject(match, opts, workDir)
+				if err != nil {
+					return nil, err
+				}
+				if len(binding.SecondaryFiles) > 0 && fileObj["class"] == cwl.TypeFile {
+					fileObj, err = collectSecondaryFiles(fileObj, binding.SecondaryFiles, evaluator, workDir, binding.Checksum)
+					if err != nil {
+						return nil, err
+					}
+				}
+				files = append(files, fileObj)
+			}
+		}
 	}
 
-	// Build file objects
-	var files []interface{}
-	for _, match := range matches {
-		fileObj, err := buildFileObject(match, binding.LoadContents, binding.LoadListing, workDir)
+	// outputEval sees the matched files as self
+	if binding.OutputEval != "" {
+		evaluator.SetSelf(files)
+		value, err := evaluator.Evaluate(binding.OutputEval)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to evaluate outputEval: %w", err)
 		}
-		files = append(files, fileObj)
+		return value, nil
 	}
 
 	// Return based on type
-	if binding.Type == "File" {
+	switch strings.TrimSuffix(binding.Type, "?") {
+	case cwl.TypeFile, cwl.TypeDirectory:
 		if len(files) == 0 {
 			return nil, nil
 		}
@@ -300,106 +555,115 @@ func collectOutput(binding OutputBinding, inputs map[string]interface{}, workDir
 	return files, nil
 }
 
-// buildFileObject creates a CWL File object.
-func buildFileObject(path string, loadContents bool, loadListing string, workDir string) (map[string]interface{}, error) {
-	info, err := os.Stat(path)
+// collectSecondaryFiles attaches the secondary files that exist next to an
+// output file. Output secondary files are optional unless marked required.
+func collectSecondaryFiles(fileObj map[string]interface{}, specs []cwl.SecondaryFileSpec, evaluator *cwl.ExpressionEvaluator, workDir string, checksum bool) (map[string]interface{}, error) {
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	attached, err := cwl.AttachSecondaryFiles(evaluator, fileObj, specs, false, exists)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get path relative to workDir for location
-	relPath, _ := filepath.Rel(workDir, path)
-
-	obj := map[string]interface{}{
-		"class":    "File",
-		"location": relPath,
-		"path":     path,
-		"basename": filepath.Base(path),
-		"size":     info.Size(),
+	// Describe each secondary file as fully as its primary
+	secondaries, _ := attached["secondaryFiles"].([]interface{})
+	for i, sf := range secondaries {
+		sfMap, ok := sf.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path, _ := sfMap["path"].(string)
+		sfObj, err := buildFileObject(path, cwl.FileObjectOptions{Checksum: checksum}, workDir)
+		if err != nil {
+			return nil, err
+		}
+		secondaries[i] = sfObj
 	}
 
-	// Extract nameroot and nameext
-	base := filepath.Base(path)
-	ext := filepath.Ext(base)
-	obj["nameext"] = ext
-	obj["nameroot"] = strings.TrimSuffix(base, ext)
+	return attached, nil
+}
 
-	// Load contents if requested
-	if loadContents {
-		// Only load if file is reasonably small (< 64KB per CWL spec)
-		if info.Size() <= 65536 {
-			content, err := os.ReadFile(path)
-			if err == nil {
-				obj["contents"] = string(content)
-			}
-		}
+// buildFileObject describes an output path as a CWL File or Directory
+// object, with locations relative to workDir.
+func buildFileObject(path string, opts cwl.FileObjectOptions, workDir string) (map[string]interface{}, error) {
+	obj, err := cwl.BuildFileObject(path, opts)
+	if err != nil {
+		return nil, err
 	}
+	relativizeLocations(obj, workDir)
+	return obj, nil
+}
 
-	// Handle directory listing
-	if info.IsDir() {
-		obj["class"] = "Directory"
-		delete(obj, "size")
-		delete(obj, "nameext")
-		delete(obj, "nameroot")
-
-		if loadListing == "shallow_listing" || loadListing == "deep_listing" {
-			listing, err := buildDirectoryListing(path, loadListing == "deep_listing", workDir)
-			if err == nil {
-				obj["listing"] = listing
-			}
+// relativizeLocations rewrites the locations of obj and its listing to be
+// relative to workDir.
+func relativizeLocations(obj map[string]interface{}, workDir string) {
+	if path, ok := obj["path"].(string); ok {
+		if rel, err := filepath.Rel(workDir, path); err == nil {
+			obj["location"] = rel
+		}
+	}
+	listing, _ := obj["listing"].([]interface{})
+	for _, item := range listing {
+		if entry, ok := item.(map[string]interface{}); ok {
+			relativizeLocations(entry, workDir)
 		}
 	}
-
-	return obj, nil
 }
 
-// buildDirectoryListing creates a listing of directory contents.
-func buildDirectoryListing(dirPath string, deep bool, workDir string) ([]interface{}, error) {
-	entries, err := os.ReadDir(dirPath)
+// uploadOutputs uploads the Files and Directories among outputs to
+// outputPath, keeping their layout relative to workDir, and returns a copy
+// of outputs pointing at the uploaded copies.
+func uploadOutputs(ctx context.Context, stager *staging.Stager, outputs map[string]interface{}, workDir, outputPath string) (map[string]interface{}, error) {
+	ref, err := stager.ParseFileRef(outputPath)
 	if err != nil {
 		return nil, err
 	}
 
-	var listing []interface{}
-	for _, entry := range entries {
-		entryPath := filepath.Join(dirPath, entry.Name())
-		info, err := entry.Info()
+	uploaded := make(map[string]interface{}, len(outputs))
+	for id, value := range outputs {
+		v, err := uploadOutput(ctx, stager, value, workDir, ref)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("output %s: %w", id, err)
 		}
+		uploaded[id] = v
+	}
+	return uploaded, nil
+}
 
-		relPath, _ := filepath.Rel(workDir, entryPath)
+func uploadOutput(ctx context.Context, stager *staging.Stager, value interface{}, workDir string, target *staging.FileRef) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		class, _ := v["class"].(string)
+		if class != cwl.TypeFile && class != cwl.TypeDirectory {
+			// A record output
+			return uploadOutputs(ctx, stager, v, workDir, target.Path)
+		}
 
-		if entry.IsDir() {
-			dirObj := map[string]interface{}{
-				"class":    "Directory",
-				"location": relPath,
-				"path":     entryPath,
-				"basename": entry.Name(),
-			}
-			if deep {
-				subListing, err := buildDirectoryListing(entryPath, true, workDir)
-				if err == nil {
-					dirObj["listing"] = subListing
-				}
-			}
-			listing = append(listing, dirObj)
-		} else {
-			fileObj := map[string]interface{}{
-				"class":    "File",
-				"location": relPath,
-				"path":     entryPath,
-				"basename": entry.Name(),
-				"size":     info.Size(),
+		path, _ := v["path"].(string)
+		rel, err := filepath.Rel(workDir, filepath.Dir(path))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			// Outside the working directory, e.g. an input passed through
+			return value, nil
+		}
+		targetDir := target.Path
+		if rel != "." {
+			targetDir = strings.TrimSuffix(targetDir, "/") + "/" + filepath.ToSlash(rel)
+		}
+		return stager.UploadFile(ctx, v, target.Backend, targetDir)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			uploaded, err := uploadOutput(ctx, stager, item, workDir, target)
+			if err != nil {
+				return nil, err
 			}
-			ext := filepath.Ext(entry.Name())
-			fileObj["nameext"] = ext
-			fileObj["nameroot"] = strings.TrimSuffix(entry.Name(), ext)
-			listing = append(listing, fileObj)
+			items[i] = uploaded
 		}
+		return items, nil
 	}
-
-	return listing, nil
+	return value, nil
 }
 
 // writeResult writes the step result to cwl_outputs.json.
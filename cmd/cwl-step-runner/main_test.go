@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/process"
 )
 
 func TestLoadParamsFromFile(t *testing.T) {
@@ -100,6 +106,31 @@ func TestExecuteCommand(t *testing.T) {
 	}
 }
 
+func TestExecuteCommandTimeLimit(t *testing.T) {
+	params := &StepParams{
+		Command:   []string{"sleep", "30"},
+		TimeLimit: 1,
+	}
+
+	_, err := executeCommand(params, t.TempDir())
+	if !errors.Is(err, process.ErrTimeLimit) {
+		t.Errorf("Expected time limit error, got %v", err)
+	}
+}
+
+func TestPermanentFailExitStatus(t *testing.T) {
+	tests := map[int]int{
+		0:                         1,
+		cwl.TemporaryFailExitCode: 1,
+		2:                         2,
+	}
+	for code, expected := range tests {
+		if got := permanentFailExitStatus(code); got != expected {
+			t.Errorf("permanentFailExitStatus(%d) = %d, want %d", code, got, expected)
+		}
+	}
+}
+
 func TestExecuteCommandWithStdin(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -165,7 +196,7 @@ func TestCollectOutputs(t *testing.T) {
 		{ID: "all_files", Type: "File[]", Glob: "*.*"},
 	}
 
-	outputs, err := collectOutputs(bindings, nil, tmpDir)
+	outputs, err := collectOutputs(bindings, cwl.NewExpressionEvaluator(), tmpDir)
 	if err != nil {
 		t.Fatalf("collectOutputs failed: %v", err)
 	}
@@ -189,6 +220,37 @@ func TestCollectOutputs(t *testing.T) {
 	}
 }
 
+func TestCollectOutputsArrayGlob(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"run.log", "stats.txt", "data.csv"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644)
+	}
+
+	// Bindings as the executors send them, with a list glob
+	var bindings []OutputBinding
+	data := `[{"id": "reports", "type": "File[]", "glob": ["*.txt", "*.$(inputs.ext)"]}]`
+	if err := json.Unmarshal([]byte(data), &bindings); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	evaluator := cwl.NewExpressionEvaluator()
+	evaluator.SetInputs(map[string]interface{}{"ext": "log"})
+	outputs, err := collectOutputs(bindings, evaluator, tmpDir)
+	if err != nil {
+		t.Fatalf("collectOutputs failed: %v", err)
+	}
+
+	reports, ok := outputs["reports"].([]interface{})
+	if !ok || len(reports) != 2 {
+		t.Fatalf("Expected 2 reports, got %v", outputs["reports"])
+	}
+	for i, name := range []string{"stats.txt", "run.log"} {
+		if basename := reports[i].(map[string]interface{})["basename"]; basename != name {
+			t.Errorf("Expected report %d to be %s, got %v", i, name, basename)
+		}
+	}
+}
+
 func TestCollectOutputWithLoadContents(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -202,7 +264,7 @@ func TestCollectOutputWithLoadContents(t *testing.T) {
 		LoadContents: true,
 	}
 
-	output, err := collectOutput(binding, nil, tmpDir)
+	output, err := collectOutput(binding, cwl.NewExpressionEvaluator(), tmpDir)
 	if err != nil {
 		t.Fatalf("collectOutput failed: %v", err)
 	}
@@ -213,12 +275,211 @@ func TestCollectOutputWithLoadContents(t *testing.T) {
 	}
 }
 
+func TestCollectOutputWithSecondaryFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(tmpDir, "sorted.bam"), []byte("bam"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "sorted.bam.bai"), []byte("bai"), 0644)
+
+	binding := OutputBinding{
+		ID:   "bam",
+		Type: "File",
+		Glob: "sorted.bam",
+		SecondaryFiles: []cwl.SecondaryFileSpec{
+			{Pattern: ".bai"},
+			{Pattern: "^.csi"},
+		},
+	}
+
+	output, err := collectOutput(binding, cwl.NewExpressionEvaluator(), tmpDir)
+	if err != nil {
+		t.Fatalf("collectOutput failed: %v", err)
+	}
+
+	fileObj := output.(map[string]interface{})
+	secondaries, ok := fileObj["secondaryFiles"].([]interface{})
+	if !ok || len(secondaries) != 1 {
+		t.Fatalf("Expected 1 secondary file, got %v", fileObj["secondaryFiles"])
+	}
+	if sf := secondaries[0].(map[string]interface{}); sf["basename"] != "sorted.bam.bai" || sf["size"] != int64(3) {
+		t.Errorf("Unexpected secondary file: %v", sf)
+	}
+}
+
+func TestCollectOutputWithOutputEval(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("b"), 0644)
+
+	params := &StepParams{Runtime: map[string]interface{}{"cores": 2}}
+	resolveRuntimePaths(params, tmpDir, "/tmp")
+	evaluator := outputEvaluator(params, 3)
+
+	count, err := collectOutput(OutputBinding{ID: "count", Type: "int", Glob: "*.txt", OutputEval: "$(self.length)"}, evaluator, tmpDir)
+	if err != nil {
+		t.Fatalf("collectOutput failed: %v", err)
+	}
+	if count != int64(2) {
+		t.Errorf("Expected 2 files, got %v", count)
+	}
+
+	code, err := collectOutput(OutputBinding{ID: "code", Type: "int", OutputEval: "$(runtime.exitCode)"}, evaluator, tmpDir)
+	if err != nil {
+		t.Fatalf("collectOutput failed: %v", err)
+	}
+	if code != int64(3) {
+		t.Errorf("Expected exitCode 3, got %v", code)
+	}
+}
+
+func TestCollectOutputRecord(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "s1.bam"), []byte("bam"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "s1.stats"), []byte("stats"), 0644)
+
+	binding := OutputBinding{
+		ID:   "sample",
+		Type: "record",
+		Fields: []OutputBinding{
+			{ID: "alignment", Type: "File", Glob: "*.bam"},
+			{ID: "name", Type: "string", Glob: "*.stats", OutputEval: "$(self[0].nameroot)"},
+		},
+	}
+
+	output, err := collectOutput(binding, cwl.NewExpressionEvaluator(), tmpDir)
+	if err != nil {
+		t.Fatalf("collectOutput failed: %v", err)
+	}
+
+	record, ok := output.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected a record, got %T", output)
+	}
+	alignment, ok := record["alignment"].(map[string]interface{})
+	if !ok || alignment["basename"] != "s1.bam" {
+		t.Errorf("Expected alignment s1.bam, got %v", record["alignment"])
+	}
+	if record["name"] != "s1" {
+		t.Errorf("Expected name s1, got %v", record["name"])
+	}
+}
+
+func TestStageInputsWithSecondaryFiles(t *testing.T) {
+	workDir := t.TempDir()
+	dataDir := t.TempDir()
+	bam := filepath.Join(dataDir, "sample.bam")
+	os.WriteFile(bam, []byte("bam"), 0644)
+	os.WriteFile(bam+".bai", []byte("bai"), 0644)
+
+	// The scheduler places the inputs under the outdir placeholder
+	params := &StepParams{
+		Command: []string{"test", "-f", cwl.OutdirPlaceholder + "/reads/sample.bam.bai"},
+		Inputs: cwl.PlaceInputs(map[string]interface{}{
+			"reads": map[string]interface{}{
+				"class":          "File",
+				"location":       bam,
+				"secondaryFiles": []interface{}{map[string]interface{}{"class": "File", "location": bam + ".bai"}},
+			},
+		}, cwl.OutdirPlaceholder),
+	}
+	resolveRuntimePaths(params, workDir, t.TempDir())
+
+	inputs, err := newStager(StorageParams{}).StageInputs(context.Background(), params.Inputs, workDir)
+	if err != nil {
+		t.Fatalf("StageInputs failed: %v", err)
+	}
+	if path := inputs["reads"].(map[string]interface{})["path"]; path != filepath.Join(workDir, "reads", "sample.bam") {
+		t.Errorf("Unexpected staged path %v", path)
+	}
+
+	exitCode, err := executeCommand(params, workDir)
+	if err != nil || exitCode != 0 {
+		t.Errorf("Expected the index next to the staged BAM, got exit code %d (err: %v)", exitCode, err)
+	}
+}
+
+func TestUploadOutputs(t *testing.T) {
+	workDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(workDir, "sorted.bam"), []byte("bam"), 0644)
+	os.WriteFile(filepath.Join(workDir, "sorted.bam.bai"), []byte("bai"), 0644)
+	os.MkdirAll(filepath.Join(workDir, "index", "db"), 0755)
+	os.WriteFile(filepath.Join(workDir, "index", "db", "db.fa"), []byte(">db"), 0644)
+
+	outputs, err := collectOutputs([]OutputBinding{
+		{ID: "bam", Type: "File", Glob: "sorted.bam", SecondaryFiles: []cwl.SecondaryFileSpec{{Pattern: ".bai"}}},
+		{ID: "db", Type: "Directory", Glob: "index/db"},
+	}, cwl.NewExpressionEvaluator(), workDir)
+	if err != nil {
+		t.Fatalf("collectOutputs failed: %v", err)
+	}
+	if _, ok := outputs["db"].(map[string]interface{}); !ok {
+		t.Fatalf("Expected a single Directory object, got %T", outputs["db"])
+	}
+
+	uploaded, err := uploadOutputs(context.Background(), newStager(StorageParams{}), outputs, workDir, outputDir)
+	if err != nil {
+		t.Fatalf("uploadOutputs failed: %v", err)
+	}
+
+	bam := uploaded["bam"].(map[string]interface{})
+	if bam["location"] != filepath.Join(outputDir, "sorted.bam") {
+		t.Errorf("Unexpected bam location %v", bam["location"])
+	}
+	sf := bam["secondaryFiles"].([]interface{})[0].(map[string]interface{})
+	if sf["location"] != filepath.Join(outputDir, "sorted.bam.bai") {
+		t.Errorf("Unexpected secondary file location %v", sf["location"])
+	}
+	db := uploaded["db"].(map[string]interface{})
+	if db["location"] != filepath.Join(outputDir, "index", "db") {
+		t.Errorf("Unexpected db location %v", db["location"])
+	}
+	for _, name := range []string{"sorted.bam", "sorted.bam.bai", "index/db/db.fa"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("Expected %s to be uploaded: %v", name, err)
+		}
+	}
+}
+
+func TestResolveRuntimePaths(t *testing.T) {
+	contents := "out=" + cwl.OutdirPlaceholder
+	params := &StepParams{
+		Command:        []string{"sort", "-T", cwl.TmpdirPlaceholder, "-o", cwl.OutdirPlaceholder + "/sorted.txt"},
+		Environment:    map[string]string{"HOME": cwl.OutdirPlaceholder},
+		InitialWorkDir: []cwl.WorkDirEntry{{Entryname: "config", Contents: &contents}},
+		Runtime:        cwl.DefaultResources().RuntimeContext(cwl.OutdirPlaceholder, cwl.TmpdirPlaceholder),
+		Inputs: map[string]interface{}{
+			"db": map[string]interface{}{"class": "Directory", "path": cwl.OutdirPlaceholder + "/db/db"},
+		},
+	}
+
+	resolveRuntimePaths(params, "/work", "/scratch")
+
+	if got := strings.Join(params.Command, " "); got != "sort -T /scratch -o /work/sorted.txt" {
+		t.Errorf("Unexpected command %q", got)
+	}
+	if params.Environment["HOME"] != "/work" {
+		t.Errorf("Unexpected HOME %q", params.Environment["HOME"])
+	}
+	if *params.InitialWorkDir[0].Contents != "out=/work" {
+		t.Errorf("Unexpected contents %q", *params.InitialWorkDir[0].Contents)
+	}
+	if params.Runtime["outdir"] != "/work" || params.Runtime["tmpdir"] != "/scratch" || params.Runtime["cores"] != 1 {
+		t.Errorf("Unexpected runtime %v", params.Runtime)
+	}
+	if db := params.Inputs["db"].(map[string]interface{}); db["path"] != "/work/db/db" {
+		t.Errorf("Unexpected input path %v", db["path"])
+	}
+}
+
 func TestBuildFileObject(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.data.txt")
 	os.WriteFile(testFile, []byte("test"), 0644)
 
-	obj, err := buildFileObject(testFile, false, "", tmpDir)
+	obj, err := buildFileObject(testFile, cwl.FileObjectOptions{Checksum: true, Format: "http://edamontology.org/format_2330"}, tmpDir)
 	if err != nil {
 		t.Fatalf("buildFileObject failed: %v", err)
 	}
@@ -238,6 +499,18 @@ func TestBuildFileObject(t *testing.T) {
 	if obj["size"].(int64) != 4 {
 		t.Errorf("Expected size 4, got %v", obj["size"])
 	}
+	if obj["dirname"] != tmpDir {
+		t.Errorf("Expected dirname %s, got %v", tmpDir, obj["dirname"])
+	}
+	if obj["location"] != "test.data.txt" {
+		t.Errorf("Expected location relative to the work directory, got %v", obj["location"])
+	}
+	if obj["checksum"] != "sha1$a94a8fe5ccb19ba61c4c0873d391e987982fbbd3" {
+		t.Errorf("Unexpected checksum %v", obj["checksum"])
+	}
+	if obj["format"] != "http://edamontology.org/format_2330" {
+		t.Errorf("Unexpected format %v", obj["format"])
+	}
 }
 
 func TestBuildDirectoryListing(t *testing.T) {
@@ -249,13 +522,17 @@ func TestBuildDirectoryListing(t *testing.T) {
 	os.WriteFile(filepath.Join(subDir, "file1.txt"), []byte("a"), 0644)
 	os.WriteFile(filepath.Join(subDir, "file2.txt"), []byte("bb"), 0644)
 
-	listing, err := buildDirectoryListing(subDir, false, tmpDir)
+	obj, err := buildFileObject(subDir, cwl.FileObjectOptions{LoadListing: cwl.ShallowListing}, tmpDir)
 	if err != nil {
-		t.Fatalf("buildDirectoryListing failed: %v", err)
+		t.Fatalf("buildFileObject failed: %v", err)
 	}
 
+	listing, _ := obj["listing"].([]interface{})
 	if len(listing) != 2 {
-		t.Errorf("Expected 2 entries, got %d", len(listing))
+		t.Fatalf("Expected 2 entries, got %d", len(listing))
+	}
+	if location := listing[0].(map[string]interface{})["location"]; location != "subdir/file1.txt" {
+		t.Errorf("Expected listing location relative to the work directory, got %v", location)
 	}
 }
 
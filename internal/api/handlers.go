@@ -14,6 +14,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"github.com/BV-BRC/cwe-cwl/internal/bvbrc"
 	"github.com/BV-BRC/cwe-cwl/internal/config"
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
 	"github.com/BV-BRC/cwe-cwl/internal/dag"
@@ -81,7 +82,7 @@ func (h *Handler) SubmitWorkflow(w http.ResponseWriter, r *http.Request) {
 
 		// Parse stored document
 		docBytes, _ := json.Marshal(workflow.Document)
-		doc, err = h.parser.ParseBytes(docBytes)
+		doc, err = h.parser.ParseBytesEntryPoint(docBytes, workflow.EntryPoint)
 		if err != nil {
 			h.errorResponse(w, "failed to parse stored workflow", http.StatusInternalServerError)
 			return
@@ -91,7 +92,7 @@ func (h *Handler) SubmitWorkflow(w http.ResponseWriter, r *http.Request) {
 		// Inline workflow document
 		docBytes, _ := json.Marshal(wf)
 		var err error
-		doc, err = h.parser.ParseBytes(docBytes)
+		doc, err = h.parser.ParseBytesEntryPoint(docBytes, req.EntryPoint)
 		if err != nil {
 			h.errorResponse(w, fmt.Sprintf("failed to parse workflow: %v", err), http.StatusBadRequest)
 			return
@@ -99,6 +100,13 @@ func (h *Handler) SubmitWorkflow(w http.ResponseWriter, r *http.Request) {
 
 		contentHash = cwl.ContentHash(docBytes)
 		workflowID = doc.ID
+		if _, packed := wf["$graph"]; packed {
+			// Graph IDs like "#main" are only unique within the document
+			workflowID = contentHash
+			if req.EntryPoint != "" {
+				workflowID += "#" + strings.TrimPrefix(req.EntryPoint, "#")
+			}
+		}
 		if workflowID == "" {
 			workflowID = contentHash
 		}
@@ -109,6 +117,7 @@ func (h *Handler) SubmitWorkflow(w http.ResponseWriter, r *http.Request) {
 			ContentHash: contentHash,
 			CWLVersion:  doc.CWLVersion,
 			Document:    wf,
+			EntryPoint:  req.EntryPoint,
 		}
 		if err := h.store.SaveWorkflow(ctx, storedWf); err != nil {
 			h.errorResponse(w, "failed to save workflow", http.StatusInternalServerError)
@@ -629,7 +638,7 @@ func (h *Handler) AdminRequeueStep(w http.ResponseWriter, r *http.Request) {
 
 	parser := cwl.NewParser()
 	docBytes, _ := json.Marshal(workflow.Document)
-	doc, err := parser.ParseBytes(docBytes)
+	doc, err := parser.ParseBytesEntryPoint(docBytes, workflow.EntryPoint)
 	if err != nil {
 		h.errorResponse(w, "failed to parse workflow", http.StatusInternalServerError)
 		return
@@ -711,7 +720,8 @@ func (h *Handler) AdminRequeueStep(w http.ResponseWriter, r *http.Request) {
 // ValidateCWL handles CWL document validation.
 func (h *Handler) ValidateCWL(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Document interface{} `json:"document"`
+		Document   interface{} `json:"document"`
+		EntryPoint string      `json:"entry_point,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -720,7 +730,7 @@ func (h *Handler) ValidateCWL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	docBytes, _ := json.Marshal(req.Document)
-	doc, err := h.parser.ParseBytes(docBytes)
+	doc, err := h.parser.ParseBytesEntryPoint(docBytes, req.EntryPoint)
 
 	result := state.ValidationResult{Valid: true}
 
@@ -748,6 +758,12 @@ func (h *Handler) ValidateInputs(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Inputs map[string]interface{} `json:"inputs"`
+
+		// Document, when given, is the tool the inputs are for; they are
+		// then also checked against its input types and formats.
+		Document      interface{} `json:"document,omitempty"`
+		EntryPoint    string      `json:"entry_point,omitempty"`
+		StrictFormats *bool       `json:"strict_formats,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -755,10 +771,27 @@ func (h *Handler) ValidateInputs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.validateInputFiles(ctx, user.Token, req.Inputs)
+	result := state.ValidationResult{Valid: true}
 
-	result := state.ValidationResult{Valid: err == nil}
-	if err != nil {
+	if req.Document != nil {
+		strict := h.config.Validation.StrictFormats
+		if req.StrictFormats != nil {
+			strict = *req.StrictFormats
+		}
+
+		docBytes, _ := json.Marshal(req.Document)
+		doc, err := h.parser.ParseBytesEntryPoint(docBytes, req.EntryPoint)
+		if err == nil {
+			err = bvbrc.ValidateInputs(doc, req.Inputs, strict)
+		}
+		if err != nil {
+			result.Valid = false
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+
+	if err := h.validateInputFiles(ctx, user.Token, req.Inputs); err != nil {
+		result.Valid = false
 		result.Errors = append(result.Errors, err.Error())
 	}
 
@@ -33,8 +33,8 @@ func NewCWLJobSpec(doc *cwl.Document, inputs map[string]interface{}, outputPath
 		return nil, fmt.Errorf("expected CommandLineTool, got %s", doc.Class)
 	}
 
-	// Validate required inputs are provided
-	if err := validateInputs(doc, inputs); err != nil {
+	// Validate required inputs, types and formats
+	if err := ValidateInputs(doc, inputs, true); err != nil {
 		return nil, err
 	}
 
@@ -45,17 +45,35 @@ func NewCWLJobSpec(doc *cwl.Document, inputs map[string]interface{}, outputPath
 	}, nil
 }
 
-// validateInputs checks that all required inputs are provided.
-func validateInputs(doc *cwl.Document, inputs map[string]interface{}) error {
+// ValidateInputs checks that all required inputs are provided, that
+// provided values match their types, resolving SchemaDefRequirement types,
+// and that File inputs match their declared formats. strictFormats also
+// rejects Files with no format or a format the bundled ontology can't place.
+func ValidateInputs(doc *cwl.Document, inputs map[string]interface{}, strictFormats bool) error {
+	defs := doc.SchemaDefs()
 	for _, input := range doc.Inputs {
-		_, provided := inputs[input.ID]
+		inputType, err := defs.ParseType(input.Type)
+		if err != nil {
+			return fmt.Errorf("input %s: %w", input.ID, err)
+		}
+
+		value, provided := inputs[input.ID]
 		if !provided {
 			// Check if optional or has default
 			if !isOptional(input) && input.Default == nil {
 				return fmt.Errorf("missing required input: %s", input.ID)
 			}
+			continue
+		}
+
+		if err := inputType.Validate(value); err != nil {
+			return fmt.Errorf("invalid value for input %s: %w", input.ID, err)
 		}
 	}
+
+	if err := doc.ValidateInputFormats(inputs, strictFormats); err != nil {
+		return fmt.Errorf("invalid format: %w", err)
+	}
 	return nil
 }
 
@@ -83,9 +101,14 @@ func (j *CWLJobSpec) GetContainerID() string {
 	return GetContainerID(j.Tool)
 }
 
-// GetResourceRequirements extracts CPU and memory requirements.
-func (j *CWLJobSpec) GetResourceRequirements() (cpu int, memoryMB int) {
-	return GetResourceRequirements(j.Tool)
+// GetResourceRequirements evaluates the tool's CPU and memory requirements
+// with the job's inputs bound.
+func (j *CWLJobSpec) GetResourceRequirements() (cpu int, memoryMB int, err error) {
+	res, err := j.Tool.ResolveResources(j.Inputs)
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.Cores, res.RAM, nil
 }
 
 // GetContainerID extracts the container ID from a CWL document's requirements.
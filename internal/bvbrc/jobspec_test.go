@@ -300,3 +300,66 @@ func TestIsOptional(t *testing.T) {
 		})
 	}
 }
+
+func TestNewCWLJobSpec_SchemaDefTypes(t *testing.T) {
+	doc := &cwl.Document{
+		Class: "CommandLineTool",
+		Requirements: []cwl.Requirement{
+			{
+				Class: "SchemaDefRequirement",
+				Types: []interface{}{
+					map[string]interface{}{
+						"name":    "#Mode",
+						"type":    "enum",
+						"symbols": []interface{}{"fast", "sensitive"},
+					},
+				},
+			},
+		},
+		Inputs: []cwl.Input{
+			{ID: "mode", Type: "#Mode"},
+		},
+	}
+
+	if _, err := NewCWLJobSpec(doc, map[string]interface{}{"mode": "fast"}, "/output"); err != nil {
+		t.Errorf("Expected valid enum input, got %v", err)
+	}
+	if _, err := NewCWLJobSpec(doc, map[string]interface{}{"mode": "slow"}, "/output"); err == nil {
+		t.Error("Expected error for invalid enum symbol")
+	}
+
+	doc.Inputs = append(doc.Inputs, cwl.Input{ID: "sheet", Type: "#Unknown?"})
+	if _, err := NewCWLJobSpec(doc, map[string]interface{}{"mode": "fast"}, "/output"); err == nil {
+		t.Error("Expected error for unknown type reference")
+	}
+}
+
+func TestValidateInputs_Formats(t *testing.T) {
+	doc := &cwl.Document{
+		Class: "CommandLineTool",
+		Inputs: []cwl.Input{
+			{ID: "contigs", Type: "File", Format: "http://edamontology.org/format_1929"},
+		},
+	}
+
+	genbank := map[string]interface{}{
+		"contigs": map[string]interface{}{
+			"class":  "File",
+			"path":   "/ws/genome.gbk",
+			"format": "http://edamontology.org/format_1936",
+		},
+	}
+	if _, err := NewCWLJobSpec(doc, genbank, "/output"); err == nil {
+		t.Error("Expected error for a File with the wrong format")
+	}
+
+	unlabeled := map[string]interface{}{
+		"contigs": map[string]interface{}{"class": "File", "path": "/ws/contigs.fa"},
+	}
+	if err := ValidateInputs(doc, unlabeled, true); err == nil {
+		t.Error("Expected error for a File with no format when strict")
+	}
+	if err := ValidateInputs(doc, unlabeled, false); err != nil {
+		t.Errorf("Expected File with no format accepted when not strict, got %v", err)
+	}
+}
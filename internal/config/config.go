@@ -6,17 +6,21 @@ import (
 	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
 )
 
 // Config holds all configuration for the CWL service.
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	MongoDB  MongoDBConfig  `mapstructure:"mongodb"`
-	Redis    RedisConfig    `mapstructure:"redis"`
-	Auth     AuthConfig     `mapstructure:"auth"`
-	BVBRC    BVBRCConfig    `mapstructure:"bvbrc"`
-	Storage  StorageConfig  `mapstructure:"storage"`
-	Executor ExecutorConfig `mapstructure:"executor"`
+	Server     ServerConfig            `mapstructure:"server"`
+	MongoDB    MongoDBConfig           `mapstructure:"mongodb"`
+	Redis      RedisConfig             `mapstructure:"redis"`
+	Auth       AuthConfig              `mapstructure:"auth"`
+	BVBRC      BVBRCConfig             `mapstructure:"bvbrc"`
+	Storage    StorageConfig           `mapstructure:"storage"`
+	Executor   ExecutorConfig          `mapstructure:"executor"`
+	Sandbox    sandbox.EvaluatorConfig `mapstructure:"sandbox"`
+	Validation ValidationConfig        `mapstructure:"validation"`
 }
 
 // ServerConfig holds HTTP server configuration.
@@ -64,6 +68,13 @@ type StorageConfig struct {
 	ShockURL     string `mapstructure:"shock_url"`
 }
 
+// ValidationConfig holds input validation configuration.
+type ValidationConfig struct {
+	// StrictFormats rejects File inputs with no format, or a format the
+	// bundled EDAM ontology can't relate to the declared one.
+	StrictFormats bool `mapstructure:"strict_formats"`
+}
+
 // ExecutorConfig holds executor configuration.
 type ExecutorConfig struct {
 	Mode           string          `mapstructure:"mode"` // "bvbrc" or "local"
@@ -73,6 +84,9 @@ type ExecutorConfig struct {
 	DefaultCPU     int             `mapstructure:"default_cpu"`
 	DefaultMemory  int             `mapstructure:"default_memory"`  // MB
 	DefaultRuntime int             `mapstructure:"default_runtime"` // seconds
+	MaxCPU         int             `mapstructure:"max_cpu"`         // 0 for no limit
+	MaxMemory      int             `mapstructure:"max_memory"`      // MB, 0 for no limit
+	Checksums      bool            `mapstructure:"checksums"`       // sha1 checksums on output Files
 	Container      ContainerConfig `mapstructure:"container"`
 }
 
@@ -123,6 +137,9 @@ func Load(configPath string) (*Config, error) {
 	v.SetDefault("executor.default_cpu", 1)
 	v.SetDefault("executor.default_memory", 4096)
 	v.SetDefault("executor.default_runtime", 86400)
+	v.SetDefault("executor.max_cpu", 0)
+	v.SetDefault("executor.max_memory", 0)
+	v.SetDefault("executor.checksums", true)
 
 	// Container runtime defaults
 	v.SetDefault("executor.container.runtime", "apptainer")
@@ -134,6 +151,24 @@ func Load(configPath string) (*Config, error) {
 	v.SetDefault("executor.container.gpu_enabled", true)
 	v.SetDefault("executor.container.gpu_runtime", "nvidia")
 
+	// Expression sandbox defaults
+	v.SetDefault("sandbox.mode", "process")
+	v.SetDefault("sandbox.process.worker_count", 4)
+	v.SetDefault("sandbox.process.timeout", 5*time.Second)
+	v.SetDefault("sandbox.process.max_memory_mb", 50)
+	v.SetDefault("sandbox.process.max_output_bytes", 1024*1024)
+	v.SetDefault("sandbox.container.runtime", "docker")
+	v.SetDefault("sandbox.container.image", "ghcr.io/bv-brc/cwl-expression-sandbox:latest")
+	v.SetDefault("sandbox.container.timeout", 10*time.Second)
+	v.SetDefault("sandbox.container.max_memory_mb", 64)
+	v.SetDefault("sandbox.container.network_disabled", true)
+	v.SetDefault("sandbox.container.read_only_rootfs", true)
+	v.SetDefault("sandbox.container.drop_capabilities", true)
+	v.SetDefault("sandbox.container.runtime_path", "docker")
+
+	// Input validation defaults
+	v.SetDefault("validation.strict_formats", true)
+
 	// Read config file if specified
 	if configPath != "" {
 		v.SetConfigFile(configPath)
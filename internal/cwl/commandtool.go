@@ -10,6 +10,10 @@ import (
 type CommandBuilder struct {
 	doc    *Document
 	inputs map[string]interface{}
+	defs   SchemaDefs
+	ee     *ExpressionEvaluator
+	// runtime is the runtime object for expressions; nil uses defaults.
+	runtime map[string]interface{}
 }
 
 // NewCommandBuilder creates a new command builder.
@@ -17,6 +21,16 @@ func NewCommandBuilder(doc *Document, inputs map[string]interface{}) *CommandBui
 	return &CommandBuilder{
 		doc:    doc,
 		inputs: inputs,
+		defs:   doc.SchemaDefs(),
+	}
+}
+
+// SetRuntime sets the runtime object seen by the tool's expressions, as
+// built by Resources.RuntimeContext.
+func (cb *CommandBuilder) SetRuntime(runtime map[string]interface{}) {
+	cb.runtime = runtime
+	if cb.ee != nil {
+		cb.ee.SetRuntime(runtime)
 	}
 }
 
@@ -48,7 +62,7 @@ func (cb *CommandBuilder) BuildCommand() ([]string, error) {
 
 	// Add inputs with bindings
 	for _, input := range cb.doc.Inputs {
-		if input.InputBinding == nil {
+		if input.InputBinding == nil && !cb.hasFieldBindings(input) {
 			continue
 		}
 		parts, err := cb.buildInputBinding(input)
@@ -58,11 +72,20 @@ func (cb *CommandBuilder) BuildCommand() ([]string, error) {
 		cmdParts = append(cmdParts, parts...)
 	}
 
-	// Sort by position
-	sort.Slice(cmdParts, func(i, j int) bool {
-		return cmdParts[i].position < cmdParts[j].position
+	// Sort by position, then by array item and record field position
+	sort.SliceStable(cmdParts, func(i, j int) bool {
+		if cmdParts[i].position != cmdParts[j].position {
+			return cmdParts[i].position < cmdParts[j].position
+		}
+		return lessFieldPosition(cmdParts[i].fieldPosition, cmdParts[j].fieldPosition)
 	})
 
+	// With ShellCommandRequirement the parts are joined into a single
+	// shell command line and run via /bin/sh -c
+	if cb.doc.HasRequirement("ShellCommandRequirement") {
+		return []string{"/bin/sh", "-c", joinShellParts(cmdParts)}, nil
+	}
+
 	// Flatten to string slice
 	var result []string
 	for _, part := range cmdParts {
@@ -75,7 +98,81 @@ func (cb *CommandBuilder) BuildCommand() ([]string, error) {
 // commandPart represents a part of the command line with its position.
 type commandPart struct {
 	position int
-	value    []string
+	// fieldPosition orders the parts of a record input bound at position:
+	// the item index within an array of records, then the field position
+	// at each level of nesting.
+	fieldPosition []int
+	value         []string
+	// noQuote is set when shellQuote is false, so the value is passed to
+	// the shell verbatim (e.g. pipes and redirects).
+	noQuote bool
+}
+
+// lessFieldPosition orders field positions element by element, treating
+// missing elements as 0.
+func lessFieldPosition(a, b []int) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// childPosition returns the field position of a part nested under parent.
+func childPosition(parent []int, position int) []int {
+	return append(append([]int(nil), parent...), position)
+}
+
+// joinShellParts joins command parts into a shell command line, quoting
+// every value except those with shellQuote: false.
+func joinShellParts(parts []commandPart) string {
+	var words []string
+	for _, part := range parts {
+		for _, v := range part.value {
+			if part.noQuote {
+				words = append(words, v)
+			} else {
+				words = append(words, ShellQuote(v))
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// ShellQuote quotes a string for safe use as a single POSIX shell word.
+// Strings made only of characters with no special meaning are unchanged.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// isShellSafe reports whether r can appear unquoted in a shell word.
+func isShellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("@%+=:,./-_", r)
 }
 
 // getBaseCommand extracts the base command as a string slice.
@@ -106,7 +203,7 @@ func (cb *CommandBuilder) buildArgument(arg CommandLineArg, index int) ([]comman
 	var value string
 	if arg.ValueFrom != "" {
 		// Evaluate expression
-		evaluated, err := cb.evaluateExpression(arg.ValueFrom)
+		evaluated, err := cb.evaluateExpression(arg.ValueFrom, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -119,6 +216,15 @@ func (cb *CommandBuilder) buildArgument(arg CommandLineArg, index int) ([]comman
 // buildInputBinding builds command parts from an input binding.
 func (cb *CommandBuilder) buildInputBinding(input Input) ([]commandPart, error) {
 	binding := input.InputBinding
+	if binding == nil {
+		// Record input bound only through its fields
+		binding = &CommandLineBinding{}
+	}
+
+	parsedType, err := cb.defs.ParseType(input.Type)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get input value
 	var value interface{}
@@ -128,8 +234,7 @@ func (cb *CommandBuilder) buildInputBinding(input Input) ([]commandPart, error)
 		value = input.Default
 	} else {
 		// Check if type is optional
-		parsedType, err := ParseType(input.Type)
-		if err == nil && parsedType.IsOptional() {
+		if parsedType.IsOptional() {
 			return nil, nil // Skip optional inputs with no value
 		}
 		return nil, fmt.Errorf("missing required input: %s", input.ID)
@@ -142,11 +247,13 @@ func (cb *CommandBuilder) buildInputBinding(input Input) ([]commandPart, error)
 
 	// Handle valueFrom expression
 	if binding.ValueFrom != "" {
-		evaluated, err := cb.evaluateExpression(binding.ValueFrom)
+		evaluated, err := cb.evaluateExpression(binding.ValueFrom, value)
 		if err != nil {
 			return nil, err
 		}
 		value = evaluated
+	} else if parsedType.hasFieldInputBindings() {
+		return cb.buildRecordParts(binding.Position, nil, binding, parsedType, value)
 	}
 
 	// Convert value to string representation
@@ -155,6 +262,96 @@ func (cb *CommandBuilder) buildInputBinding(input Input) ([]commandPart, error)
 	return cb.buildBindingParts(binding.Position, binding.Prefix, binding.Separate, strValue, binding.ShellQuote), nil
 }
 
+// buildRecordParts builds command parts for a record input, or an array
+// of records: the binding's prefix, if any, followed by each field that has
+// an inputBinding, ordered by item index and then field position. Nested
+// records are expanded the same way.
+func (cb *CommandBuilder) buildRecordParts(position int, fieldPos []int, binding *CommandLineBinding, recordType *CWLType, value interface{}) ([]commandPart, error) {
+	var parts []commandPart
+	if binding.Prefix != "" {
+		parts = append(parts, commandPart{
+			position:      position,
+			fieldPosition: childPosition(fieldPos, -1000000), // Prefix comes before the fields
+			value:         []string{binding.Prefix},
+			noQuote:       binding.ShellQuote != nil && !*binding.ShellQuote,
+		})
+	}
+
+	if items, ok := value.([]interface{}); ok && recordType.Type == TypeArray {
+		for i, item := range items {
+			if item == nil {
+				continue
+			}
+			itemParts, err := cb.buildRecordParts(position, childPosition(fieldPos, i), &CommandLineBinding{}, recordType.Items, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			parts = append(parts, itemParts...)
+		}
+		return parts, nil
+	}
+
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected record, got %T", value)
+	}
+
+	for _, field := range recordType.Fields {
+		value, ok := record[field.Name]
+		if !ok || value == nil {
+			continue
+		}
+
+		fb := field.InputBinding
+		if fb == nil {
+			if !field.Type.hasFieldInputBindings() {
+				continue
+			}
+			// Nested record bound only through its fields
+			fb = &CommandLineBinding{}
+		}
+		pos := childPosition(fieldPos, fb.Position)
+
+		if fb.ValueFrom != "" {
+			evaluated, err := cb.evaluateExpression(fb.ValueFrom, value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			if evaluated == nil {
+				continue
+			}
+			value = evaluated
+		} else if field.Type.hasFieldInputBindings() {
+			nested, err := cb.buildRecordParts(position, pos, fb, field.Type, value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			parts = append(parts, nested...)
+			continue
+		}
+
+		strValue := cb.formatValue(value, nil, fb)
+		fieldParts := cb.buildBindingParts(position, fb.Prefix, fb.Separate, strValue, fb.ShellQuote)
+		for i := range fieldParts {
+			fieldParts[i].fieldPosition = pos
+		}
+		parts = append(parts, fieldParts...)
+	}
+
+	return parts, nil
+}
+
+// hasFieldBindings reports whether an input is a record, or an array of
+// records, whose fields declare inputBindings, which are bound even
+// without an input binding.
+func (cb *CommandBuilder) hasFieldBindings(input Input) bool {
+	t, err := cb.defs.ParseType(input.Type)
+	if err != nil {
+		return false
+	}
+	return t.hasFieldInputBindings()
+}
+
 // buildBindingParts creates command parts from binding components.
 func (cb *CommandBuilder) buildBindingParts(position int, prefix string, separate *bool, value string, shellQuote *bool) []commandPart {
 	var parts []commandPart
@@ -164,27 +361,33 @@ func (cb *CommandBuilder) buildBindingParts(position int, prefix string, separat
 		sep = *separate
 	}
 
+	noQuote := shellQuote != nil && !*shellQuote
+
 	if prefix != "" {
 		if sep && value != "" {
 			parts = append(parts, commandPart{
 				position: position,
 				value:    []string{prefix, value},
+				noQuote:  noQuote,
 			})
 		} else if value != "" {
 			parts = append(parts, commandPart{
 				position: position,
 				value:    []string{prefix + value},
+				noQuote:  noQuote,
 			})
 		} else {
 			parts = append(parts, commandPart{
 				position: position,
 				value:    []string{prefix},
+				noQuote:  noQuote,
 			})
 		}
 	} else if value != "" {
 		parts = append(parts, commandPart{
 			position: position,
 			value:    []string{value},
+			noQuote:  noQuote,
 		})
 	}
 
@@ -233,111 +436,97 @@ func (cb *CommandBuilder) formatValue(value interface{}, typeSpec interface{}, b
 	}
 }
 
-// evaluateExpression evaluates a CWL expression.
-func (cb *CommandBuilder) evaluateExpression(expr string) (interface{}, error) {
-	// Check if it's a parameter reference $(inputs.foo)
-	if strings.HasPrefix(expr, "$(") && strings.HasSuffix(expr, ")") {
-		ref := expr[2 : len(expr)-1]
-		return cb.resolveReference(ref)
-	}
-
-	// Check if it's a JavaScript expression ${...}
-	if strings.HasPrefix(expr, "${") && strings.HasSuffix(expr, "}") {
-		// JavaScript evaluation would go here
-		// For now, return the expression as-is
-		return expr, nil
-	}
-
-	// Literal value
-	return expr, nil
+// evaluateExpression evaluates a CWL expression with self bound to the
+// given value. JavaScript is only available with InlineJavascriptRequirement.
+func (cb *CommandBuilder) evaluateExpression(expr string, self interface{}) (interface{}, error) {
+	ee := cb.evaluator()
+	ee.SetSelf(self)
+	return ee.Evaluate(expr)
 }
 
-// resolveReference resolves a parameter reference like inputs.foo.
-func (cb *CommandBuilder) resolveReference(ref string) (interface{}, error) {
-	parts := strings.Split(ref, ".")
-	if len(parts) == 0 {
-		return nil, fmt.Errorf("empty reference")
+// evaluator returns the builder's expression evaluator, creating it on
+// first use.
+func (cb *CommandBuilder) evaluator() *ExpressionEvaluator {
+	if cb.ee == nil {
+		cb.ee = NewExpressionEvaluator()
+		cb.ee.SetInputs(cb.inputs)
+		cb.ee.SetRequirements(cb.doc.Requirements, cb.doc.Hints)
+		if cb.runtime != nil {
+			cb.ee.SetRuntime(cb.runtime)
+		}
 	}
+	return cb.ee
+}
 
-	var current interface{}
-
-	switch parts[0] {
-	case "inputs":
-		current = cb.inputs
-	case "self":
-		// self refers to current input value in certain contexts
-		return nil, fmt.Errorf("self reference not supported in this context")
-	case "runtime":
-		// runtime references need special handling
-		return cb.resolveRuntimeReference(parts[1:])
-	default:
-		return nil, fmt.Errorf("unknown reference root: %s", parts[0])
+// GetDockerRequirement returns the DockerRequirement if present.
+func (doc *Document) GetDockerRequirement() *Requirement {
+	for i := range doc.Requirements {
+		if doc.Requirements[i].Class == "DockerRequirement" {
+			return &doc.Requirements[i]
+		}
 	}
-
-	// Navigate through the reference path
-	for i := 1; i < len(parts); i++ {
-		switch c := current.(type) {
-		case map[string]interface{}:
-			var ok bool
-			current, ok = c[parts[i]]
-			if !ok {
-				return nil, fmt.Errorf("reference path not found: %s", strings.Join(parts[:i+1], "."))
-			}
-		default:
-			return nil, fmt.Errorf("cannot navigate into non-object at: %s", strings.Join(parts[:i], "."))
+	for i := range doc.Hints {
+		if doc.Hints[i].Class == "DockerRequirement" {
+			return &doc.Hints[i]
 		}
 	}
-
-	return current, nil
+	return nil
 }
 
-// resolveRuntimeReference resolves runtime.* references.
-func (cb *CommandBuilder) resolveRuntimeReference(parts []string) (interface{}, error) {
-	if len(parts) == 0 {
-		return nil, fmt.Errorf("empty runtime reference")
+// GetResourceRequirement returns the ResourceRequirement if present.
+func (doc *Document) GetResourceRequirement() *Requirement {
+	for i := range doc.Requirements {
+		if doc.Requirements[i].Class == "ResourceRequirement" {
+			return &doc.Requirements[i]
+		}
 	}
-
-	// These would be populated with actual runtime values during execution
-	runtimeValues := map[string]interface{}{
-		"cores":      1,
-		"ram":        4096,
-		"tmpdirSize": 1024,
-		"outdirSize": 1024,
-		"tmpdir":     "/tmp",
-		"outdir":     "/output",
+	for i := range doc.Hints {
+		if doc.Hints[i].Class == "ResourceRequirement" {
+			return &doc.Hints[i]
+		}
 	}
+	return nil
+}
 
-	if val, ok := runtimeValues[parts[0]]; ok {
-		return val, nil
+// GetToolTimeLimit returns the ToolTimeLimit if present.
+func (doc *Document) GetToolTimeLimit() *Requirement {
+	for i := range doc.Requirements {
+		if doc.Requirements[i].Class == "ToolTimeLimit" {
+			return &doc.Requirements[i]
+		}
 	}
-
-	return nil, fmt.Errorf("unknown runtime property: %s", parts[0])
+	for i := range doc.Hints {
+		if doc.Hints[i].Class == "ToolTimeLimit" {
+			return &doc.Hints[i]
+		}
+	}
+	return nil
 }
 
-// GetDockerRequirement returns the DockerRequirement if present.
-func (doc *Document) GetDockerRequirement() *Requirement {
+// GetLoadListingRequirement returns the LoadListingRequirement if present.
+func (doc *Document) GetLoadListingRequirement() *Requirement {
 	for i := range doc.Requirements {
-		if doc.Requirements[i].Class == "DockerRequirement" {
+		if doc.Requirements[i].Class == "LoadListingRequirement" {
 			return &doc.Requirements[i]
 		}
 	}
 	for i := range doc.Hints {
-		if doc.Hints[i].Class == "DockerRequirement" {
+		if doc.Hints[i].Class == "LoadListingRequirement" {
 			return &doc.Hints[i]
 		}
 	}
 	return nil
 }
 
-// GetResourceRequirement returns the ResourceRequirement if present.
-func (doc *Document) GetResourceRequirement() *Requirement {
+// GetInitialWorkDirRequirement returns the InitialWorkDirRequirement if present.
+func (doc *Document) GetInitialWorkDirRequirement() *Requirement {
 	for i := range doc.Requirements {
-		if doc.Requirements[i].Class == "ResourceRequirement" {
+		if doc.Requirements[i].Class == "InitialWorkDirRequirement" {
 			return &doc.Requirements[i]
 		}
 	}
 	for i := range doc.Hints {
-		if doc.Hints[i].Class == "ResourceRequirement" {
+		if doc.Hints[i].Class == "InitialWorkDirRequirement" {
 			return &doc.Hints[i]
 		}
 	}
@@ -359,6 +548,20 @@ func (doc *Document) HasRequirement(class string) bool {
 	return false
 }
 
+// ExpressionLib returns the expressionLib of the document's
+// InlineJavascriptRequirement, from requirements or hints.
+func (doc *Document) ExpressionLib() []string {
+	var lib []string
+	for _, reqs := range [][]Requirement{doc.Requirements, doc.Hints} {
+		for _, req := range reqs {
+			if req.Class == "InlineJavascriptRequirement" {
+				lib = append(lib, req.ExpressionLib...)
+			}
+		}
+	}
+	return lib
+}
+
 // GetDockerImage returns the Docker image to use.
 func (doc *Document) GetDockerImage() string {
 	req := doc.GetDockerRequirement()
@@ -490,30 +693,6 @@ func (doc *Document) RequiresContainer() bool {
 
 // GetResourceRequirements extracts resource requirements.
 func (doc *Document) GetResourceRequirements() (cores int, ramMB int, err error) {
-	req := doc.GetResourceRequirement()
-	if req == nil {
-		return 1, 4096, nil // defaults
-	}
-
-	cores = toInt(req.CoresMin, 1)
-	ramMB = toInt(req.RAMMin, 4096)
-
-	return cores, ramMB, nil
-}
-
-// toInt converts an interface to int with a default value.
-func toInt(v interface{}, def int) int {
-	switch val := v.(type) {
-	case int:
-		return val
-	case int64:
-		return int(val)
-	case float64:
-		return int(val)
-	case string:
-		// Could be an expression, return default
-		return def
-	default:
-		return def
-	}
+	res := doc.GetResources()
+	return res.Cores, res.RAM, nil
 }
@@ -3,7 +3,9 @@ package cwl
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCommandBuilder_BuildCommand(t *testing.T) {
@@ -121,6 +123,46 @@ func TestCommandBuilder_BuildCommand_Simple(t *testing.T) {
 	}
 }
 
+func TestCommandBuilder_RuntimeContext(t *testing.T) {
+	doc := &Document{
+		CWLVersion:  "v1.2",
+		Class:       ClassCommandLineTool,
+		BaseCommand: "bwa",
+		Requirements: []Requirement{
+			{Class: "ResourceRequirement", CoresMin: 8, OutdirMin: 20480},
+		},
+		Arguments: []CommandLineArg{
+			{ValueFrom: "-t", Position: 1},
+			{ValueFrom: "$(runtime.cores)", Position: 2},
+			{ValueFrom: "$(runtime.outdir)/out.sam", Position: 3},
+		},
+	}
+
+	res := doc.GetResources()
+	if res.Cores != 8 || res.RAM != 4096 || res.OutdirSize != 20480 || res.TmpdirSize != 1024 {
+		t.Fatalf("Unexpected resources: %+v", res)
+	}
+
+	builder := NewCommandBuilder(doc, nil)
+	builder.SetRuntime(res.RuntimeContext("/work/step", "/work/tmp"))
+	cmd, err := builder.BuildCommand()
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+
+	expected := []string{"bwa", "-t", "8", "/work/step/out.sam"}
+	if strings.Join(cmd, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected command %v, got %v", expected, cmd)
+	}
+}
+
+func TestReplaceRuntimePaths(t *testing.T) {
+	s := OutdirPlaceholder + "/out.sam:" + TmpdirPlaceholder
+	if got := ReplaceRuntimePaths(s, "/work/step", "/tmp/x"); got != "/work/step/out.sam:/tmp/x" {
+		t.Errorf("ReplaceRuntimePaths = %q", got)
+	}
+}
+
 func TestCommandBuilder_BuildCommand_WithPrefix(t *testing.T) {
 	doc := &Document{
 		CWLVersion:  "v1.2",
@@ -286,6 +328,217 @@ func TestCommandBuilder_OptionalInput(t *testing.T) {
 	}
 }
 
+func TestCommandBuilder_ShellCommand(t *testing.T) {
+	noQuote := false
+	doc := &Document{
+		CWLVersion:  "v1.2",
+		Class:       ClassCommandLineTool,
+		BaseCommand: []interface{}{"samtools", "view"},
+		Requirements: []Requirement{
+			{Class: "ShellCommandRequirement"},
+		},
+		Arguments: []CommandLineArg{
+			{Position: 2, ValueFrom: "|", ShellQuote: &noQuote},
+			{Position: 3, ValueFrom: "samtools"},
+			{Position: 4, ValueFrom: "sort"},
+			{Position: 5, ValueFrom: "> sorted.bam", ShellQuote: &noQuote},
+		},
+		Inputs: []Input{
+			{
+				ID:   "bam",
+				Type: "string",
+				InputBinding: &CommandLineBinding{
+					Position: 1,
+				},
+			},
+		},
+	}
+
+	inputs := map[string]interface{}{
+		"bam": "my reads's.bam",
+	}
+
+	builder := NewCommandBuilder(doc, inputs)
+	cmd, err := builder.BuildCommand()
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+
+	expected := []string{"/bin/sh", "-c", `samtools view 'my reads'\''s.bam' | samtools sort > sorted.bam`}
+	if len(cmd) != len(expected) {
+		t.Fatalf("Expected command %v, got %v", expected, cmd)
+	}
+	for i := range expected {
+		if cmd[i] != expected[i] {
+			t.Errorf("Expected cmd[%d]=%s, got %s", i, expected[i], cmd[i])
+		}
+	}
+}
+
+func TestCommandBuilder_RecordFieldBindings(t *testing.T) {
+	doc := &Document{
+		CWLVersion:  "v1.2",
+		Class:       ClassCommandLineTool,
+		BaseCommand: "align",
+		Requirements: []Requirement{
+			{
+				Class: "SchemaDefRequirement",
+				Types: []interface{}{
+					map[string]interface{}{
+						"name": "#Sample",
+						"type": "record",
+						"fields": []interface{}{
+							map[string]interface{}{
+								"name":         "reads",
+								"type":         "File",
+								"inputBinding": map[string]interface{}{"position": 2},
+							},
+							map[string]interface{}{
+								"name":         "id",
+								"type":         "string",
+								"inputBinding": map[string]interface{}{"position": 1, "prefix": "--id"},
+							},
+							map[string]interface{}{"name": "notes", "type": "string?"},
+						},
+					},
+				},
+			},
+		},
+		Inputs: []Input{
+			{ID: "sample", Type: "#Sample"},
+			{
+				ID:           "threads",
+				Type:         "int",
+				InputBinding: &CommandLineBinding{Position: 2, Prefix: "-t"},
+			},
+		},
+	}
+
+	inputs := map[string]interface{}{
+		"sample": map[string]interface{}{
+			"id":    "s1",
+			"reads": map[string]interface{}{"class": "File", "path": "/data/s1.fq"},
+			"notes": "ignored",
+		},
+		"threads": 4,
+	}
+
+	cmd, err := NewCommandBuilder(doc, inputs).BuildCommand()
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+
+	expected := []string{"align", "--id", "s1", "/data/s1.fq", "-t", "4"}
+	if len(cmd) != len(expected) {
+		t.Fatalf("Expected command %v, got %v", expected, cmd)
+	}
+	for i := range expected {
+		if cmd[i] != expected[i] {
+			t.Errorf("Expected cmd[%d]=%s, got %s", i, expected[i], cmd[i])
+		}
+	}
+}
+
+func TestCommandBuilder_NestedRecordBindings(t *testing.T) {
+	sample := map[string]interface{}{
+		"name": "#Sample",
+		"type": "record",
+		"fields": []interface{}{
+			map[string]interface{}{
+				"name":         "reads",
+				"type":         "File",
+				"inputBinding": map[string]interface{}{"position": 3},
+			},
+			map[string]interface{}{
+				"name":         "id",
+				"type":         "string",
+				"inputBinding": map[string]interface{}{"position": 1, "prefix": "--id"},
+			},
+			map[string]interface{}{
+				"name": "platform",
+				"type": map[string]interface{}{
+					"type": "record",
+					"fields": []interface{}{
+						map[string]interface{}{
+							"name": "model",
+							"type": "string",
+							"inputBinding": map[string]interface{}{
+								"prefix":    "--platform",
+								"valueFrom": "$(self.toUpperCase())",
+							},
+						},
+					},
+				},
+				"inputBinding": map[string]interface{}{"position": 2},
+			},
+		},
+	}
+
+	doc := &Document{
+		CWLVersion:  "v1.2",
+		Class:       ClassCommandLineTool,
+		BaseCommand: "align",
+		Requirements: []Requirement{
+			{Class: "InlineJavascriptRequirement"},
+			{Class: "SchemaDefRequirement", Types: []interface{}{sample}},
+		},
+		Inputs: []Input{
+			{ID: "samples", Type: "#Sample[]", InputBinding: &CommandLineBinding{Position: 1, Prefix: "--samples"}},
+			{ID: "threads", Type: "int", InputBinding: &CommandLineBinding{Position: 2, Prefix: "-t"}},
+		},
+	}
+
+	inputs := map[string]interface{}{
+		"samples": []interface{}{
+			map[string]interface{}{
+				"id":       "s1",
+				"reads":    map[string]interface{}{"class": "File", "path": "/data/s1.fq"},
+				"platform": map[string]interface{}{"model": "illumina"},
+			},
+			map[string]interface{}{
+				"id":    "s2",
+				"reads": map[string]interface{}{"class": "File", "path": "/data/s2.fq"},
+			},
+		},
+		"threads": 4,
+	}
+
+	cmd, err := NewCommandBuilder(doc, inputs).BuildCommand()
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+
+	expected := []string{
+		"align", "--samples",
+		"--id", "s1", "--platform", "ILLUMINA", "/data/s1.fq",
+		"--id", "s2", "/data/s2.fq",
+		"-t", "4",
+	}
+	if len(cmd) != len(expected) {
+		t.Fatalf("Expected command %v, got %v", expected, cmd)
+	}
+	for i := range expected {
+		if cmd[i] != expected[i] {
+			t.Errorf("Expected cmd[%d]=%s, got %s", i, expected[i], cmd[i])
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"":               "''",
+		"plain-file.txt": "plain-file.txt",
+		"a b":            "'a b'",
+		"$HOME":          "'$HOME'",
+		"it's":           `'it'\''s'`,
+	}
+	for in, want := range tests {
+		if got := ShellQuote(in); got != want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
 func TestDocument_GetDockerImage(t *testing.T) {
 	parser := NewParser()
 
@@ -331,6 +584,88 @@ func TestDocument_GetResourceRequirements(t *testing.T) {
 	}
 }
 
+func TestDocument_ResolveResources(t *testing.T) {
+	js := Requirement{Class: "InlineJavascriptRequirement"}
+	inputs := map[string]interface{}{"threads": 6, "genome_size": 1500}
+
+	tests := []struct {
+		name    string
+		req     Requirement
+		want    Resources
+		wantErr bool
+	}{
+		{
+			name: "parameter reference",
+			req:  Requirement{Class: "ResourceRequirement", CoresMin: "$(inputs.threads)"},
+			want: Resources{Cores: 6, CoresMax: 6, RAM: 4096, RAMMax: 4096, OutdirSize: 1024, OutdirMax: 1024, TmpdirSize: 1024, TmpdirMax: 1024},
+		},
+		{
+			name: "expression and range",
+			req:  Requirement{Class: "ResourceRequirement", RAMMin: "${ return inputs.genome_size * 4 }", RAMMax: 16384, TmpdirMin: 2048.5},
+			want: Resources{Cores: 1, CoresMax: 1, RAM: 6000, RAMMax: 16384, OutdirSize: 1024, OutdirMax: 1024, TmpdirSize: 2049, TmpdirMax: 2049},
+		},
+		{
+			name: "fractional cores and max only",
+			req:  Requirement{Class: "ResourceRequirement", CoresMin: 0.25, OutdirMax: 512},
+			want: Resources{Cores: 1, CoresMax: 1, RAM: 4096, RAMMax: 4096, OutdirSize: 512, OutdirMax: 512, TmpdirSize: 1024, TmpdirMax: 1024},
+		},
+		{
+			name:    "max below min",
+			req:     Requirement{Class: "ResourceRequirement", CoresMin: 8, CoresMax: 4},
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			req:     Requirement{Class: "ResourceRequirement", RAMMin: "$(inputs.threads * 'x')"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &Document{Class: ClassCommandLineTool, Requirements: []Requirement{js, tt.req}}
+			got, err := doc.ResolveResources(inputs)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveResources failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDocument_ResolveTimeLimit(t *testing.T) {
+	doc := &Document{
+		Class:        ClassCommandLineTool,
+		Hints:        []Requirement{{Class: "ToolTimeLimit", TimeLimit: "$(inputs.hours * 3600)"}},
+		Requirements: []Requirement{{Class: "InlineJavascriptRequirement"}},
+	}
+
+	limit, err := doc.ResolveTimeLimit(map[string]interface{}{"hours": 2})
+	if err != nil {
+		t.Fatalf("ResolveTimeLimit failed: %v", err)
+	}
+	if limit != 2*time.Hour {
+		t.Errorf("Expected 2h, got %s", limit)
+	}
+
+	doc.Hints[0].TimeLimit = -5
+	if _, err := doc.ResolveTimeLimit(nil); err == nil {
+		t.Error("Expected error for negative time limit")
+	}
+
+	if limit, err := (&Document{}).ResolveTimeLimit(nil); err != nil || limit != 0 {
+		t.Errorf("Expected no limit, got %s, %v", limit, err)
+	}
+}
+
 func TestDocument_HasRequirement(t *testing.T) {
 	parser := NewParser()
 
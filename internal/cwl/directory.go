@@ -0,0 +1,50 @@
+package cwl
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// loadListing values.
+const (
+	NoListing      = "no_listing"
+	ShallowListing = "shallow_listing"
+	DeepListing    = "deep_listing"
+)
+
+// InputLoadListing returns how much of a Directory input's listing to
+// load: the input's own loadListing, else the LoadListingRequirement, else
+// no_listing.
+func (doc *Document) InputLoadListing(in Input) string {
+	if in.LoadListing != "" {
+		return in.LoadListing
+	}
+	if req := doc.GetLoadListingRequirement(); req != nil && req.LoadListing != "" {
+		return req.LoadListing
+	}
+	return NoListing
+}
+
+// ListDirectory returns the contents of a local directory as File and
+// Directory objects. With deep, subdirectories are listed recursively.
+func ListDirectory(path string, deep bool) ([]interface{}, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := FileObjectOptions{LoadListing: NoListing}
+	if deep {
+		opts.LoadListing = DeepListing
+	}
+
+	listing := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		obj, err := BuildFileObject(filepath.Join(path, entry.Name()), opts)
+		if err != nil {
+			return nil, err
+		}
+		listing = append(listing, obj)
+	}
+	return listing, nil
+}
@@ -0,0 +1,54 @@
+package cwl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "params"), 0755)
+	os.WriteFile(filepath.Join(dir, "model.npz"), []byte("model"), 0644)
+	os.WriteFile(filepath.Join(dir, "params", "weights.bin"), []byte("weights"), 0644)
+
+	shallow, err := ListDirectory(dir, false)
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	if len(shallow) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", shallow)
+	}
+	file := shallow[0].(map[string]interface{})
+	if file["class"] != TypeFile || file["basename"] != "model.npz" || file["nameext"] != ".npz" || file["size"] != int64(5) {
+		t.Errorf("Unexpected file entry %v", file)
+	}
+	sub := shallow[1].(map[string]interface{})
+	if sub["class"] != TypeDirectory || sub["listing"] != nil {
+		t.Errorf("Expected unlisted directory entry, got %v", sub)
+	}
+
+	deep, err := ListDirectory(dir, true)
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	nested, _ := deep[1].(map[string]interface{})["listing"].([]interface{})
+	if len(nested) != 1 || nested[0].(map[string]interface{})["basename"] != "weights.bin" {
+		t.Errorf("Expected nested listing with weights.bin, got %v", nested)
+	}
+}
+
+func TestDocument_InputLoadListing(t *testing.T) {
+	doc := &Document{}
+	if got := doc.InputLoadListing(Input{ID: "db"}); got != NoListing {
+		t.Errorf("Expected %s by default, got %s", NoListing, got)
+	}
+
+	doc.Hints = []Requirement{{Class: "LoadListingRequirement", LoadListing: DeepListing}}
+	if got := doc.InputLoadListing(Input{ID: "db"}); got != DeepListing {
+		t.Errorf("Expected %s from LoadListingRequirement, got %s", DeepListing, got)
+	}
+	if got := doc.InputLoadListing(Input{ID: "db", LoadListing: ShallowListing}); got != ShallowListing {
+		t.Errorf("Expected the input's own %s, got %s", ShallowListing, got)
+	}
+}
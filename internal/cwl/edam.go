@@ -0,0 +1,88 @@
+package cwl
+
+import "strings"
+
+// EDAMNamespace is the IRI prefix of EDAM ontology terms.
+const EDAMNamespace = "http://edamontology.org/"
+
+// edamParents is the bundled subset of the EDAM format hierarchy, mapping
+// each format term to its direct superclasses. It covers the formats our
+// tools exchange, so format checks work without fetching $schemas.
+var edamParents = map[string][]string{
+	"format_1915": nil,             // Format
+	"format_2330": {"format_1915"}, // Textual format
+	"format_2333": {"format_1915"}, // Binary format
+	"format_1919": {"format_1915"}, // Sequence record format
+	"format_1920": {"format_1915"}, // Sequence feature annotation format
+	"format_1921": {"format_1915"}, // Alignment format
+	"format_2006": {"format_1915"}, // Phylogenetic tree format
+
+	// Sequence records
+	"format_2546": {"format_1919"},                // FASTA-like
+	"format_2200": {"format_2546", "format_2330"}, // FASTA-like (text)
+	"format_1929": {"format_2200"},                // FASTA
+	"format_2545": {"format_1919"},                // FASTQ-like format
+	"format_2182": {"format_2545", "format_2330"}, // FASTQ-like format (text)
+	"format_1930": {"format_2182"},                // FASTQ
+	"format_1931": {"format_2182"},                // FASTQ-illumina
+	"format_1932": {"format_2182"},                // FASTQ-sanger
+	"format_1933": {"format_2182"},                // FASTQ-solexa
+	"format_2559": {"format_1919"},                // GenBank-like format
+	"format_2205": {"format_2559", "format_2330"}, // GenBank-like format (text)
+	"format_1936": {"format_2205"},                // GenBank format
+
+	// Feature annotation
+	"format_2206": {"format_1920", "format_2330"}, // Sequence feature table format (text)
+	"format_2305": {"format_2206"},                // GFF
+	"format_1975": {"format_2305"},                // GFF3
+	"format_2306": {"format_2305"},                // GTF
+	"format_3003": {"format_2206"},                // BED
+
+	// Alignments and variants
+	"format_2573": {"format_1921", "format_2330"}, // SAM
+	"format_2572": {"format_1921", "format_2333"}, // BAM
+	"format_3462": {"format_1921", "format_2333"}, // CRAM
+	"format_3016": {"format_2330"},                // VCF
+
+	// Trees
+	"format_2556": {"format_2006", "format_2330"}, // Phylogenetic tree format (text)
+	"format_1910": {"format_2556"},                // newick
+
+	// Generic
+	"format_3475": {"format_2330"}, // TSV
+	"format_3752": {"format_2330"}, // CSV
+	"format_3464": {"format_2330"}, // JSON
+	"format_2332": {"format_2330"}, // XML
+	"format_2331": {"format_2330"}, // HTML
+	"format_3987": {"format_2333"}, // ZIP format
+	"format_3989": {"format_2333"}, // GZIP format
+}
+
+// edamTerm returns the EDAM term of a format IRI, and whether the term is
+// in the bundled ontology.
+func edamTerm(iri string) (string, bool) {
+	if !strings.HasPrefix(iri, EDAMNamespace) {
+		return "", false
+	}
+	term := strings.TrimPrefix(iri, EDAMNamespace)
+	_, ok := edamParents[term]
+	return term, ok
+}
+
+// IsSubFormat reports whether format is declared or, in the bundled EDAM
+// ontology, a subclass of it. Both are full IRIs.
+func IsSubFormat(format, declared string) bool {
+	if format == declared {
+		return true
+	}
+	term, ok := edamTerm(format)
+	if !ok {
+		return false
+	}
+	for _, parent := range edamParents[term] {
+		if IsSubFormat(EDAMNamespace+parent, declared) {
+			return true
+		}
+	}
+	return false
+}
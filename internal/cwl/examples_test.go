@@ -161,13 +161,10 @@ func TestParseExampleAlignReadsWorkflow(t *testing.T) {
 	examplesDir := findExamplesDir(t)
 	cwlPath := filepath.Join(examplesDir, "workflows", "align-reads.cwl")
 
-	data, err := os.ReadFile(cwlPath)
-	if err != nil {
-		t.Fatalf("Failed to read align-reads.cwl: %v", err)
-	}
-
+	// The workflow runs ../tools/bwa-mem.cwl, so it must be parsed from its
+	// file for the reference to resolve
 	parser := NewParser()
-	doc, err := parser.ParseBytes(data)
+	doc, err := parser.ParseFile(cwlPath)
 	if err != nil {
 		t.Fatalf("Failed to parse align-reads.cwl: %v", err)
 	}
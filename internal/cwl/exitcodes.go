@@ -0,0 +1,48 @@
+package cwl
+
+// ExitStatus is the outcome of a tool run, from its exit code.
+type ExitStatus string
+
+const (
+	ExitSuccess          ExitStatus = "success"
+	ExitTemporaryFailure ExitStatus = "temporaryFail"
+	ExitPermanentFailure ExitStatus = "permanentFail"
+)
+
+// TemporaryFailExitCode is the exit status cwl-step-runner uses to report a
+// temporary failure to the task system (EX_TEMPFAIL from sysexits.h).
+const TemporaryFailExitCode = 75
+
+// ClassifyExitCode interprets a tool's exit code. Codes in successCodes
+// succeed, then those in temporaryFailCodes fail temporarily and those in
+// permanentFailCodes fail permanently. Otherwise 0 succeeds and any other
+// code is a permanent failure.
+func ClassifyExitCode(code int, successCodes, temporaryFailCodes, permanentFailCodes []int) ExitStatus {
+	switch {
+	case containsCode(successCodes, code):
+		return ExitSuccess
+	case containsCode(temporaryFailCodes, code):
+		return ExitTemporaryFailure
+	case containsCode(permanentFailCodes, code):
+		return ExitPermanentFailure
+	case code == 0:
+		return ExitSuccess
+	default:
+		return ExitPermanentFailure
+	}
+}
+
+// ClassifyExitCode interprets an exit code of the tool.
+func (doc *Document) ClassifyExitCode(code int) ExitStatus {
+	return ClassifyExitCode(code, doc.SuccessCodes, doc.TemporaryFailCodes, doc.PermanentFailCodes)
+}
+
+// containsCode reports whether codes contains code.
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package cwl
+
+import "testing"
+
+func TestClassifyExitCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int
+		success   []int
+		temporary []int
+		permanent []int
+		expected  ExitStatus
+	}{
+		{name: "zero succeeds by default", code: 0, expected: ExitSuccess},
+		{name: "non-zero fails by default", code: 1, expected: ExitPermanentFailure},
+		{name: "listed success code", code: 1, success: []int{0, 1}, expected: ExitSuccess},
+		{name: "zero still succeeds with successCodes", code: 0, success: []int{1}, expected: ExitSuccess},
+		{name: "temporary fail code", code: 3, temporary: []int{3}, expected: ExitTemporaryFailure},
+		{name: "permanent fail code", code: 0, permanent: []int{0}, expected: ExitPermanentFailure},
+		{name: "success wins over temporary", code: 2, success: []int{2}, temporary: []int{2}, expected: ExitSuccess},
+		{name: "temporary wins over permanent", code: 2, temporary: []int{2}, permanent: []int{2}, expected: ExitTemporaryFailure},
+		{name: "unlisted code", code: 4, success: []int{1}, temporary: []int{3}, expected: ExitPermanentFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyExitCode(tt.code, tt.success, tt.temporary, tt.permanent)
+			if got != tt.expected {
+				t.Errorf("ClassifyExitCode(%d) = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
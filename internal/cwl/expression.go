@@ -1,27 +1,62 @@
 package cwl
 
 import (
+	"context"
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/dop251/goja"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
 )
 
 // ExpressionEvaluator evaluates CWL expressions.
 type ExpressionEvaluator struct {
-	runtime    *goja.Runtime
 	expressionLib []string
-	inputs     map[string]interface{}
-	self       interface{}
-	runtimeCtx map[string]interface{}
+	inputs        map[string]interface{}
+	self          interface{}
+	runtimeCtx    map[string]interface{}
+	// javascript enables JavaScript evaluation; without it only parameter
+	// references are evaluated.
+	javascript bool
+	// sandbox, when set, runs JavaScript instead of localEvaluator.
+	sandbox sandbox.Evaluator
+	timeout time.Duration
 }
 
-// NewExpressionEvaluator creates a new expression evaluator.
+// localEvaluator runs JavaScript for evaluators without a sandbox, sharing
+// its cache of compiled libraries and expressions between them.
+var localEvaluator = sandbox.NewInProcessEvaluator()
+
+var (
+	defaultSandboxMu      sync.RWMutex
+	defaultSandbox        sandbox.Evaluator
+	defaultSandboxTimeout time.Duration
+)
+
+// SetDefaultSandbox sets the sandbox evaluator, and the timeout for each
+// expression, that new ExpressionEvaluators use. Processes that evaluate
+// user expressions on shared infrastructure, such as cwe-scheduler, set it
+// at startup so no user JavaScript runs unconstrained in the process.
+func SetDefaultSandbox(evaluator sandbox.Evaluator, timeout time.Duration) {
+	defaultSandboxMu.Lock()
+	defer defaultSandboxMu.Unlock()
+	defaultSandbox = evaluator
+	defaultSandboxTimeout = timeout
+}
+
+// NewExpressionEvaluator creates a new expression evaluator. JavaScript is
+// enabled until SetRequirements says otherwise, and runs in the default
+// sandbox if one is set.
 func NewExpressionEvaluator() *ExpressionEvaluator {
+	defaultSandboxMu.RLock()
+	defer defaultSandboxMu.RUnlock()
+
 	return &ExpressionEvaluator{
-		runtime:    goja.New(),
 		runtimeCtx: make(map[string]interface{}),
+		javascript: true,
+		sandbox:    defaultSandbox,
+		timeout:    defaultSandboxTimeout,
 	}
 }
 
@@ -45,113 +80,149 @@ func (ee *ExpressionEvaluator) SetExpressionLib(lib []string) {
 	ee.expressionLib = lib
 }
 
-// Evaluate evaluates a CWL expression and returns the result.
+// SetSandbox runs JavaScript in evaluator, allowing each expression up to
+// timeout. A zero timeout leaves the deadline to the evaluator; a nil
+// evaluator runs JavaScript in this process.
+func (ee *ExpressionEvaluator) SetSandbox(evaluator sandbox.Evaluator, timeout time.Duration) {
+	ee.sandbox = evaluator
+	ee.timeout = timeout
+}
+
+// SetRequirements configures evaluation for a process's requirements and
+// hints. With InlineJavascriptRequirement, expressions run as JavaScript with
+// its expressionLib loaded; without it, only parameter references such as
+// $(inputs.reads.path) are evaluated and JavaScript is an error.
+func (ee *ExpressionEvaluator) SetRequirements(requirements, hints []Requirement) {
+	ee.javascript = false
+	ee.expressionLib = nil
+	for _, reqs := range [][]Requirement{requirements, hints} {
+		for _, req := range reqs {
+			if req.Class == "InlineJavascriptRequirement" {
+				ee.javascript = true
+				ee.expressionLib = append(ee.expressionLib, req.ExpressionLib...)
+			}
+		}
+	}
+}
+
+// Evaluate evaluates a CWL expression and returns the result. A string that
+// is a single expression yields the expression's value; otherwise each
+// embedded expression is replaced by its value and a string is returned.
 func (ee *ExpressionEvaluator) Evaluate(expr string) (interface{}, error) {
-	// Check expression type
-	if strings.HasPrefix(expr, "$(") && strings.HasSuffix(expr, ")") {
-		// Parameter reference or simple expression
-		inner := expr[2 : len(expr)-1]
-		return ee.evaluateParameterReference(inner)
+	if !containsExpression(expr) {
+		// Literal value
+		return expr, nil
 	}
 
-	if strings.HasPrefix(expr, "${") && strings.HasSuffix(expr, "}") {
-		// JavaScript expression
-		inner := expr[2 : len(expr)-1]
-		return ee.evaluateJavaScript(inner)
+	segments, err := scanExpressions(expr)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for embedded expressions in string
-	if containsExpression(expr) {
-		return ee.evaluateStringWithExpressions(expr)
+	if len(segments) == 1 && segments[0].Expr {
+		return ee.evaluateSegment(segments[0])
 	}
 
-	// Literal value
-	return expr, nil
+	return ee.interpolate(segments)
+}
+
+// evaluateSegment evaluates a single $(...) or ${...} expression body.
+func (ee *ExpressionEvaluator) evaluateSegment(seg exprSegment) (interface{}, error) {
+	if !ee.javascript {
+		if seg.Block {
+			return nil, fmt.Errorf("${...} expression requires InlineJavascriptRequirement")
+		}
+		return ee.evaluateReference(seg.Text)
+	}
+	if seg.Block {
+		return ee.evaluateJavaScript(seg.Text)
+	}
+	return ee.evaluateParameterReference(seg.Text)
 }
 
 // evaluateParameterReference evaluates a simple parameter reference.
 func (ee *ExpressionEvaluator) evaluateParameterReference(ref string) (interface{}, error) {
-	// Set up the JavaScript runtime with contexts
-	ee.setupContext()
-
-	// Evaluate as JavaScript expression
-	result, err := ee.runtime.RunString(ref)
+	// Evaluate as a JavaScript expression; the parentheses keep object
+	// literals from parsing as blocks
+	result, err := ee.runScript("(" + ref + "\n)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to evaluate reference '%s': %w", ref, err)
 	}
 
-	return result.Export(), nil
+	return result, nil
 }
 
 // evaluateJavaScript evaluates a JavaScript expression.
 func (ee *ExpressionEvaluator) evaluateJavaScript(code string) (interface{}, error) {
-	// Set up the JavaScript runtime with contexts
-	ee.setupContext()
-
-	// Load expression library
-	for _, lib := range ee.expressionLib {
-		if _, err := ee.runtime.RunString(lib); err != nil {
-			return nil, fmt.Errorf("failed to load expression library: %w", err)
-		}
-	}
-
 	// Wrap code to return result
-	wrappedCode := fmt.Sprintf("(function() { %s })()", code)
+	wrappedCode := fmt.Sprintf("(function() { %s \n})()", code)
 
-	result, err := ee.runtime.RunString(wrappedCode)
+	result, err := ee.runScript(wrappedCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to evaluate JavaScript: %w", err)
 	}
 
-	return result.Export(), nil
+	return result, nil
 }
 
-// evaluateStringWithExpressions evaluates a string containing embedded expressions.
-func (ee *ExpressionEvaluator) evaluateStringWithExpressions(s string) (interface{}, error) {
-	// Find all $(expr) patterns
-	paramPattern := regexp.MustCompile(`\$\([^)]+\)`)
-
-	result := paramPattern.ReplaceAllStringFunc(s, func(match string) string {
-		inner := match[2 : len(match)-1]
-		val, err := ee.evaluateParameterReference(inner)
-		if err != nil {
-			return match // Keep original on error
-		}
-		return fmt.Sprintf("%v", val)
-	})
+// runScript runs a JavaScript program with the expression library loaded
+// and returns its completion value. The program runs in the sandbox if one
+// is set and in this process otherwise, bounded by the evaluator's timeout
+// and with a fresh global scope either way.
+func (ee *ExpressionEvaluator) runScript(script string) (interface{}, error) {
+	ctx := context.Background()
+	if ee.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ee.timeout)
+		defer cancel()
+	}
 
-	return result, nil
-}
+	inputs := ee.inputs
+	if inputs == nil {
+		inputs = make(map[string]interface{})
+	}
 
-// setupContext sets up the JavaScript runtime with CWL contexts.
-func (ee *ExpressionEvaluator) setupContext() {
-	// Set up inputs
-	if ee.inputs != nil {
-		ee.runtime.Set("inputs", ee.inputs)
-	} else {
-		ee.runtime.Set("inputs", make(map[string]interface{}))
+	evaluator := ee.sandbox
+	if evaluator == nil {
+		evaluator = localEvaluator
 	}
+	return evaluator.Evaluate(ctx, sandbox.Request{
+		Expression:    script,
+		Inputs:        inputs,
+		Self:          ee.self,
+		Runtime:       ee.runtimeContext(),
+		ExpressionLib: ee.expressionLib,
+	})
+}
 
-	// Set up self
-	if ee.self != nil {
-		ee.runtime.Set("self", ee.self)
-	} else {
-		ee.runtime.Set("self", nil)
+// interpolate evaluates the expressions in segments and joins the results
+// with the literal text between them.
+func (ee *ExpressionEvaluator) interpolate(segments []exprSegment) (string, error) {
+	var b strings.Builder
+	for _, seg := range segments {
+		if !seg.Expr {
+			b.WriteString(seg.Text)
+			continue
+		}
+		val, err := ee.evaluateSegment(seg)
+		if err != nil {
+			return "", err
+		}
+		str, err := interpolationString(val)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(str)
 	}
+	return b.String(), nil
+}
 
-	// Set up runtime
-	if ee.runtimeCtx != nil {
-		ee.runtime.Set("runtime", ee.runtimeCtx)
-	} else {
-		ee.runtime.Set("runtime", map[string]interface{}{
-			"cores":      1,
-			"ram":        4096,
-			"tmpdirSize": 1024,
-			"outdirSize": 1024,
-			"tmpdir":     "/tmp",
-			"outdir":     "/output",
-		})
+// runtimeContext returns the runtime object, with defaults when unset.
+func (ee *ExpressionEvaluator) runtimeContext() map[string]interface{} {
+	if len(ee.runtimeCtx) > 0 {
+		return ee.runtimeCtx
 	}
+	return defaultResources.RuntimeContext(DefaultOutdir, DefaultTmpdir)
 }
 
 // containsExpression checks if a string contains CWL expressions.
@@ -170,11 +241,36 @@ func IsExpression(s string) bool {
 	return false
 }
 
-// EvaluateGlob evaluates a glob expression which can be a string or expression.
+// EvaluateFormat evaluates an output's format, a literal IRI or an
+// expression yielding one. A missing format is the empty string.
+func (ee *ExpressionEvaluator) EvaluateFormat(format interface{}) (string, error) {
+	expr, ok := format.(string)
+	if !ok || expr == "" {
+		return "", nil
+	}
+
+	result, err := ee.Evaluate(expr)
+	if err != nil {
+		return "", err
+	}
+	switch v := result.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("format must evaluate to a string, got %T", result)
+	}
+}
+
+// EvaluateGlob evaluates a glob, which can be a string or list of strings
+// that may contain expressions. A nil glob matches nothing.
 func (ee *ExpressionEvaluator) EvaluateGlob(glob interface{}) ([]string, error) {
 	switch v := glob.(type) {
+	case nil:
+		return nil, nil
 	case string:
-		if IsExpression(v) {
+		if containsExpression(v) {
 			result, err := ee.Evaluate(v)
 			if err != nil {
 				return nil, err
@@ -186,7 +282,7 @@ func (ee *ExpressionEvaluator) EvaluateGlob(glob interface{}) ([]string, error)
 		var patterns []string
 		for _, item := range v {
 			if s, ok := item.(string); ok {
-				if IsExpression(s) {
+				if containsExpression(s) {
 					result, err := ee.Evaluate(s)
 					if err != nil {
 						return nil, err
@@ -321,7 +417,8 @@ func getNameext(path string) string {
 	return ""
 }
 
-// EvaluateCondition evaluates a CWL "when" condition.
+// EvaluateCondition evaluates a CWL "when" condition. A null result skips
+// the step; any other non-boolean result is an error.
 func (ee *ExpressionEvaluator) EvaluateCondition(condition string, inputs map[string]interface{}) (bool, error) {
 	ee.SetInputs(inputs)
 
@@ -336,7 +433,6 @@ func (ee *ExpressionEvaluator) EvaluateCondition(condition string, inputs map[st
 	case nil:
 		return false, nil
 	default:
-		// Truthy evaluation
-		return result != nil && result != false && result != 0 && result != "", nil
+		return false, fmt.Errorf("when must evaluate to a boolean, got %T", result)
 	}
 }
@@ -1,7 +1,14 @@
 package cwl
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
 )
 
 func TestExpressionEvaluator_SimpleReference(t *testing.T) {
@@ -195,6 +202,44 @@ func TestExpressionEvaluator_EvaluateGlob(t *testing.T) {
 	if len(patterns) != 2 {
 		t.Errorf("Expected 2 patterns, got %d", len(patterns))
 	}
+
+	// Expression embedded in a pattern
+	patterns, err = ee.EvaluateGlob("$(inputs.output_dir)/*.$(inputs.format)")
+	if err != nil {
+		t.Fatalf("Failed to evaluate glob: %v", err)
+	}
+	if len(patterns) != 1 || patterns[0] != "results/*.pdb" {
+		t.Errorf("Expected [results/*.pdb], got %v", patterns)
+	}
+}
+
+func TestExpressionEvaluator_EvaluateFormat(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetInputs(map[string]interface{}{
+		"reads": map[string]interface{}{"class": "File", "format": "http://edamontology.org/format_1930"},
+	})
+
+	tests := []struct {
+		format   interface{}
+		expected string
+	}{
+		{nil, ""},
+		{"http://edamontology.org/format_1929", "http://edamontology.org/format_1929"},
+		{"$(inputs.reads.format)", "http://edamontology.org/format_1930"},
+	}
+	for _, tt := range tests {
+		got, err := ee.EvaluateFormat(tt.format)
+		if err != nil {
+			t.Fatalf("EvaluateFormat(%v) failed: %v", tt.format, err)
+		}
+		if got != tt.expected {
+			t.Errorf("EvaluateFormat(%v) = %q, want %q", tt.format, got, tt.expected)
+		}
+	}
+
+	if _, err := ee.EvaluateFormat("$(inputs.reads)"); err == nil {
+		t.Error("Expected error for a format that is not a string")
+	}
 }
 
 func TestExpressionEvaluator_EvaluateCondition(t *testing.T) {
@@ -239,6 +284,24 @@ func TestExpressionEvaluator_EvaluateCondition(t *testing.T) {
 	}
 }
 
+func TestExpressionEvaluator_EvaluateCondition_NonBoolean(t *testing.T) {
+	ee := NewExpressionEvaluator()
+
+	run, err := ee.EvaluateCondition("$(inputs.missing)", map[string]interface{}{"missing": nil})
+	if err != nil {
+		t.Fatalf("Expected null condition to evaluate, got error: %v", err)
+	}
+	if run {
+		t.Error("Expected null condition to be false")
+	}
+
+	for _, value := range []interface{}{1, "yes", []interface{}{true}} {
+		if _, err := ee.EvaluateCondition("$(inputs.value)", map[string]interface{}{"value": value}); err == nil {
+			t.Errorf("Expected error for non-boolean condition result %v", value)
+		}
+	}
+}
+
 func TestResolveSecondaryFilePattern(t *testing.T) {
 	testCases := []struct {
 		primary  string
@@ -320,3 +383,149 @@ func TestGetNameext(t *testing.T) {
 		}
 	}
 }
+
+func TestExpressionEvaluator_Sandbox(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetSandbox(sandbox.NewInProcessEvaluator(), time.Second)
+	ee.SetRequirements([]Requirement{{
+		Class:         "InlineJavascriptRequirement",
+		ExpressionLib: []string{"function tag(s) { return s + '.sorted'; }"},
+	}}, nil)
+	ee.SetInputs(map[string]interface{}{"sample": "S1"})
+	ee.SetSelf("bam")
+
+	result, err := ee.Evaluate("$(tag(inputs.sample)).$(self)")
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result != "S1.sorted.bam" {
+		t.Errorf("Expected 'S1.sorted.bam', got %v", result)
+	}
+
+	result, err = ee.Evaluate("${ return inputs.sample.length; }")
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result != int64(2) {
+		t.Errorf("Expected 2, got %v (%T)", result, result)
+	}
+}
+
+func TestExpressionEvaluator_SandboxTimeout(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetSandbox(sandbox.NewInProcessEvaluator(), 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := ee.Evaluate("${ while(true) {} }")
+	if err == nil || !strings.Contains(err.Error(), sandbox.ErrTimeout.Error()) {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected evaluation to stop at the timeout, took %v", elapsed)
+	}
+}
+
+func TestSetDefaultSandbox(t *testing.T) {
+	evaluator := sandbox.NewInProcessEvaluator()
+	SetDefaultSandbox(evaluator, time.Second)
+	defer SetDefaultSandbox(nil, 0)
+
+	ee := NewExpressionEvaluator()
+	if ee.sandbox != evaluator || ee.timeout != time.Second {
+		t.Errorf("Expected new evaluators to use the default sandbox")
+	}
+
+	// Command lines are built with the default sandbox too
+	doc := &Document{
+		CWLVersion:   "v1.2",
+		Class:        ClassCommandLineTool,
+		BaseCommand:  "echo",
+		Requirements: []Requirement{{Class: "InlineJavascriptRequirement"}},
+		Arguments:    []CommandLineArg{{ValueFrom: "$(inputs.n * 2)"}},
+	}
+	cmd, err := NewCommandBuilder(doc, map[string]interface{}{"n": 21}).BuildCommand()
+	if err != nil {
+		t.Fatalf("BuildCommand failed: %v", err)
+	}
+	if strings.Join(cmd, " ") != "echo 42" {
+		t.Errorf("Expected 'echo 42', got %v", cmd)
+	}
+}
+
+func TestExpressionEvaluator_LibraryLoadedOnce(t *testing.T) {
+	lib := []string{"var loads = (typeof loads === 'undefined' ? 0 : loads) + 1;"}
+	evaluator := sandbox.NewInProcessEvaluator()
+
+	for i := 0; i < 3; i++ {
+		ee := NewExpressionEvaluator()
+		ee.SetSandbox(evaluator, time.Second)
+		ee.SetExpressionLib(lib)
+		ee.SetInputs(map[string]interface{}{"n": i})
+		result, err := ee.Evaluate("${ return [loads, inputs.n]; }")
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		got := result.([]interface{})
+		if got[0] != int64(1) || got[1] != int64(i) {
+			t.Fatalf("Expected the library loaded once and inputs.n=%d, got loads=%v n=%v", i, got[0], got[1])
+		}
+	}
+}
+
+// benchmarkLib is an expression library of the size bioinformatics tools
+// commonly ship: a handful of helper functions.
+var benchmarkLib = []string{
+	"var libLoads = (typeof libLoads === 'undefined' ? 0 : libLoads) + 1;",
+	strings.Repeat("var pad = function(n, width) { var s = String(n); while (s.length < width) { s = '0' + s; } return s; };\n", 20),
+	"function basename(path) { return path.split('/').pop(); }",
+	"function stem(path) { var b = basename(path); var i = b.lastIndexOf('.'); return i > 0 ? b.slice(0, i) : b; }",
+}
+
+// BenchmarkExpressionEvaluator_Scatter evaluates one expression per scatter
+// element, each with a fresh evaluator, through the in-process sandbox
+// evaluator, and reports how many times the library was loaded.
+func BenchmarkExpressionEvaluator_Scatter(b *testing.B) {
+	evaluator := sandbox.NewInProcessEvaluator()
+	for i := 0; i < b.N; i++ {
+		ee := NewExpressionEvaluator()
+		ee.SetSandbox(evaluator, time.Second)
+		ee.SetExpressionLib(benchmarkLib)
+		ee.SetInputs(map[string]interface{}{"index": i, "path": "/data/sample.fastq"})
+		if _, err := ee.Evaluate("$(stem(inputs.path))_$(pad(inputs.index, 6)).bam"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	ee := NewExpressionEvaluator()
+	ee.SetSandbox(evaluator, time.Second)
+	ee.SetExpressionLib(benchmarkLib)
+	loads, err := ee.Evaluate("$(libLoads)")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(loads.(int64)), "lib-loads")
+}
+
+// BenchmarkExpressionEvaluator_Uncached is the same workload with the
+// library and expressions parsed on every evaluation, for comparison.
+func BenchmarkExpressionEvaluator_Uncached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		vm := goja.New()
+		vm.Set("inputs", map[string]interface{}{"index": i, "path": "/data/sample.fastq"})
+		for _, lib := range benchmarkLib {
+			if _, err := vm.RunString(lib); err != nil {
+				b.Fatal(err)
+			}
+		}
+		stem, err := vm.RunString("(stem(inputs.path)\n)")
+		if err != nil {
+			b.Fatal(err)
+		}
+		index, err := vm.RunString("(pad(inputs.index, 6)\n)")
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = fmt.Sprintf("%v_%v.bam", stem.Export(), index.Export())
+	}
+}
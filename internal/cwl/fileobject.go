@@ -0,0 +1,101 @@
+package cwl
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileObjectOptions controls the optional parts of a File or Directory
+// object built by BuildFileObject.
+type FileObjectOptions struct {
+	// Checksum streams the file through SHA-1 to fill in checksum.
+	Checksum bool
+
+	// LoadContents reads the file into contents; files larger than
+	// MaxContentsSize are an error.
+	LoadContents bool
+
+	// LoadListing is how much of a Directory's listing to load.
+	LoadListing string
+
+	// Format is the format IRI recorded on a File.
+	Format string
+}
+
+// BuildFileObject describes a local file or directory as a CWL File or
+// Directory object. Files always carry basename, dirname, nameroot,
+// nameext and size; the options add the rest.
+func BuildFileObject(path string, opts FileObjectOptions) (map[string]interface{}, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	basename := filepath.Base(path)
+	if info.IsDir() {
+		obj := map[string]interface{}{
+			"class":    TypeDirectory,
+			"location": path,
+			"path":     path,
+			"basename": basename,
+		}
+		if opts.LoadListing == ShallowListing || opts.LoadListing == DeepListing {
+			listing, err := ListDirectory(path, opts.LoadListing == DeepListing)
+			if err != nil {
+				return nil, err
+			}
+			obj["listing"] = listing
+		}
+		return obj, nil
+	}
+
+	ext := filepath.Ext(basename)
+	obj := map[string]interface{}{
+		"class":    TypeFile,
+		"location": path,
+		"path":     path,
+		"basename": basename,
+		"dirname":  filepath.Dir(path),
+		"nameroot": strings.TrimSuffix(basename, ext),
+		"nameext":  ext,
+		"size":     info.Size(),
+	}
+	if opts.Format != "" {
+		obj["format"] = opts.Format
+	}
+	if opts.Checksum {
+		checksum, err := FileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		obj["checksum"] = checksum
+	}
+	if opts.LoadContents {
+		contents, err := ReadContents(path)
+		if err != nil {
+			return nil, err
+		}
+		obj["contents"] = contents
+	}
+	return obj, nil
+}
+
+// FileChecksum returns the CWL checksum of a file, "sha1$" followed by the
+// hex SHA-1 digest. The file is streamed rather than read into memory.
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha1$" + hex.EncodeToString(h.Sum(nil)), nil
+}
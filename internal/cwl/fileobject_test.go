@@ -0,0 +1,72 @@
+package cwl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildFileObject(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reads.fastq.gz")
+	os.WriteFile(path, []byte("test"), 0644)
+
+	obj, err := BuildFileObject(path, FileObjectOptions{
+		Checksum:     true,
+		LoadContents: true,
+		Format:       "http://edamontology.org/format_1930",
+	})
+	if err != nil {
+		t.Fatalf("BuildFileObject failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"class":    TypeFile,
+		"location": path,
+		"path":     path,
+		"basename": "reads.fastq.gz",
+		"dirname":  dir,
+		"nameroot": "reads.fastq",
+		"nameext":  ".gz",
+		"size":     int64(4),
+		"checksum": "sha1$a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"contents": "test",
+		"format":   "http://edamontology.org/format_1930",
+	}
+	for key, want := range expected {
+		if obj[key] != want {
+			t.Errorf("Expected %s %v, got %v", key, want, obj[key])
+		}
+	}
+
+	// Optional fields are left out unless requested
+	obj, err = BuildFileObject(path, FileObjectOptions{})
+	if err != nil {
+		t.Fatalf("BuildFileObject failed: %v", err)
+	}
+	for _, key := range []string{"checksum", "contents", "format"} {
+		if _, ok := obj[key]; ok {
+			t.Errorf("Expected no %s, got %v", key, obj[key])
+		}
+	}
+}
+
+func TestBuildFileObject_Directory(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+
+	obj, err := BuildFileObject(dir, FileObjectOptions{Checksum: true, LoadListing: ShallowListing})
+	if err != nil {
+		t.Fatalf("BuildFileObject failed: %v", err)
+	}
+	if obj["class"] != TypeDirectory {
+		t.Errorf("Expected Directory, got %v", obj["class"])
+	}
+	if _, ok := obj["checksum"]; ok {
+		t.Error("Directories have no checksum")
+	}
+	listing, _ := obj["listing"].([]interface{})
+	if len(listing) != 1 || listing[0].(map[string]interface{})["dirname"] != dir {
+		t.Errorf("Expected listing with full File metadata, got %v", listing)
+	}
+}
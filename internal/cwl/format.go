@@ -0,0 +1,159 @@
+package cwl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpandIRI expands a "prefix:name" reference using the document's
+// $namespaces. Full IRIs and references with unknown prefixes are returned
+// unchanged.
+func (doc *Document) ExpandIRI(ref string) string {
+	i := strings.Index(ref, ":")
+	if i <= 0 || strings.HasPrefix(ref[i+1:], "//") {
+		return ref
+	}
+	if ns, ok := doc.Namespaces[ref[:i]]; ok {
+		return ns + ref[i+1:]
+	}
+	return ref
+}
+
+// expandFormat expands the IRIs of a parameter's format, a string or list
+// of strings. Expressions are left for evaluation.
+func (doc *Document) expandFormat(format interface{}) interface{} {
+	switch v := format.(type) {
+	case string:
+		if IsExpression(v) {
+			return v
+		}
+		return doc.ExpandIRI(v)
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded[i] = doc.expandFormat(item)
+		}
+		return expanded
+	default:
+		return format
+	}
+}
+
+// ValidateInputFormats checks that each File given to an input with a
+// format has that format or, per the bundled EDAM ontology, a subclass of
+// it. Strict checking also rejects Files without a format and formats the
+// ontology cannot relate to the declared one; otherwise only known
+// mismatches are errors.
+func (doc *Document) ValidateInputFormats(inputs map[string]interface{}, strict bool) error {
+	for _, input := range doc.Inputs {
+		if input.Format == nil {
+			continue
+		}
+		value, ok := inputs[input.ID]
+		if !ok || value == nil {
+			continue
+		}
+
+		declared, err := doc.inputFormats(input.Format, inputs)
+		if err != nil {
+			return fmt.Errorf("input %s: format: %w", input.ID, err)
+		}
+		if len(declared) == 0 {
+			continue
+		}
+		if err := doc.checkFormats(value, declared, strict); err != nil {
+			return fmt.Errorf("input %s: %w", input.ID, err)
+		}
+	}
+	return nil
+}
+
+// inputFormats returns the expanded format IRIs an input accepts,
+// evaluating expressions against the job inputs.
+func (doc *Document) inputFormats(format interface{}, inputs map[string]interface{}) ([]string, error) {
+	switch v := format.(type) {
+	case string:
+		if !IsExpression(v) {
+			return []string{doc.ExpandIRI(v)}, nil
+		}
+		ee := NewExpressionEvaluator()
+		ee.SetInputs(inputs)
+		ee.SetRequirements(doc.Requirements, doc.Hints)
+		result, err := ee.Evaluate(v)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			return nil, nil
+		}
+		if s, ok := result.(string); ok {
+			return []string{doc.ExpandIRI(s)}, nil
+		}
+		return doc.inputFormats(result, inputs)
+	case []interface{}:
+		var formats []string
+		for _, item := range v {
+			f, err := doc.inputFormats(item, inputs)
+			if err != nil {
+				return nil, err
+			}
+			formats = append(formats, f...)
+		}
+		return formats, nil
+	default:
+		return nil, fmt.Errorf("must be a string or list of strings, got %T", format)
+	}
+}
+
+// checkFormats checks the Files in value, which may be nested in arrays,
+// against the declared formats.
+func (doc *Document) checkFormats(value interface{}, declared []string, strict bool) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if class, _ := v["class"].(string); class == TypeFile {
+			return doc.checkFileFormat(v, declared, strict)
+		}
+	case []interface{}:
+		for i, item := range v {
+			if err := doc.checkFormats(item, declared, strict); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkFileFormat checks a single File against the declared formats.
+func (doc *Document) checkFileFormat(file map[string]interface{}, declared []string, strict bool) error {
+	name, _ := file["basename"].(string)
+	if name == "" {
+		name, _ = file["location"].(string)
+	}
+	expected := strings.Join(declared, " or ")
+
+	format, _ := file["format"].(string)
+	if format == "" {
+		if strict {
+			return fmt.Errorf("file %s has no format, expected %s", name, expected)
+		}
+		return nil
+	}
+	format = doc.ExpandIRI(format)
+
+	known := true
+	for _, want := range declared {
+		if IsSubFormat(format, want) {
+			return nil
+		}
+		if _, ok := edamTerm(want); !ok {
+			known = false
+		}
+	}
+	if _, ok := edamTerm(format); !ok {
+		known = false
+	}
+	if !known && !strict {
+		return nil
+	}
+	return fmt.Errorf("file %s has format %s, expected %s", name, format, expected)
+}
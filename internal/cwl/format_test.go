@@ -0,0 +1,161 @@
+package cwl
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	edamFASTA   = EDAMNamespace + "format_1929"
+	edamFASTQ   = EDAMNamespace + "format_1930"
+	edamGenBank = EDAMNamespace + "format_1936"
+)
+
+func TestIsSubFormat(t *testing.T) {
+	tests := []struct {
+		format   string
+		declared string
+		expected bool
+	}{
+		{edamFASTA, edamFASTA, true},
+		{edamFASTA, EDAMNamespace + "format_2200", true},
+		{edamFASTA, EDAMNamespace + "format_1919", true},
+		{edamFASTQ, EDAMNamespace + "format_2330", true},
+		{EDAMNamespace + "format_2572", EDAMNamespace + "format_2333", true},
+		{edamFASTQ, edamFASTA, false},
+		{EDAMNamespace + "format_2200", edamFASTA, false},
+		{"http://example.org/fasta", edamFASTA, false},
+	}
+	for _, tt := range tests {
+		if got := IsSubFormat(tt.format, tt.declared); got != tt.expected {
+			t.Errorf("IsSubFormat(%s, %s) = %v, want %v", tt.format, tt.declared, got, tt.expected)
+		}
+	}
+}
+
+func TestDocument_ExpandIRI(t *testing.T) {
+	doc := &Document{Namespaces: map[string]string{"edam": EDAMNamespace}}
+
+	tests := map[string]string{
+		"edam:format_1929":      edamFASTA,
+		edamFASTA:               edamFASTA,
+		"other:format_1929":     "other:format_1929",
+		"format_1929":           "format_1929",
+		"file:///data/reads.fq": "file:///data/reads.fq",
+	}
+	for ref, expected := range tests {
+		if got := doc.ExpandIRI(ref); got != expected {
+			t.Errorf("ExpandIRI(%s) = %s, want %s", ref, got, expected)
+		}
+	}
+}
+
+func TestDocument_ValidateInputFormats(t *testing.T) {
+	doc := &Document{
+		Namespaces: map[string]string{"edam": EDAMNamespace},
+		Inputs: []Input{
+			{ID: "contigs", Type: "File", Format: edamFASTA},
+			{ID: "reads", Type: "File[]", Format: []interface{}{edamFASTQ, edamFASTA}},
+			{ID: "annotation", Type: "File?", Format: "$(inputs.contigs.format)"},
+		},
+	}
+
+	file := func(name, format string) map[string]interface{} {
+		f := map[string]interface{}{"class": "File", "basename": name, "location": "/data/" + name}
+		if format != "" {
+			f["format"] = format
+		}
+		return f
+	}
+
+	tests := []struct {
+		name    string
+		inputs  map[string]interface{}
+		strict  bool
+		wantErr string
+	}{
+		{
+			name: "matching formats",
+			inputs: map[string]interface{}{
+				"contigs": file("contigs.fa", "edam:format_1929"),
+				"reads":   []interface{}{file("r1.fq", edamFASTQ), file("r2.fa", edamFASTA)},
+			},
+			strict: true,
+		},
+		{
+			name:    "known mismatch",
+			inputs:  map[string]interface{}{"contigs": file("genome.gbk", edamGenBank)},
+			wantErr: "input contigs: file genome.gbk has format " + edamGenBank,
+		},
+		{
+			name: "mismatch in array",
+			inputs: map[string]interface{}{
+				"reads": []interface{}{file("r1.fq", edamFASTQ), file("genome.gbk", edamGenBank)},
+			},
+			wantErr: "input reads: [1]: file genome.gbk",
+		},
+		{
+			name: "format from expression",
+			inputs: map[string]interface{}{
+				"contigs":    file("contigs.fa", edamFASTA),
+				"annotation": file("r1.fq", edamFASTQ),
+			},
+			wantErr: "input annotation: file r1.fq has format " + edamFASTQ,
+		},
+		{
+			name:    "missing format strict",
+			inputs:  map[string]interface{}{"contigs": file("contigs.fa", "")},
+			strict:  true,
+			wantErr: "has no format",
+		},
+		{
+			name:   "missing format lenient",
+			inputs: map[string]interface{}{"contigs": file("contigs.fa", "")},
+		},
+		{
+			name:    "unknown format strict",
+			inputs:  map[string]interface{}{"contigs": file("contigs.fa", "http://example.org/fasta")},
+			strict:  true,
+			wantErr: "has format http://example.org/fasta",
+		},
+		{
+			name:   "unknown format lenient",
+			inputs: map[string]interface{}{"contigs": file("contigs.fa", "http://example.org/fasta")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := doc.ValidateInputFormats(tt.inputs, tt.strict)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDocument_ValidateInputFormats_RequiresJavascript(t *testing.T) {
+	doc := &Document{
+		Inputs: []Input{
+			{ID: "contigs", Type: "File", Format: "${ return inputs.contigs.format; }"},
+		},
+	}
+	inputs := map[string]interface{}{
+		"contigs": map[string]interface{}{"class": "File", "basename": "contigs.fa", "format": edamFASTA},
+	}
+
+	if err := doc.ValidateInputFormats(inputs, true); err == nil {
+		t.Error("Expected JavaScript format expression to fail without InlineJavascriptRequirement")
+	}
+
+	doc.Requirements = []Requirement{{Class: "InlineJavascriptRequirement"}}
+	if err := doc.ValidateInputFormats(inputs, true); err != nil {
+		t.Errorf("Expected JavaScript format expression to run with InlineJavascriptRequirement, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package cwl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// exprSegment is one piece of a string that may contain expressions:
+// either literal text or the body of a $(...) or ${...} expression.
+type exprSegment struct {
+	Text string
+	// Expr is set when Text is an expression body.
+	Expr bool
+	// Block is set for ${...} function bodies; otherwise Text is a
+	// $(...) parameter reference.
+	Block bool
+}
+
+// scanExpressions splits s into literal text and expressions following the
+// CWL v1.2 interpolation grammar. Expressions end at the bracket matching
+// their opening one; brackets inside string literals do not count. In
+// literal text, "\$(" and "\${" produce "$(" and "${", and "\\" produces a
+// single backslash; other backslashes are kept.
+func scanExpressions(s string) ([]exprSegment, error) {
+	var segments []exprSegment
+	var literal strings.Builder
+
+	flush := func() {
+		if literal.Len() > 0 {
+			segments = append(segments, exprSegment{Text: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\\':
+			literal.WriteByte('\\')
+			i += 2
+		case c == '\\' && isExpressionStart(s, i+1):
+			literal.WriteString(s[i+1 : i+3])
+			i += 3
+		case isExpressionStart(s, i):
+			end, err := scanBalanced(s, i+1)
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			segments = append(segments, exprSegment{
+				Text:  s[i+2 : end],
+				Expr:  true,
+				Block: s[i+1] == '{',
+			})
+			i = end + 1
+		default:
+			literal.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+
+	return segments, nil
+}
+
+// isExpressionStart reports whether s has "$(" or "${" at offset i.
+func isExpressionStart(s string, i int) bool {
+	return i+1 < len(s) && s[i] == '$' && (s[i+1] == '(' || s[i+1] == '{')
+}
+
+// scanBalanced returns the offset of the bracket that closes the one at
+// s[start], skipping over string literals.
+func scanBalanced(s string, start int) (int, error) {
+	var closers []byte
+	var quote byte
+
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			closers = append(closers, ')')
+		case '[':
+			closers = append(closers, ']')
+		case '{':
+			closers = append(closers, '}')
+		case ')', ']', '}':
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return 0, fmt.Errorf("unbalanced %q at offset %d in expression %q", c, i, s[start-1:])
+			}
+			closers = closers[:len(closers)-1]
+			if len(closers) == 0 {
+				return i, nil
+			}
+		}
+	}
+
+	if quote != 0 {
+		return 0, fmt.Errorf("unterminated string literal in expression %q", s[start-1:])
+	}
+	return 0, fmt.Errorf("unterminated expression %q", s[start-1:])
+}
+
+// interpolationString converts an expression result for embedding in a
+// string: strings are used as-is and other values are serialized as JSON.
+func interpolationString(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize interpolated value: %w", err)
+	}
+	return string(data), nil
+}
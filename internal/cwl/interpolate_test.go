@@ -0,0 +1,66 @@
+package cwl
+
+import (
+	"testing"
+)
+
+func TestExpressionEvaluator_Interpolation(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetInputs(map[string]interface{}{
+		"name":    "sample",
+		"threads": 4,
+		"files":   []interface{}{"a.fq", "b.fq"},
+		"params":  map[string]interface{}{"k": 31},
+	})
+
+	testCases := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"$(inputs.files.map(function(f) { return (f); }).length)", int64(2)},
+		{"$(inputs.name).$(inputs.threads).txt", "sample.4.txt"},
+		{"-t $(inputs.files.map(f => (f.toUpperCase())).join(','))", "-t A.FQ,B.FQ"},
+		{"$(inputs.name + ')')_out", "sample)_out"},
+		{"n=${ return inputs.threads * 2; }", "n=8"},
+		{"files=$(inputs.files)", `files=["a.fq","b.fq"]`},
+		{"params=$(inputs.params) none=$(null)", `params={"k":31} none=null`},
+		{"$({'class': 'File', 'path': inputs.name})", map[string]interface{}{"class": "File", "path": "sample"}},
+		{`\$(inputs.name) is $(inputs.name)`, "$(inputs.name) is sample"},
+		{`\\$(inputs.name)`, `\sample`},
+		{`cost: \$5 $(inputs.threads)`, `cost: \$5 4`},
+	}
+
+	for _, tc := range testCases {
+		result, err := ee.Evaluate(tc.expr)
+		if err != nil {
+			t.Errorf("Evaluate(%q) failed: %v", tc.expr, err)
+			continue
+		}
+		if m, ok := tc.expected.(map[string]interface{}); ok {
+			got, ok := result.(map[string]interface{})
+			if !ok || got["class"] != m["class"] || got["path"] != m["path"] {
+				t.Errorf("Evaluate(%q) = %v, expected %v", tc.expr, result, tc.expected)
+			}
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Evaluate(%q) = %#v, expected %#v", tc.expr, result, tc.expected)
+		}
+	}
+}
+
+func TestExpressionEvaluator_InterpolationErrors(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetInputs(map[string]interface{}{"name": "sample"})
+
+	for _, expr := range []string{
+		"out_$(inputs.name",
+		"out_$(inputs.name]",
+		"$(inputs.name + ')",
+		"out_$(inputs.missing.field).txt",
+	} {
+		if result, err := ee.Evaluate(expr); err == nil {
+			t.Errorf("Evaluate(%q) = %v, expected error", expr, result)
+		}
+	}
+}
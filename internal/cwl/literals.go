@@ -0,0 +1,149 @@
+package cwl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// MaxContentsSize is the most loadContents reads from a file.
+const MaxContentsSize = 64 * 1024
+
+// ReadContents reads a file for loadContents. Files larger than
+// MaxContentsSize are an error.
+func ReadContents(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxContentsSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxContentsSize {
+		return "", fmt.Errorf("loadContents: %s is larger than %d KiB", path, MaxContentsSize/1024)
+	}
+	return string(data), nil
+}
+
+// IsFileLiteral reports whether obj is a File without a location, whose
+// contents are given inline.
+func IsFileLiteral(obj map[string]interface{}) bool {
+	return isLiteral(obj, TypeFile)
+}
+
+// IsDirectoryLiteral reports whether obj is a Directory without a location,
+// whose contents are given entirely by its listing.
+func IsDirectoryLiteral(obj map[string]interface{}) bool {
+	return isLiteral(obj, TypeDirectory)
+}
+
+func isLiteral(obj map[string]interface{}, class string) bool {
+	if c, _ := obj["class"].(string); c != class {
+		return false
+	}
+	location, _ := obj["location"].(string)
+	path, _ := obj["path"].(string)
+	return location == "" && path == ""
+}
+
+// PlaceLiterals returns a copy of inputs in which each File and Directory
+// literal, including those in arrays, has a path under root so a command
+// line can refer to it before it is materialized. Each literal gets its own
+// parent directory named after its input, which also names literals
+// without a basename.
+func PlaceLiterals(inputs map[string]interface{}, root string) map[string]interface{} {
+	return placeInputs(inputs, root, false)
+}
+
+// PlaceInputs is PlaceLiterals for located Files and Directories as well,
+// which a remote step must stage before its command runs. A File's
+// secondaryFiles are placed in the same directory as the File. Placed
+// objects keep their location, the source to stage them from.
+func PlaceInputs(inputs map[string]interface{}, root string) map[string]interface{} {
+	return placeInputs(inputs, root, true)
+}
+
+func placeInputs(inputs map[string]interface{}, root string, located bool) map[string]interface{} {
+	placed := make(map[string]interface{}, len(inputs))
+	for id, value := range inputs {
+		placed[id] = placeInput(value, root, id, located)
+	}
+	return placed
+}
+
+func placeInput(value interface{}, root, name string, located bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		class, _ := v["class"].(string)
+		literal := IsFileLiteral(v) || IsDirectoryLiteral(v)
+		if !literal && !(located && (class == TypeFile || class == TypeDirectory)) {
+			return value
+		}
+		dir := root + "/" + name
+		obj := placeObject(v, dir, name, literal)
+		if secondaries, ok := v["secondaryFiles"].([]interface{}); ok && located {
+			placed := make([]interface{}, len(secondaries))
+			for i, sf := range secondaries {
+				sfMap, ok := sf.(map[string]interface{})
+				if !ok {
+					placed[i] = sf
+					continue
+				}
+				placed[i] = placeObject(sfMap, dir, name, IsFileLiteral(sfMap) || IsDirectoryLiteral(sfMap))
+			}
+			obj["secondaryFiles"] = placed
+		}
+		return obj
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = placeInput(item, root, name+"_"+strconv.Itoa(i), located)
+		}
+		return items
+	default:
+		return value
+	}
+}
+
+// placeObject returns a copy of a File or Directory with its path in dir.
+// A literal without a basename is named name.
+func placeObject(v map[string]interface{}, dir, name string, literal bool) map[string]interface{} {
+	obj := make(map[string]interface{}, len(v)+2)
+	for k, val := range v {
+		obj[k] = val
+	}
+	source := sourceLocation(obj)
+	basename, _ := obj["basename"].(string)
+	if basename == "" {
+		basename = name
+		if !literal {
+			basename = path.Base(source)
+		}
+		obj["basename"] = basename
+	}
+	placed := dir + "/" + basename
+	obj["path"] = placed
+	if _, ok := obj["dirname"]; ok {
+		obj["dirname"] = dir
+	}
+	obj["location"] = placed
+	if !literal {
+		obj["location"] = source
+	}
+	return obj
+}
+
+// sourceLocation returns where a located File or Directory is read from:
+// its location, or its path without one.
+func sourceLocation(obj map[string]interface{}) string {
+	if location, _ := obj["location"].(string); location != "" {
+		return location
+	}
+	p, _ := obj["path"].(string)
+	return p
+}
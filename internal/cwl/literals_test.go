@@ -0,0 +1,72 @@
+package cwl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadContents(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "params.txt")
+	os.WriteFile(small, []byte("k=31\n"), 0644)
+
+	contents, err := ReadContents(small)
+	if err != nil {
+		t.Fatalf("ReadContents failed: %v", err)
+	}
+	if contents != "k=31\n" {
+		t.Errorf("Expected contents %q, got %q", "k=31\n", contents)
+	}
+
+	exact := filepath.Join(dir, "exact.txt")
+	os.WriteFile(exact, []byte(strings.Repeat("a", MaxContentsSize)), 0644)
+	if _, err := ReadContents(exact); err != nil {
+		t.Errorf("Expected a file of exactly %d bytes to load, got %v", MaxContentsSize, err)
+	}
+
+	large := filepath.Join(dir, "large.txt")
+	os.WriteFile(large, []byte(strings.Repeat("a", MaxContentsSize+1)), 0644)
+	if _, err := ReadContents(large); err == nil || !strings.Contains(err.Error(), "64 KiB") {
+		t.Errorf("Expected size limit error, got %v", err)
+	}
+}
+
+func TestPlaceLiterals(t *testing.T) {
+	fileLiteral := map[string]interface{}{
+		"class":    "File",
+		"basename": "params.json",
+		"contents": `{"k": 31}`,
+	}
+	located := map[string]interface{}{"class": "Directory", "path": "/data/db"}
+	inputs := map[string]interface{}{
+		"params": fileLiteral,
+		"refs":   map[string]interface{}{"class": "Directory", "listing": []interface{}{}},
+		"dbs":    []interface{}{located, map[string]interface{}{"class": "Directory", "basename": "extra"}},
+		"label":  "run1",
+	}
+
+	placed := PlaceLiterals(inputs, "/work")
+
+	if path := placed["params"].(map[string]interface{})["path"]; path != "/work/params/params.json" {
+		t.Errorf("Unexpected placed File literal path %v", path)
+	}
+	refs := placed["refs"].(map[string]interface{})
+	if refs["path"] != "/work/refs/refs" || refs["basename"] != "refs" {
+		t.Errorf("Unexpected placed Directory literal %v", refs)
+	}
+	dbs := placed["dbs"].([]interface{})
+	if dbs[0].(map[string]interface{})["path"] != "/data/db" {
+		t.Errorf("Located directory should be unchanged, got %v", dbs[0])
+	}
+	if path := dbs[1].(map[string]interface{})["path"]; path != "/work/dbs_1/extra" {
+		t.Errorf("Unexpected placed array literal path %v", path)
+	}
+	if placed["label"] != "run1" {
+		t.Errorf("Expected non-literal inputs unchanged, got %v", placed["label"])
+	}
+	if _, ok := fileLiteral["path"]; ok {
+		t.Error("PlaceLiterals modified its input")
+	}
+}
@@ -0,0 +1,483 @@
+package cwl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loader resolves document preprocessing directives ($import, $include,
+// $mixin) and step run references relative to the document they appear in.
+type loader struct {
+	// base is the directory of the document being loaded; relative
+	// references resolve against it. Empty means the document did not come
+	// from a file, and may not refer to any.
+	base string
+	// graph holds the $graph entries of the document, for "#id" references.
+	graph []interface{}
+	// version is the document's cwlVersion, given to $graph entries that
+	// are inlined as step run documents.
+	version string
+	// metadata holds the document's $namespaces and $schemas, likewise
+	// given to $graph entries.
+	metadata map[string]interface{}
+	// loading holds the files currently being imported, to catch cycles.
+	loading map[string]bool
+	// keepGraphRuns leaves "#id" run references into graph unresolved, for
+	// WorkflowAnalyzer.ResolveStepTool to look up; otherwise they are inlined.
+	keepGraphRuns bool
+	// inlineRuns replaces run references to files with the documents they
+	// name, as PackFile does; otherwise they are made absolute.
+	inlineRuns bool
+}
+
+// newLoader returns a loader for the top-level document raw, with
+// references resolving against base.
+func newLoader(raw map[string]interface{}, base string) *loader {
+	l := &loader{base: base, loading: make(map[string]bool)}
+	l.version, _ = raw["cwlVersion"].(string)
+	l.metadata = make(map[string]interface{})
+	for _, key := range []string{"$namespaces", "$schemas"} {
+		if v, ok := raw[key]; ok {
+			l.metadata[key] = v
+		}
+	}
+	return l
+}
+
+// loadDocument resolves the directives in raw relative to base. For a
+// $graph document it returns the process named by fragment, or "main" (or
+// the only process) when fragment is empty, along with every resolved graph
+// process keyed by id.
+func loadDocument(raw map[string]interface{}, fragment, base string) (map[string]interface{}, map[string]map[string]interface{}, error) {
+	l := newLoader(raw, base)
+
+	graph, isGraph := raw["$graph"].([]interface{})
+	if !isGraph {
+		resolved, err := l.resolve(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc := resolved.(map[string]interface{})
+		// cwltool --pack writes a lone process without $graph, still
+		// qualifying its ids
+		if id, _ := doc["id"].(string); strings.HasPrefix(id, "#") {
+			relativeIDs(doc)
+		}
+		return doc, nil, nil
+	}
+
+	l.graph = graph
+	l.keepGraphRuns = true
+	processes := make(map[string]map[string]interface{}, len(graph))
+	for _, item := range graph {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := entry["id"].(string)
+		if id == "" {
+			return nil, nil, fmt.Errorf("$graph process has no id")
+		}
+		resolved, err := l.resolveGraphEntry(entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("$graph process %s: %w", id, err)
+		}
+		processes[shortName(id)] = resolved
+	}
+
+	if fragment == "" {
+		if len(processes) == 1 {
+			for id := range processes {
+				fragment = id
+			}
+		} else {
+			fragment = "main"
+		}
+	}
+	entry, ok := processes[fragment]
+	if !ok {
+		return nil, nil, fmt.Errorf("$graph has no process %q", fragment)
+	}
+	return entry, processes, nil
+}
+
+// resolve returns a copy of node with all directives resolved.
+func (l *loader) resolve(node interface{}) (interface{}, error) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$import"].(string); ok {
+			return l.importRef(ref)
+		}
+		if ref, ok := v["$include"].(string); ok {
+			path, err := l.filePath(ref)
+			if err != nil {
+				return nil, err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to include %s: %w", ref, err)
+			}
+			return string(data), nil
+		}
+
+		out := make(map[string]interface{}, len(v))
+		if ref, ok := v["$mixin"].(string); ok {
+			mixed, err := l.importRef(ref)
+			if err != nil {
+				return nil, err
+			}
+			mixin, ok := mixed.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$mixin %s is not a map", ref)
+			}
+			for k, val := range mixin {
+				out[k] = val
+			}
+		}
+
+		// Only workflow steps carry run references; an input or field
+		// named "run" is left alone
+		_, hasIn := v["in"]
+		_, hasOut := v["out"]
+		isStep := hasIn || hasOut
+
+		for k, val := range v {
+			if k == "$mixin" {
+				continue
+			}
+			if run, ok := val.(string); ok && k == "run" && isStep {
+				resolved, err := l.resolveRun(run)
+				if err != nil {
+					return nil, err
+				}
+				out[k] = resolved
+				continue
+			}
+			resolved, err := l.resolve(val)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = resolved
+		}
+		return out, nil
+
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := l.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = resolved
+		}
+		return out, nil
+
+	default:
+		return node, nil
+	}
+}
+
+// importRef loads the document a $import or $mixin refers to. A "#id"
+// reference selects an entry of the current $graph; "file#id" selects an
+// entry of the imported file.
+func (l *loader) importRef(ref string) (interface{}, error) {
+	path, fragment := splitFragment(ref)
+	if path == "" {
+		entry := findFragment(l.graph, fragment)
+		if entry == nil {
+			return nil, fmt.Errorf("reference %q not found in $graph", ref)
+		}
+		return l.resolve(entry)
+	}
+
+	path, err := l.filePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if l.loading[path] {
+		return nil, fmt.Errorf("circular import of %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", ref, err)
+	}
+	var imported interface{}
+	if err := yaml.Unmarshal(data, &imported); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ref, err)
+	}
+
+	child := &loader{base: filepath.Dir(path), version: l.version, loading: l.loading, inlineRuns: l.inlineRuns}
+	if m, ok := imported.(map[string]interface{}); ok {
+		child.graph, _ = m["$graph"].([]interface{})
+		if v, ok := m["cwlVersion"].(string); ok {
+			child.version = v
+		}
+	}
+	if fragment != "" {
+		entry := findFragment(imported, fragment)
+		if entry == nil {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+		imported = entry
+	}
+
+	l.loading[path] = true
+	defer delete(l.loading, path)
+	return child.resolve(imported)
+}
+
+// resolveRun resolves a step's run reference. References into the current
+// $graph are kept or inlined (see keepGraphRuns); file references are
+// inlined (see inlineRuns) or made absolute, keeping any fragment, so
+// ParseFile can load them later.
+func (l *loader) resolveRun(run string) (interface{}, error) {
+	path, fragment := splitFragment(run)
+	if path == "" {
+		entry := findFragment(l.graph, fragment)
+		if entry == nil {
+			return nil, fmt.Errorf("run reference %q not found in $graph", run)
+		}
+		if l.keepGraphRuns {
+			return run, nil
+		}
+		return l.resolveGraphEntry(entry)
+	}
+
+	if l.inlineRuns {
+		imported, err := l.importRef(run)
+		if err != nil {
+			return nil, err
+		}
+		doc, ok := imported.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("run %s is not a process", run)
+		}
+		if _, ok := doc["$graph"]; ok {
+			return nil, fmt.Errorf("run %s is a $graph document; select its process with #id", run)
+		}
+		if _, ok := doc["cwlVersion"]; !ok && l.version != "" {
+			doc["cwlVersion"] = l.version
+		}
+		return doc, nil
+	}
+
+	resolved, err := l.filePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if fragment != "" {
+		resolved += "#" + fragment
+	}
+	return resolved, nil
+}
+
+// resolveGraphEntry resolves a $graph process, giving it the graph's
+// cwlVersion and making its ids relative so it can be parsed on its own.
+func (l *loader) resolveGraphEntry(entry map[string]interface{}) (map[string]interface{}, error) {
+	resolved, err := l.resolve(entry)
+	if err != nil {
+		return nil, err
+	}
+	doc := resolved.(map[string]interface{})
+	relativeIDs(doc)
+	if _, ok := doc["cwlVersion"]; !ok && l.version != "" {
+		doc["cwlVersion"] = l.version
+	}
+	for key, v := range l.metadata {
+		if _, ok := doc[key]; !ok {
+			doc[key] = v
+		}
+	}
+	return doc, nil
+}
+
+// relativeIDs rewrites the ids of a packed process in place. cwltool --pack
+// qualifies every id with the process id ("#main/msg", "#main/say/out",
+// "#echo.cwl/msg"); within the process they are written relative to it
+// ("msg", "say/out"), and step inputs, outputs and scatter relative to
+// their step ("msg"). Ids without the qualifying prefix are left alone.
+func relativeIDs(process map[string]interface{}) {
+	id, _ := process["id"].(string)
+	if id == "" {
+		return
+	}
+	prefix := id + "/"
+
+	for _, key := range []string{"inputs", "outputs"} {
+		for _, param := range listedMaps(process[key]) {
+			param["id"] = trimID(param["id"], prefix)
+			if source, ok := param["outputSource"]; ok {
+				param["outputSource"] = trimID(source, prefix)
+			}
+		}
+	}
+
+	for _, step := range listedMaps(process["steps"]) {
+		stepID, _ := step["id"].(string)
+		stepPrefix := stepID + "/"
+		step["id"] = trimID(stepID, prefix)
+		for _, in := range listedMaps(step["in"]) {
+			in["id"] = trimID(in["id"], stepPrefix)
+			if source, ok := in["source"]; ok {
+				in["source"] = trimID(source, prefix)
+			}
+		}
+		if outs, ok := step["out"].([]interface{}); ok {
+			for i, out := range outs {
+				if m, ok := out.(map[string]interface{}); ok {
+					m["id"] = trimID(m["id"], stepPrefix)
+				} else {
+					outs[i] = trimID(out, stepPrefix)
+				}
+			}
+		}
+		if scatter, ok := step["scatter"]; ok {
+			step["scatter"] = trimID(scatter, stepPrefix)
+		}
+		if run, ok := step["run"].(map[string]interface{}); ok {
+			relativeIDs(run)
+		}
+	}
+}
+
+// listedMaps returns the map entries of a list-form field such as inputs or
+// steps. Map-form fields are keyed by short ids already and yield nothing.
+func listedMaps(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	maps := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			maps = append(maps, m)
+		}
+	}
+	return maps
+}
+
+// trimID removes prefix from an id or reference, or from each of a list of
+// them.
+func trimID(v interface{}, prefix string) interface{} {
+	switch id := v.(type) {
+	case string:
+		return strings.TrimPrefix(id, prefix)
+	case []interface{}:
+		out := make([]interface{}, len(id))
+		for i, item := range id {
+			out[i] = trimID(item, prefix)
+		}
+		return out
+	}
+	return v
+}
+
+// filePath resolves a file reference against the loader's base directory.
+// A document without a base, such as one submitted to the server, may not
+// refer to files at all: resolving them would read the server's own
+// filesystem. Such documents are packed by the client first.
+func (l *loader) filePath(ref string) (string, error) {
+	if l.base == "" {
+		return "", fmt.Errorf("file reference %q not allowed in a document without a base path; pack the document first", ref)
+	}
+	path := strings.TrimPrefix(ref, "file://")
+	if strings.Contains(path, "://") {
+		return "", fmt.Errorf("unsupported reference %q", ref)
+	}
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Join(l.base, path), nil
+}
+
+// PackFile loads the CWL document at path and resolves every reference to
+// another file ($import, $include, $mixin and run), returning a
+// self-contained document that ParseBytes accepts. A $graph document keeps
+// its graph, with "#id" references between processes left as they are.
+func (p *Parser) PackFile(path string) (map[string]interface{}, error) {
+	path, _ = splitFragment(strings.TrimPrefix(path, "file://"))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CWL file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse CWL document: %w", err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve CWL file path: %w", err)
+	}
+
+	l := newLoader(raw, filepath.Dir(abs))
+	l.inlineRuns = true
+	graph, isGraph := raw["$graph"].([]interface{})
+	if !isGraph {
+		resolved, err := l.resolve(raw)
+		if err != nil {
+			return nil, err
+		}
+		return resolved.(map[string]interface{}), nil
+	}
+
+	l.graph = graph
+	l.keepGraphRuns = true
+	packed := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		packed[k] = v
+	}
+	resolved, err := l.resolve(graph)
+	if err != nil {
+		return nil, err
+	}
+	packed["$graph"] = resolved
+	return packed, nil
+}
+
+// splitFragment splits "path#fragment" into its path and fragment.
+func splitFragment(ref string) (string, string) {
+	if i := strings.Index(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// findFragment returns the map whose id or name matches fragment, searching
+// a $graph document, a list of entries, or a single map. It returns nil when
+// fragment is empty or nothing matches.
+func findFragment(doc interface{}, fragment string) map[string]interface{} {
+	if fragment == "" {
+		return nil
+	}
+
+	var entries []interface{}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if graph, ok := v["$graph"].([]interface{}); ok {
+			entries = graph
+		} else {
+			entries = []interface{}{v}
+		}
+	case []interface{}:
+		entries = v
+	}
+
+	for _, entry := range entries {
+		m, ok := entry.(map[string]interface{})
+		if ok && fragmentMatches(m, fragment) {
+			return m
+		}
+	}
+	return nil
+}
+
+// fragmentMatches reports whether a map's id or name refers to fragment.
+func fragmentMatches(m map[string]interface{}, fragment string) bool {
+	for _, key := range []string{"id", "name"} {
+		if id, ok := m[key].(string); ok && shortName(id) == fragment {
+			return true
+		}
+	}
+	return false
+}
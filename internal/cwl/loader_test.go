@@ -0,0 +1,307 @@
+package cwl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParser_ResolvesRunRelativeToDocument(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "tools/echo.cwl", `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: echo
+inputs: []
+outputs: []
+`)
+	wfPath := writeTestFile(t, dir, "workflows/main.cwl", `
+cwlVersion: v1.2
+class: Workflow
+inputs: []
+outputs: []
+steps:
+  say:
+    run: ../tools/echo.cwl
+    in: []
+    out: []
+`)
+
+	doc, err := NewParser().ParseFile(wfPath)
+	if err != nil {
+		t.Fatalf("Failed to parse workflow: %v", err)
+	}
+
+	run, ok := doc.Steps[0].Run.(string)
+	if !ok {
+		t.Fatalf("Expected run to be a path, got %T", doc.Steps[0].Run)
+	}
+	if run != filepath.Join(dir, "tools/echo.cwl") {
+		t.Errorf("Expected run resolved against the workflow, got %s", run)
+	}
+
+	tool, err := NewParser().ParseFile(run)
+	if err != nil {
+		t.Fatalf("Failed to parse resolved run: %v", err)
+	}
+	if tool.Class != ClassCommandLineTool {
+		t.Errorf("Expected CommandLineTool, got %s", tool.Class)
+	}
+}
+
+func TestParser_IncludeAndMixin(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "script.js", "function double(x) { return 2 * x; }")
+	writeTestFile(t, dir, "binding.yml", "position: 3\nprefix: --threads\n")
+	toolPath := writeTestFile(t, dir, "tool.cwl", `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: run
+requirements:
+  InlineJavascriptRequirement:
+    expressionLib:
+      - $include: script.js
+inputs:
+  threads:
+    type: int
+    inputBinding:
+      $mixin: binding.yml
+      position: 1
+outputs: []
+`)
+
+	doc, err := NewParser().ParseFile(toolPath)
+	if err != nil {
+		t.Fatalf("Failed to parse tool: %v", err)
+	}
+
+	if lib := doc.Requirements[0].ExpressionLib; len(lib) != 1 || lib[0] != "function double(x) { return 2 * x; }" {
+		t.Errorf("Expected included expressionLib, got %v", lib)
+	}
+	binding := doc.Inputs[0].InputBinding
+	if binding.Prefix != "--threads" {
+		t.Errorf("Expected prefix from mixin, got %q", binding.Prefix)
+	}
+	if binding.Position != 1 {
+		t.Errorf("Expected local position to override mixin, got %d", binding.Position)
+	}
+}
+
+func TestParser_GraphFragments(t *testing.T) {
+	dir := t.TempDir()
+	packed := writeTestFile(t, dir, "packed.cwl", `
+cwlVersion: v1.2
+$graph:
+  - id: "#echo"
+    class: CommandLineTool
+    baseCommand: echo
+    inputs: []
+    outputs: []
+  - id: "#main"
+    class: Workflow
+    inputs: []
+    outputs: []
+    steps:
+      - id: say
+        run: "#echo"
+        in: []
+        out: []
+`)
+
+	doc, err := NewParser().ParseFile(packed)
+	if err != nil {
+		t.Fatalf("Failed to parse packed workflow: %v", err)
+	}
+	if doc.Class != ClassWorkflow {
+		t.Fatalf("Expected #main workflow, got %s", doc.Class)
+	}
+	step := &doc.Steps[0]
+	if step.Run != "#echo" {
+		t.Errorf("Expected run to reference #echo, got %v", step.Run)
+	}
+	resolved, _, err := NewWorkflowAnalyzer(doc).ResolveStepTool(step)
+	if err != nil {
+		t.Fatalf("Failed to resolve #echo: %v", err)
+	}
+	if resolved.Class != ClassCommandLineTool || resolved.CWLVersion != "v1.2" {
+		t.Errorf("Expected #echo CommandLineTool, got %s %s", resolved.CWLVersion, resolved.Class)
+	}
+
+	tool, err := NewParser().ParseFile(packed + "#echo")
+	if err != nil {
+		t.Fatalf("Failed to parse #echo: %v", err)
+	}
+	if tool.Class != ClassCommandLineTool {
+		t.Errorf("Expected CommandLineTool, got %s", tool.Class)
+	}
+
+	if _, err := NewParser().ParseFile(packed + "#missing"); err == nil {
+		t.Error("Expected error for missing $graph fragment")
+	}
+}
+
+func TestParser_ParseBytesEntryPoint(t *testing.T) {
+	packed := []byte(`{
+  "cwlVersion": "v1.2",
+  "$graph": [
+    {"id": "#main", "class": "Workflow", "inputs": [], "outputs": [],
+     "steps": [{"id": "say", "run": "#echo", "in": [], "out": []}]},
+    {"id": "#echo", "class": "CommandLineTool", "baseCommand": "echo", "inputs": [], "outputs": []}
+  ]
+}`)
+
+	doc, err := NewParser().ParseBytesEntryPoint(packed, "#echo")
+	if err != nil {
+		t.Fatalf("Failed to parse entry point: %v", err)
+	}
+	if doc.Class != ClassCommandLineTool {
+		t.Errorf("Expected CommandLineTool entry point, got %s", doc.Class)
+	}
+
+	if _, err := NewParser().ParseBytes([]byte(`{"cwlVersion": "v1.2", "$graph": [
+    {"id": "#main", "class": "Workflow", "inputs": [], "outputs": [],
+     "steps": [{"id": "say", "run": "#missing", "in": [], "out": []}]}]}`)); err == nil {
+		t.Error("Expected error for dangling run reference")
+	}
+}
+
+func TestParser_ParseBytesRejectsFileReferences(t *testing.T) {
+	docs := map[string]string{
+		"$include": `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: echo
+arguments:
+  - valueFrom: {$include: /etc/passwd}
+inputs: []
+outputs: []
+`,
+		"$import": `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: echo
+requirements:
+  - $import: /etc/cwe-cwl/config.yaml
+inputs: []
+outputs: []
+`,
+		"run": `
+cwlVersion: v1.2
+class: Workflow
+inputs: []
+outputs: []
+steps:
+  say:
+    run: /opt/tools/echo.cwl
+    in: []
+    out: []
+`,
+	}
+
+	for name, doc := range docs {
+		if _, err := NewParser().ParseBytes([]byte(doc)); err == nil {
+			t.Errorf("Expected %s file reference to be rejected", name)
+		}
+	}
+}
+
+func TestParser_PackFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "tools/script.sh", "echo packed\n")
+	writeTestFile(t, dir, "tools/echo.cwl", `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: [sh, -c]
+arguments:
+  - valueFrom: {$include: script.sh}
+inputs: []
+outputs: []
+`)
+	wfPath := writeTestFile(t, dir, "workflows/main.cwl", `
+cwlVersion: v1.2
+class: Workflow
+inputs: []
+outputs: []
+steps:
+  say:
+    run: ../tools/echo.cwl
+    in: []
+    out: []
+`)
+
+	packed, err := NewParser().PackFile(wfPath)
+	if err != nil {
+		t.Fatalf("PackFile failed: %v", err)
+	}
+
+	// The packed document parses without a base path
+	data, err := json.Marshal(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := NewParser().ParseBytes(data)
+	if err != nil {
+		t.Fatalf("Failed to parse packed workflow: %v", err)
+	}
+
+	run, ok := doc.Steps[0].Run.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected run to be inlined, got %T", doc.Steps[0].Run)
+	}
+	args, _ := run["arguments"].([]interface{})
+	if len(args) != 1 || args[0].(map[string]interface{})["valueFrom"] != "echo packed\n" {
+		t.Errorf("Expected $include resolved relative to the tool, got %v", run["arguments"])
+	}
+}
+
+func TestParser_CwltoolPackedIDs(t *testing.T) {
+	doc, err := NewParser().ParseFile(filepath.Join("testdata", "packed-echo.json"))
+	if err != nil {
+		t.Fatalf("Failed to parse packed workflow: %v", err)
+	}
+	if doc.Inputs[0].ID != "msg" || doc.Outputs[0].ID != "out" {
+		t.Errorf("Expected relative workflow ids, got %s and %s", doc.Inputs[0].ID, doc.Outputs[0].ID)
+	}
+	if doc.Outputs[0].OutputSource != "say/out" {
+		t.Errorf("Expected outputSource say/out, got %v", doc.Outputs[0].OutputSource)
+	}
+
+	analyzer := NewWorkflowAnalyzer(doc)
+	if errs := analyzer.ValidateWorkflow(); len(errs) > 0 {
+		t.Fatalf("Expected packed workflow to validate, got %v", errs)
+	}
+
+	greet := &doc.Steps[0]
+	if greet.ID != "greet" || greet.In[0].ID != "msg" || greet.In[0].Source != "names" {
+		t.Errorf("Expected relative step ids, got %s %s %v", greet.ID, greet.In[0].ID, greet.In[0].Source)
+	}
+	if greet.Scatter != "msg" || greet.Out[0] != "out" {
+		t.Errorf("Expected scatter and out relative to the step, got %v %v", greet.Scatter, greet.Out)
+	}
+
+	tool, _, err := analyzer.ResolveStepTool(&doc.Steps[1])
+	if err != nil {
+		t.Fatalf("Failed to resolve #echo.cwl: %v", err)
+	}
+	cmd, err := NewCommandBuilder(tool, map[string]interface{}{"msg": "hello"}).BuildCommand()
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+	if strings.Join(cmd, " ") != "echo hello" {
+		t.Errorf("Expected 'echo hello', got %v", cmd)
+	}
+}
@@ -0,0 +1,288 @@
+package cwl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parseParameterReference splits a parameter reference such as
+// inputs.reads[0]['path'] into its root symbol and the path below it. Path
+// elements are field names (string) or array indexes (int).
+func parseParameterReference(ref string) (string, []interface{}, error) {
+	ref = strings.TrimSpace(ref)
+
+	root, i := scanSymbol(ref, 0)
+	if root == "" {
+		return "", nil, fmt.Errorf("invalid parameter reference %q", ref)
+	}
+
+	var path []interface{}
+	for i < len(ref) {
+		switch ref[i] {
+		case '.':
+			field, next := scanSymbol(ref, i+1)
+			if field == "" {
+				return "", nil, fmt.Errorf("invalid parameter reference %q: expected field name at offset %d", ref, i+1)
+			}
+			path = append(path, field)
+			i = next
+		case '[':
+			if i+1 < len(ref) && (ref[i+1] == '\'' || ref[i+1] == '"') {
+				field, next, err := scanQuotedField(ref, i+1)
+				if err != nil {
+					return "", nil, err
+				}
+				path = append(path, field)
+				i = next
+				continue
+			}
+			end := strings.IndexByte(ref[i:], ']')
+			if end < 0 {
+				return "", nil, fmt.Errorf("invalid parameter reference %q: unterminated index", ref)
+			}
+			index, err := strconv.Atoi(ref[i+1 : i+end])
+			if err != nil || index < 0 {
+				return "", nil, fmt.Errorf("invalid parameter reference %q: bad index %q", ref, ref[i+1:i+end])
+			}
+			path = append(path, index)
+			i += end + 1
+		default:
+			return "", nil, fmt.Errorf("invalid parameter reference %q: unexpected %q at offset %d", ref, ref[i], i)
+		}
+	}
+
+	return root, path, nil
+}
+
+// scanSymbol returns the symbol (letters, digits and underscores) starting
+// at s[i] and the offset just past it.
+func scanSymbol(s string, i int) (string, int) {
+	start := i
+	for i < len(s) {
+		r := rune(s[i])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		i++
+	}
+	return s[start:i], i
+}
+
+// scanQuotedField parses a ['field'] or ["field"] segment whose quote is at
+// s[i], returning the field and the offset just past the closing bracket.
+func scanQuotedField(s string, i int) (string, int, error) {
+	quote := s[i]
+	var field strings.Builder
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if j+1 < len(s) {
+				j++
+				field.WriteByte(s[j])
+			}
+		case quote:
+			if j+1 >= len(s) || s[j+1] != ']' {
+				return "", 0, fmt.Errorf("invalid parameter reference %q: expected ] at offset %d", s, j+1)
+			}
+			return field.String(), j + 2, nil
+		default:
+			field.WriteByte(s[j])
+		}
+	}
+	return "", 0, fmt.Errorf("invalid parameter reference %q: unterminated string", s)
+}
+
+// evaluateReference resolves a parameter reference against inputs, self and
+// runtime without running JavaScript.
+func (ee *ExpressionEvaluator) evaluateReference(ref string) (interface{}, error) {
+	root, path, err := parseParameterReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var current interface{}
+	switch root {
+	case "inputs":
+		current = ee.inputs
+	case "self":
+		current = ee.self
+	case "runtime":
+		current = ee.runtimeContext()
+	default:
+		return nil, fmt.Errorf("unknown parameter reference root %q in %q", root, ref)
+	}
+
+	for _, elem := range path {
+		current, err = referenceElement(current, elem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate reference '%s': %w", ref, err)
+		}
+	}
+
+	return current, nil
+}
+
+// referenceElement returns the field or index elem of value. Missing fields
+// are null; arrays and strings have a length.
+func referenceElement(value interface{}, elem interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("cannot read %v of null", elem)
+	case map[string]interface{}:
+		field, ok := elem.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot index object with %v", elem)
+		}
+		return v[field], nil
+	case []interface{}:
+		switch e := elem.(type) {
+		case int:
+			if e >= len(v) {
+				return nil, fmt.Errorf("index %d out of range for array of length %d", e, len(v))
+			}
+			return v[e], nil
+		case string:
+			if e == "length" {
+				return len(v), nil
+			}
+		}
+		return nil, fmt.Errorf("array has no field %v", elem)
+	case string:
+		if elem == "length" {
+			return len(v), nil
+		}
+		return nil, fmt.Errorf("string has no field %v", elem)
+	default:
+		return nil, fmt.Errorf("cannot read %v of %T", elem, value)
+	}
+}
+
+// CheckParameterReferences reports an error if s contains an expression
+// that is not a plain parameter reference, as required for processes
+// without InlineJavascriptRequirement.
+func CheckParameterReferences(s string) error {
+	if !containsExpression(s) {
+		return nil
+	}
+	segments, err := scanExpressions(s)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if !seg.Expr {
+			continue
+		}
+		if seg.Block {
+			return fmt.Errorf("${...} expression requires InlineJavascriptRequirement")
+		}
+		root, _, err := parseParameterReference(seg.Text)
+		if err != nil || (root != "inputs" && root != "self" && root != "runtime") {
+			return fmt.Errorf("$(%s) is not a parameter reference; JavaScript requires InlineJavascriptRequirement", seg.Text)
+		}
+	}
+	return nil
+}
+
+// validateToolExpressions checks the expressions a CommandLineTool declares.
+// Unless javascript is set, each must be a plain parameter reference.
+func validateToolExpressions(doc *Document, javascript bool) []error {
+	if javascript || doc.HasRequirement("InlineJavascriptRequirement") {
+		return nil
+	}
+
+	var errs []error
+	check := func(where, s string) {
+		if err := CheckParameterReferences(s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", where, err))
+		}
+	}
+
+	for i, arg := range doc.Arguments {
+		check(fmt.Sprintf("argument %d", i), arg.ValueFrom)
+	}
+	for _, in := range doc.Inputs {
+		if in.InputBinding != nil {
+			check("input "+in.ID+" valueFrom", in.InputBinding.ValueFrom)
+		}
+		for _, sf := range in.SecondaryFiles {
+			check("input "+in.ID+" secondaryFiles", sf.Pattern)
+		}
+	}
+	for _, out := range doc.Outputs {
+		if out.OutputBinding != nil {
+			for _, glob := range globPatterns(out.OutputBinding.Glob) {
+				check("output "+out.ID+" glob", glob)
+			}
+			check("output "+out.ID+" outputEval", out.OutputBinding.OutputEval)
+		}
+		for _, sf := range out.SecondaryFiles {
+			check("output "+out.ID+" secondaryFiles", sf.Pattern)
+		}
+	}
+	check("stdin", doc.Stdin)
+	check("stdout", doc.Stdout)
+	check("stderr", doc.Stderr)
+	for _, req := range doc.Requirements {
+		for _, env := range req.EnvDef {
+			check("envDef "+env.EnvName, env.EnvValue)
+		}
+		for _, entry := range listingStrings(req.Listing) {
+			check("InitialWorkDirRequirement listing", entry)
+		}
+		for _, v := range []interface{}{req.CoresMin, req.CoresMax, req.RAMMin, req.RAMMax, req.TmpdirMin, req.TmpdirMax, req.OutdirMin, req.OutdirMax} {
+			if s, ok := v.(string); ok {
+				check("ResourceRequirement", s)
+			}
+		}
+		if s, ok := req.TimeLimit.(string); ok {
+			check("ToolTimeLimit", s)
+		}
+	}
+
+	return errs
+}
+
+// listingStrings returns the strings in an InitialWorkDirRequirement
+// listing: expressions and Dirent entry and entryname fields.
+func listingStrings(listing interface{}) []string {
+	switch v := listing.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var strs []string
+		for _, item := range v {
+			strs = append(strs, listingStrings(item)...)
+		}
+		return strs
+	case map[string]interface{}:
+		var strs []string
+		for _, key := range []string{"entryname", "entry"} {
+			if s, ok := v[key].(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	default:
+		return nil
+	}
+}
+
+// globPatterns returns the strings in a glob, which may be a string or list.
+func globPatterns(glob interface{}) []string {
+	switch v := glob.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var patterns []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				patterns = append(patterns, s)
+			}
+		}
+		return patterns
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package cwl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpressionEvaluator_ParameterReferences(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetRequirements(nil, nil)
+	ee.SetInputs(map[string]interface{}{
+		"sample": "S1",
+		"reads": []interface{}{
+			map[string]interface{}{"class": "File", "path": "/data/r1.fq"},
+			map[string]interface{}{"class": "File", "path": "/data/r2.fq"},
+		},
+		"opts": map[string]interface{}{"min-len": 50},
+	})
+	ee.SetSelf("self.txt")
+
+	testCases := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"$(inputs.sample)", "S1"},
+		{"$(inputs.reads[1].path)", "/data/r2.fq"},
+		{"$(inputs.reads.length)", 2},
+		{"$(inputs['opts'][\"min-len\"])", 50},
+		{"$(self.length)", 8},
+		{"$(inputs.missing)", nil},
+		{"$(runtime.cores)", 1},
+		{"$(inputs.sample)_$(self)", "S1_self.txt"},
+	}
+
+	for _, tc := range testCases {
+		result, err := ee.Evaluate(tc.expr)
+		if err != nil {
+			t.Errorf("Evaluate(%q) failed: %v", tc.expr, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Evaluate(%q) = %#v, expected %#v", tc.expr, result, tc.expected)
+		}
+	}
+}
+
+func TestExpressionEvaluator_RejectsJavascriptWithoutRequirement(t *testing.T) {
+	ee := NewExpressionEvaluator()
+	ee.SetRequirements(nil, nil)
+	ee.SetInputs(map[string]interface{}{"n": 2})
+
+	for _, expr := range []string{
+		"$(inputs.n + 1)",
+		"${ return inputs.n; }",
+		"$(inputs.n.toString())",
+		"$(inputs.missing.field)",
+	} {
+		if result, err := ee.Evaluate(expr); err == nil {
+			t.Errorf("Evaluate(%q) = %v, expected error", expr, result)
+		}
+	}
+
+	ee.SetRequirements([]Requirement{{Class: "InlineJavascriptRequirement"}}, nil)
+	result, err := ee.Evaluate("$(inputs.n + 1)")
+	if err != nil {
+		t.Fatalf("Evaluate failed with InlineJavascriptRequirement: %v", err)
+	}
+	if result != int64(3) {
+		t.Errorf("Expected 3, got %v", result)
+	}
+}
+
+func TestCheckParameterReferences(t *testing.T) {
+	valid := []string{
+		"plain text",
+		"$(inputs.reads[0].path)",
+		"$(runtime.outdir)/$(inputs.name).txt",
+		`\${literal}`,
+	}
+	for _, s := range valid {
+		if err := CheckParameterReferences(s); err != nil {
+			t.Errorf("CheckParameterReferences(%q) = %v, expected nil", s, err)
+		}
+	}
+
+	invalid := []string{
+		"$(inputs.a + inputs.b)",
+		"${ return 1; }",
+		"$(Math.max(1, 2))",
+		"$(inputs.a",
+	}
+	for _, s := range invalid {
+		if err := CheckParameterReferences(s); err == nil {
+			t.Errorf("CheckParameterReferences(%q) = nil, expected error", s)
+		}
+	}
+}
+
+func TestWorkflowAnalyzer_ValidateWorkflow_Javascript(t *testing.T) {
+	tool := map[string]interface{}{
+		"cwlVersion":  "v1.2",
+		"class":       "CommandLineTool",
+		"baseCommand": "echo",
+		"inputs":      map[string]interface{}{"name": "string"},
+		"outputs":     map[string]interface{}{},
+		"arguments":   []interface{}{"$(inputs.name.toUpperCase())"},
+	}
+	doc := &Document{
+		CWLVersion: "v1.2",
+		Class:      ClassWorkflow,
+		Inputs:     []Input{{ID: "name", Type: "string"}},
+		Steps: []WorkflowStep{{
+			ID:   "greet",
+			Run:  tool,
+			In:   []WorkflowStepInput{{ID: "name", Source: "name"}},
+			When: "$(inputs.name.length > 0)",
+		}},
+	}
+
+	errs := NewWorkflowAnalyzer(doc).ValidateWorkflow()
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got: %v", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "InlineJavascriptRequirement") {
+			t.Errorf("Expected error to mention InlineJavascriptRequirement, got: %v", err)
+		}
+	}
+
+	// The workflow's requirement applies to its steps and their tools
+	doc.Requirements = []Requirement{{Class: "InlineJavascriptRequirement"}}
+	if errs := NewWorkflowAnalyzer(doc).ValidateWorkflow(); len(errs) != 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+}
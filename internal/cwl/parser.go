@@ -14,25 +14,28 @@ import (
 )
 
 // Parser parses CWL documents.
-type Parser struct {
-	basePath string
-}
+type Parser struct{}
 
 // NewParser creates a new CWL parser.
 func NewParser() *Parser {
 	return &Parser{}
 }
 
-// ParseFile parses a CWL document from a file.
+// ParseFile parses a CWL document from a file. A "#id" suffix selects a
+// process from a $graph document. References in the document resolve
+// relative to the file's directory.
 func (p *Parser) ParseFile(path string) (*Document, error) {
-	f, err := os.Open(path)
+	path, fragment := splitFragment(strings.TrimPrefix(path, "file://"))
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open CWL file: %w", err)
 	}
-	defer f.Close()
 
-	p.basePath = filepath.Dir(path)
-	return p.Parse(f)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve CWL file path: %w", err)
+	}
+	return p.parseBytes(data, fragment, filepath.Dir(abs))
 }
 
 // Parse parses a CWL document from a reader.
@@ -45,15 +48,42 @@ func (p *Parser) Parse(r io.Reader) (*Document, error) {
 	return p.ParseBytes(data)
 }
 
-// ParseBytes parses a CWL document from bytes.
+// ParseBytes parses a CWL document from bytes. For a packed $graph
+// document the "#main" process is returned. The document has no base path,
+// so it must not refer to other files; see PackFile.
 func (p *Parser) ParseBytes(data []byte) (*Document, error) {
+	return p.parseBytes(data, "", "")
+}
+
+// ParseBytesEntryPoint parses a CWL document from bytes, returning the
+// $graph process with the given id (with or without a leading "#"). An
+// empty entry point behaves like ParseBytes.
+func (p *Parser) ParseBytesEntryPoint(data []byte, entryPoint string) (*Document, error) {
+	return p.parseBytes(data, strings.TrimPrefix(entryPoint, "#"), "")
+}
+
+// parseBytes parses a CWL document, selecting the process named by
+// fragment if the document is a $graph. File references resolve against
+// base; with no base they are rejected.
+func (p *Parser) parseBytes(data []byte, fragment, base string) (*Document, error) {
 	// Try YAML first (which also handles JSON)
 	var raw map[string]interface{}
 	if err := yaml.Unmarshal(data, &raw); err != nil {
 		return nil, fmt.Errorf("failed to parse CWL document: %w", err)
 	}
 
-	return p.parseDocument(raw)
+	// Resolve $import, $include, $mixin and run references
+	loaded, graph, err := loadDocument(raw, fragment, base)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := p.parseDocument(loaded)
+	if err != nil {
+		return nil, err
+	}
+	doc.graph = graph
+	return doc, nil
 }
 
 // ParseString parses a CWL document from a string.
@@ -95,11 +125,26 @@ func (p *Parser) parseDocument(raw map[string]interface{}) (*Document, error) {
 		doc.Doc = docStr
 	}
 
+	// Parse $namespaces and $schemas
+	namespaces, err := parseNamespaces(raw["$namespaces"])
+	if err != nil {
+		return nil, err
+	}
+	doc.Namespaces = namespaces
+	schemas, err := parseSchemas(raw["$schemas"])
+	if err != nil {
+		return nil, err
+	}
+	doc.Schemas = schemas
+
 	// Parse inputs
 	inputs, err := p.parseInputs(raw["inputs"])
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse inputs: %w", err)
 	}
+	for i := range inputs {
+		inputs[i].Format = doc.expandFormat(inputs[i].Format)
+	}
 	doc.Inputs = inputs
 
 	// Parse outputs
@@ -107,6 +152,9 @@ func (p *Parser) parseDocument(raw map[string]interface{}) (*Document, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse outputs: %w", err)
 	}
+	for i := range outputs {
+		outputs[i].Format = doc.expandFormat(outputs[i].Format)
+	}
 	doc.Outputs = outputs
 
 	// Parse requirements
@@ -148,6 +196,48 @@ func (p *Parser) parseDocument(raw map[string]interface{}) (*Document, error) {
 	return doc, nil
 }
 
+// parseNamespaces parses a document's $namespaces map of prefixes to IRIs.
+func parseNamespaces(raw interface{}) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("$namespaces must be a map, got %T", raw)
+	}
+	namespaces := make(map[string]string, len(m))
+	for prefix, v := range m {
+		iri, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("$namespaces %s must be a string, got %T", prefix, v)
+		}
+		namespaces[prefix] = iri
+	}
+	return namespaces, nil
+}
+
+// parseSchemas parses a document's $schemas, a string or list of strings.
+func parseSchemas(raw interface{}) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		schemas := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("$schemas entries must be strings, got %T", item)
+			}
+			schemas = append(schemas, s)
+		}
+		return schemas, nil
+	default:
+		return nil, fmt.Errorf("$schemas must be a string or list, got %T", raw)
+	}
+}
+
 // parseInputs parses CWL inputs (handles both array and map formats).
 func (p *Parser) parseInputs(raw interface{}) ([]Input, error) {
 	if raw == nil {
@@ -589,7 +679,11 @@ func (p *Parser) parseRequirement(m map[string]interface{}) (Requirement, error)
 
 	// SchemaDefRequirement
 	if types, ok := m["types"]; ok {
-		req.Types = []interface{}{types}
+		resolved, err := p.resolveSchemaTypes(types)
+		if err != nil {
+			return req, fmt.Errorf("SchemaDefRequirement: %w", err)
+		}
+		req.Types = resolved
 	}
 
 	// InitialWorkDirRequirement
@@ -628,9 +722,40 @@ func (p *Parser) parseRequirement(m map[string]interface{}) (Requirement, error)
 		req.TimeLimit = tl
 	}
 
+	// LoadListingRequirement
+	if ll, ok := m["loadListing"].(string); ok {
+		req.LoadListing = ll
+	}
+
 	return req, nil
 }
 
+// resolveSchemaTypes flattens SchemaDefRequirement types into a list of
+// type definitions. Imported type files have already been loaded in place
+// and may contribute nested lists.
+func (p *Parser) resolveSchemaTypes(raw interface{}) ([]interface{}, error) {
+	items, ok := raw.([]interface{})
+	if !ok {
+		items = []interface{}{raw}
+	}
+
+	var types []interface{}
+	for _, item := range items {
+		switch v := item.(type) {
+		case map[string]interface{}:
+			types = append(types, v)
+		case []interface{}:
+			nested, err := p.resolveSchemaTypes(v)
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, nested...)
+		}
+	}
+
+	return types, nil
+}
+
 // parseCommandLineTool parses CommandLineTool-specific fields.
 func (p *Parser) parseCommandLineTool(doc *Document, raw map[string]interface{}) error {
 	// baseCommand can be string or array of strings
@@ -685,21 +810,32 @@ func (p *Parser) parseCommandLineTool(doc *Document, raw map[string]interface{})
 		doc.Stderr = stderr
 	}
 
-	// successCodes
-	if sc, ok := raw["successCodes"].([]interface{}); ok {
-		for _, code := range sc {
-			switch v := code.(type) {
-			case int:
-				doc.SuccessCodes = append(doc.SuccessCodes, v)
-			case float64:
-				doc.SuccessCodes = append(doc.SuccessCodes, int(v))
-			}
-		}
-	}
+	// successCodes, temporaryFailCodes, permanentFailCodes
+	doc.SuccessCodes = parseExitCodes(raw["successCodes"])
+	doc.TemporaryFailCodes = parseExitCodes(raw["temporaryFailCodes"])
+	doc.PermanentFailCodes = parseExitCodes(raw["permanentFailCodes"])
 
 	return nil
 }
 
+// parseExitCodes parses a list of exit codes.
+func parseExitCodes(raw interface{}) []int {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil
+	}
+	var codes []int
+	for _, code := range list {
+		switch v := code.(type) {
+		case int:
+			codes = append(codes, v)
+		case float64:
+			codes = append(codes, int(v))
+		}
+	}
+	return codes
+}
+
 // parseWorkflow parses Workflow-specific fields.
 func (p *Parser) parseWorkflow(doc *Document, raw map[string]interface{}) error {
 	steps, ok := raw["steps"]
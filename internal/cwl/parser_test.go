@@ -3,6 +3,7 @@ package cwl
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -203,6 +204,137 @@ outputs:
 	}
 }
 
+func TestParser_ExitCodes(t *testing.T) {
+	parser := NewParser()
+
+	cwlDoc := `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: grep
+successCodes: [0, 1]
+temporaryFailCodes: [75]
+permanentFailCodes: [2]
+inputs: []
+outputs: []
+`
+
+	doc, err := parser.ParseBytes([]byte(cwlDoc))
+	if err != nil {
+		t.Fatalf("Failed to parse CWL bytes: %v", err)
+	}
+
+	if !reflect.DeepEqual(doc.SuccessCodes, []int{0, 1}) {
+		t.Errorf("Expected successCodes [0 1], got %v", doc.SuccessCodes)
+	}
+	if !reflect.DeepEqual(doc.TemporaryFailCodes, []int{75}) {
+		t.Errorf("Expected temporaryFailCodes [75], got %v", doc.TemporaryFailCodes)
+	}
+	if !reflect.DeepEqual(doc.PermanentFailCodes, []int{2}) {
+		t.Errorf("Expected permanentFailCodes [2], got %v", doc.PermanentFailCodes)
+	}
+	if status := doc.ClassifyExitCode(1); status != ExitSuccess {
+		t.Errorf("Expected exit code 1 to succeed, got %s", status)
+	}
+}
+
+func TestParser_Namespaces(t *testing.T) {
+	parser := NewParser()
+
+	cwlDoc := `
+cwlVersion: v1.2
+class: CommandLineTool
+$namespaces:
+  edam: http://edamontology.org/
+$schemas:
+  - https://edamontology.org/EDAM_1.25.owl
+baseCommand: bwa
+inputs:
+  reference:
+    type: File
+    format: edam:format_1929
+  reads:
+    type: File
+    format: [edam:format_1930, edam:format_1929]
+outputs:
+  alignment:
+    type: File
+    format: $(inputs.reads.format)
+    outputBinding:
+      glob: out.sam
+`
+
+	doc, err := parser.ParseBytes([]byte(cwlDoc))
+	if err != nil {
+		t.Fatalf("Failed to parse CWL bytes: %v", err)
+	}
+
+	if doc.Namespaces["edam"] != EDAMNamespace {
+		t.Errorf("Expected edam namespace, got %v", doc.Namespaces)
+	}
+	if !reflect.DeepEqual(doc.Schemas, []string{"https://edamontology.org/EDAM_1.25.owl"}) {
+		t.Errorf("Unexpected $schemas %v", doc.Schemas)
+	}
+
+	formats := map[string]interface{}{}
+	for _, in := range doc.Inputs {
+		formats[in.ID] = in.Format
+	}
+	if formats["reference"] != "http://edamontology.org/format_1929" {
+		t.Errorf("Expected expanded reference format, got %v", formats["reference"])
+	}
+	expected := []interface{}{"http://edamontology.org/format_1930", "http://edamontology.org/format_1929"}
+	if !reflect.DeepEqual(formats["reads"], expected) {
+		t.Errorf("Expected expanded reads formats, got %v", formats["reads"])
+	}
+	if doc.Outputs[0].Format != "$(inputs.reads.format)" {
+		t.Errorf("Expected format expression left as is, got %v", doc.Outputs[0].Format)
+	}
+}
+
+func TestParser_SchemaDefImport(t *testing.T) {
+	dir := t.TempDir()
+	typesYAML := `
+- name: SampleSheet
+  type: record
+  fields:
+    sample_id: string
+    reads: File
+`
+	if err := os.WriteFile(filepath.Join(dir, "types.yml"), []byte(typesYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	toolYAML := `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: echo
+requirements:
+  SchemaDefRequirement:
+    types:
+      - $import: types.yml
+inputs:
+  sheet:
+    type: types.yml#SampleSheet
+outputs: []
+`
+	toolPath := filepath.Join(dir, "tool.cwl")
+	if err := os.WriteFile(toolPath, []byte(toolYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := NewParser().ParseFile(toolPath)
+	if err != nil {
+		t.Fatalf("Failed to parse tool: %v", err)
+	}
+
+	sheetType, err := doc.SchemaDefs().ParseType(doc.Inputs[0].Type)
+	if err != nil {
+		t.Fatalf("Failed to resolve imported type: %v", err)
+	}
+	if sheetType.Type != TypeRecord || len(sheetType.Fields) != 2 {
+		t.Errorf("Expected record with 2 fields, got %+v", sheetType)
+	}
+}
+
 func TestParser_ParseWorkflowWithMapInputs(t *testing.T) {
 	parser := NewParser()
 
@@ -221,7 +353,13 @@ outputs:
     outputSource: step1/out
 steps:
   step1:
-    run: tool.cwl
+    run:
+      class: CommandLineTool
+      baseCommand: cat
+      inputs:
+        in1: File
+      outputs:
+        out: stdout
     in:
       in1: input1
     out: [out]
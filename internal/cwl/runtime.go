@@ -0,0 +1,205 @@
+package cwl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resources are the resources a tool runs with, from its
+// ResourceRequirement. Cores, RAM, OutdirSize and TmpdirSize are the
+// minimums to reserve; the Max fields are the most the tool can use. RAM and
+// the directory sizes are in mebibytes.
+type Resources struct {
+	Cores      int
+	CoresMax   int
+	RAM        int
+	RAMMax     int
+	OutdirSize int
+	OutdirMax  int
+	TmpdirSize int
+	TmpdirMax  int
+}
+
+// defaultResources apply to tools that do not request a resource.
+var defaultResources = Resources{
+	Cores: 1, CoresMax: 1,
+	RAM: 4096, RAMMax: 4096,
+	OutdirSize: 1024, OutdirMax: 1024,
+	TmpdirSize: 1024, TmpdirMax: 1024,
+}
+
+// DefaultResources returns the resources of a tool without a
+// ResourceRequirement.
+func DefaultResources() Resources {
+	return defaultResources
+}
+
+// GetResources returns the tool's resources without inputs bound, so
+// expressions that read inputs fall back to the defaults. Use
+// ResolveResources to size a node.
+func (doc *Document) GetResources() Resources {
+	res, err := doc.ResolveResources(nil)
+	if err != nil {
+		return defaultResources
+	}
+	return res
+}
+
+// ResolveResources evaluates the tool's ResourceRequirement with inputs
+// bound. Each value may be a number or an expression. A min without a max
+// (or a max without a min) sets both, and fractional values are rounded up,
+// so runtime.cores is always a whole number of at least one.
+func (doc *Document) ResolveResources(inputs map[string]interface{}) (Resources, error) {
+	res := defaultResources
+	req := doc.GetResourceRequirement()
+	if req == nil {
+		return res, nil
+	}
+
+	ee := NewExpressionEvaluator()
+	ee.SetInputs(inputs)
+	ee.SetRequirements(doc.Requirements, doc.Hints)
+
+	ranges := []struct {
+		name     string
+		min, max interface{}
+		minOut   *int
+		maxOut   *int
+	}{
+		{"cores", req.CoresMin, req.CoresMax, &res.Cores, &res.CoresMax},
+		{"ram", req.RAMMin, req.RAMMax, &res.RAM, &res.RAMMax},
+		{"outdir", req.OutdirMin, req.OutdirMax, &res.OutdirSize, &res.OutdirMax},
+		{"tmpdir", req.TmpdirMin, req.TmpdirMax, &res.TmpdirSize, &res.TmpdirMax},
+	}
+	for _, r := range ranges {
+		min, hasMin, err := resourceValue(ee, r.min)
+		if err != nil {
+			return res, fmt.Errorf("ResourceRequirement %sMin: %w", r.name, err)
+		}
+		max, hasMax, err := resourceValue(ee, r.max)
+		if err != nil {
+			return res, fmt.Errorf("ResourceRequirement %sMax: %w", r.name, err)
+		}
+
+		switch {
+		case hasMin && !hasMax:
+			max = min
+		case hasMax && !hasMin:
+			min = max
+		case !hasMin && !hasMax:
+			continue
+		}
+		if max < min {
+			return res, fmt.Errorf("ResourceRequirement %sMax %v is less than %sMin %v", r.name, max, r.name, min)
+		}
+		*r.minOut = int(math.Ceil(min))
+		*r.maxOut = int(math.Ceil(max))
+	}
+
+	if res.Cores < 1 {
+		res.Cores = 1
+	}
+	if res.CoresMax < res.Cores {
+		res.CoresMax = res.Cores
+	}
+
+	return res, nil
+}
+
+// ResolveTimeLimit evaluates the tool's ToolTimeLimit with inputs bound.
+// Zero means the tool may run indefinitely.
+func (doc *Document) ResolveTimeLimit(inputs map[string]interface{}) (time.Duration, error) {
+	req := doc.GetToolTimeLimit()
+	if req == nil {
+		return 0, nil
+	}
+
+	ee := NewExpressionEvaluator()
+	ee.SetInputs(inputs)
+	ee.SetRequirements(doc.Requirements, doc.Hints)
+
+	seconds, _, err := resourceValue(ee, req.TimeLimit)
+	if err != nil {
+		return 0, fmt.Errorf("ToolTimeLimit timelimit: %w", err)
+	}
+	return time.Duration(math.Ceil(seconds)) * time.Second, nil
+}
+
+// resourceValue returns the number a ResourceRequirement or ToolTimeLimit
+// value specifies, evaluating it if it is an expression. Unset values and
+// expressions that yield null report false.
+func resourceValue(ee *ExpressionEvaluator, v interface{}) (float64, bool, error) {
+	if s, ok := v.(string); ok {
+		result, err := ee.Evaluate(s)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to evaluate %q: %w", s, err)
+		}
+		v = result
+	}
+
+	var value float64
+	switch val := v.(type) {
+	case nil:
+		return 0, false, nil
+	case int:
+		value = float64(val)
+	case int64:
+		value = float64(val)
+	case float64:
+		value = val
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%q is not a number", val)
+		}
+		value = parsed
+	default:
+		return 0, false, fmt.Errorf("expected a number, got %T", v)
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return 0, false, fmt.Errorf("invalid value %v", value)
+	}
+	return value, true, nil
+}
+
+// RuntimeContext returns the runtime object seen by expressions of a tool
+// running with these resources in outdir and tmpdir.
+func (r Resources) RuntimeContext(outdir, tmpdir string) map[string]interface{} {
+	return map[string]interface{}{
+		"outdir":     outdir,
+		"tmpdir":     tmpdir,
+		"cores":      r.Cores,
+		"ram":        r.RAM,
+		"outdirSize": r.OutdirSize,
+		"tmpdirSize": r.TmpdirSize,
+	}
+}
+
+// Directories seen as runtime.outdir and runtime.tmpdir by expressions
+// evaluated outside a tool's working directory, such as those of
+// ExpressionTools run by the scheduler.
+const (
+	DefaultOutdir = "/output"
+	DefaultTmpdir = "/tmp"
+)
+
+// Placeholders for runtime.outdir and runtime.tmpdir in commands built
+// before the tool's directories are known, such as those run by
+// cwl-step-runner on a compute node. ReplaceRuntimePaths substitutes them.
+const (
+	OutdirPlaceholder = "@@CWL_RUNTIME_OUTDIR@@"
+	TmpdirPlaceholder = "@@CWL_RUNTIME_TMPDIR@@"
+)
+
+// ReplaceRuntimePaths replaces the runtime path placeholders in s.
+func ReplaceRuntimePaths(s, outdir, tmpdir string) string {
+	if !strings.Contains(s, "@@CWL_RUNTIME_") {
+		return s
+	}
+	s = strings.ReplaceAll(s, OutdirPlaceholder, outdir)
+	return strings.ReplaceAll(s, TmpdirPlaceholder, tmpdir)
+}
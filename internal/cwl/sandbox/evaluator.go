@@ -3,6 +3,7 @@ package sandbox
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 // Mode determines the isolation level for expression evaluation.
@@ -52,6 +53,15 @@ func DefaultEvaluatorConfig() EvaluatorConfig {
 	}
 }
 
+// Timeout returns the per-expression timeout for the configured mode. The
+// in-process evaluator uses the process timeout.
+func (c EvaluatorConfig) Timeout() time.Duration {
+	if c.Mode == ModeContainer {
+		return c.Container.Timeout
+	}
+	return c.Process.Timeout
+}
+
 // NewEvaluator creates an expression evaluator based on configuration.
 func NewEvaluator(cfg EvaluatorConfig) (Evaluator, error) {
 	switch cfg.Mode {
@@ -71,21 +81,23 @@ func NewEvaluator(cfg EvaluatorConfig) (Evaluator, error) {
 
 // InProcessEvaluator runs expressions in the same process.
 // This is fast but provides no isolation - use only for trusted expressions.
-type InProcessEvaluator struct{}
+type InProcessEvaluator struct {
+	programs *programCache
+	vms      *vmCache
+}
 
 // NewInProcessEvaluator creates an in-process evaluator.
 func NewInProcessEvaluator() *InProcessEvaluator {
-	return &InProcessEvaluator{}
+	programs := newProgramCache()
+	return &InProcessEvaluator{programs: programs, vms: newVMCache(programs)}
 }
 
 // Evaluate runs an expression in the current process.
 func (e *InProcessEvaluator) Evaluate(ctx context.Context, req Request) (interface{}, error) {
-	vm := createSandboxedVM()
-
-	// Set up context variables
-	vm.Set("inputs", req.Inputs)
-	vm.Set("self", req.Self)
-	vm.Set("runtime", req.Runtime)
+	vm, key, err := e.vms.acquire(req.ExpressionLib)
+	if err != nil {
+		return nil, err
+	}
 
 	// Use a goroutine with timeout
 	type result struct {
@@ -102,12 +114,11 @@ func (e *InProcessEvaluator) Evaluate(ctx context.Context, req Request) (interfa
 			}
 		}()
 
-		val, err := vm.RunString(req.Expression)
-		if err != nil {
-			resultCh <- result{err: err}
-			return
+		val, err := runRequest(vm, e.programs, req)
+		if err == nil {
+			e.vms.release(key, vm)
 		}
-		resultCh <- result{value: val.Export()}
+		resultCh <- result{value: val, err: err}
 	}()
 
 	select {
@@ -0,0 +1,66 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// maxCachedPrograms bounds the number of compiled programs a cache holds;
+// the cache is emptied when it fills.
+const maxCachedPrograms = 4096
+
+// programCache holds compiled expressionLib and expression programs keyed
+// by the hash of their source. Compiled programs are not tied to a runtime,
+// so one cache serves every VM a worker creates; a scatter evaluates the
+// same few sources once per element.
+type programCache struct {
+	mu       sync.Mutex
+	programs map[string]*goja.Program
+}
+
+func newProgramCache() *programCache {
+	return &programCache{programs: make(map[string]*goja.Program)}
+}
+
+// compileLibrary returns the compiled program for an expressionLib.
+func (c *programCache) compileLibrary(lib []string) (*goja.Program, error) {
+	return c.compile("expressionLib", librarySource(lib))
+}
+
+// librarySource joins an expressionLib into a single script.
+func librarySource(lib []string) string {
+	return strings.Join(lib, ";\n")
+}
+
+// compile returns the compiled program for source, compiling it under name
+// on first use.
+func (c *programCache) compile(name, source string) (*goja.Program, error) {
+	key := sourceKey(name, source)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prog, ok := c.programs[key]; ok {
+		return prog, nil
+	}
+
+	prog, err := goja.Compile(name, source, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.programs) >= maxCachedPrograms {
+		c.programs = make(map[string]*goja.Program)
+	}
+	c.programs[key] = prog
+	return prog, nil
+}
+
+// sourceKey returns the cache key for source compiled under name.
+func sourceKey(name, source string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + source))
+	return hex.EncodeToString(sum[:])
+}
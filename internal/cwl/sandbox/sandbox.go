@@ -36,7 +36,7 @@ type Config struct {
 	MaxOutputBytes int `mapstructure:"max_output_bytes"`
 
 	// WorkerBinary is the path to the sandbox worker binary.
-	// If empty, uses the built-in worker mode.
+	// If empty, the running executable is started as the worker.
 	WorkerBinary string `mapstructure:"worker_binary"`
 }
 
@@ -57,6 +57,9 @@ type Request struct {
 	Inputs     map[string]interface{} `json:"inputs"`
 	Self       interface{}            `json:"self"`
 	Runtime    map[string]interface{} `json:"runtime"`
+
+	// ExpressionLib is loaded before Expression; workers cache both compiled.
+	ExpressionLib []string `json:"expressionLib,omitempty"`
 }
 
 // Response is returned from worker processes.
@@ -108,16 +111,15 @@ func NewPool(cfg Config) (*Pool, error) {
 func (p *Pool) startWorker() (*worker, error) {
 	binary := p.config.WorkerBinary
 	if binary == "" {
-		// Use self with --sandbox-worker flag
+		// Use self, which must call RunWorkerIfRequested
 		binary = os.Args[0]
 	}
 
-	cmd := exec.Command(binary, "--sandbox-worker")
+	cmd := exec.Command(binary, WorkerFlag)
 
-	// Set resource limits via environment (worker enforces via rlimit)
+	// Set the memory limit via environment (worker enforces via rlimit)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SANDBOX_MEMORY_MB=%d", p.config.MaxMemoryMB),
-		fmt.Sprintf("SANDBOX_TIMEOUT_SEC=%d", int(p.config.Timeout.Seconds())),
 	)
 
 	stdin, err := cmd.StdinPipe()
@@ -164,15 +166,18 @@ func (p *Pool) Evaluate(ctx context.Context, req Request) (interface{}, error) {
 		return nil, ErrPoolExhausted
 	}
 
-	// Ensure worker is returned to pool (or replaced if crashed)
+	// Ensure worker is returned to pool, or replaced if it was killed or
+	// crashed
+	healthy := false
 	defer func() {
-		if w.cmd.ProcessState != nil && w.cmd.ProcessState.Exited() {
-			// Worker crashed, start a new one
-			if newW, err := p.startWorker(); err == nil {
-				p.workers <- newW
-			}
-		} else {
+		if healthy {
 			p.workers <- w
+			return
+		}
+		w.cmd.Process.Kill()
+		w.cmd.Wait()
+		if newW, err := p.startWorker(); err == nil {
+			p.workers <- newW
 		}
 	}()
 
@@ -197,22 +202,20 @@ func (p *Pool) Evaluate(ctx context.Context, req Request) (interface{}, error) {
 	// Wait for result or timeout
 	select {
 	case resp := <-resultCh:
+		healthy = true
 		if resp.Error != "" {
 			return nil, errors.New(resp.Error)
 		}
 		return resp.Result, nil
 
 	case err := <-errCh:
-		// Kill the worker - it may be hung
-		w.cmd.Process.Kill()
+		// The worker may be hung or have crashed
 		return nil, err
 
 	case <-ctx.Done():
-		w.cmd.Process.Kill()
 		return nil, ErrTimeout
 
 	case <-time.After(p.config.Timeout):
-		w.cmd.Process.Kill()
 		return nil, ErrTimeout
 	}
 }
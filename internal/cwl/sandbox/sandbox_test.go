@@ -2,10 +2,20 @@ package sandbox
 
 import (
 	"context"
+	"errors"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
 
+// TestMain lets the test binary serve as its own sandbox worker, since Pool
+// starts workers by running itself with --sandbox-worker.
+func TestMain(m *testing.M) {
+	RunWorkerIfRequested()
+	os.Exit(m.Run())
+}
+
 func TestInProcessEvaluator_SimpleExpression(t *testing.T) {
 	eval := NewInProcessEvaluator()
 	defer eval.Close()
@@ -361,6 +371,135 @@ func TestInProcessEvaluator_CWLExpressions(t *testing.T) {
 	}
 }
 
+func TestInProcessEvaluator_Builtins(t *testing.T) {
+	eval := NewInProcessEvaluator()
+	defer eval.Close()
+
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"inputs.files.map(function(f) { return f.toUpperCase(); }).join(',')", "A.FQ,B.FQ"},
+		{"String(inputs.n).padStart(4, '0')", "0007"},
+		{"Math.log(Math.E * Math.E)", int64(2)},
+		{"Array.isArray(inputs.files) && Object.keys(inputs).length", int64(2)},
+		{"JSON.parse('[1,2]').length", int64(2)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			req := Request{
+				Expression: tc.expr,
+				Inputs: map[string]interface{}{
+					"files": []interface{}{"a.fq", "b.fq"},
+					"n":     7,
+				},
+			}
+
+			result, err := eval.Evaluate(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected %v (%T), got %v (%T)", tc.expected, tc.expected, result, result)
+			}
+		})
+	}
+}
+
+func TestInProcessEvaluator_ExpressionLib(t *testing.T) {
+	eval := NewInProcessEvaluator()
+	defer eval.Close()
+
+	lib := []string{"function stem(f) { return f.replace(/\\.[^.]*$/, ''); }"}
+	for _, name := range []string{"a.fq", "b.bam"} {
+		req := Request{
+			Expression:    "stem(inputs.name)",
+			Inputs:        map[string]interface{}{"name": name},
+			ExpressionLib: lib,
+		}
+		result, err := eval.Evaluate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if result != name[:1] {
+			t.Errorf("Expected %q, got %v", name[:1], result)
+		}
+	}
+
+	// The library and the expression are compiled once and reused
+	if len(eval.programs.programs) != 2 {
+		t.Errorf("Expected 2 cached programs, got %d", len(eval.programs.programs))
+	}
+
+	_, err := eval.Evaluate(context.Background(), Request{
+		Expression:    "1",
+		ExpressionLib: []string{"function broken( {"},
+	})
+	if err == nil {
+		t.Error("Expected error for invalid expression library")
+	}
+}
+
+func TestInProcessEvaluator_LibraryLoadedOnce(t *testing.T) {
+	eval := NewInProcessEvaluator()
+	defer eval.Close()
+
+	lib := []string{"var loads = (typeof loads === 'undefined' ? 0 : loads) + 1;"}
+	requests := []Request{
+		{Expression: "[loads, inputs.n, self]", Inputs: map[string]interface{}{"n": "a"}, Self: "first", ExpressionLib: lib},
+		{Expression: "[loads, inputs.n, self]", Inputs: map[string]interface{}{"n": "b"}, ExpressionLib: lib},
+		{Expression: "[loads, inputs.n, self]", Inputs: map[string]interface{}{"n": "c"}, Self: "third", ExpressionLib: lib},
+	}
+	for i, req := range requests {
+		result, err := eval.Evaluate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		got := result.([]interface{})
+		if got[0] != int64(1) {
+			t.Errorf("Expected the library to run once, got loads=%v on evaluation %d", got[0], i)
+		}
+		if got[1] != req.Inputs["n"] || got[2] != req.Self {
+			t.Errorf("Expected inputs and self from evaluation %d, got %v", i, got[1:])
+		}
+	}
+
+	// A different library gets its own VM
+	result, err := eval.Evaluate(context.Background(), Request{
+		Expression:    "typeof loads",
+		ExpressionLib: []string{"var other = 1;"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result != "undefined" {
+		t.Errorf("Expected libraries to be isolated, got typeof loads = %v", result)
+	}
+}
+
+func TestInProcessEvaluator_FailedVMDiscarded(t *testing.T) {
+	eval := NewInProcessEvaluator()
+	defer eval.Close()
+
+	lib := []string{"var state = 'clean';"}
+	_, err := eval.Evaluate(context.Background(), Request{
+		Expression:    "(function() { state = 'dirty'; throw new Error('boom'); })()",
+		ExpressionLib: lib,
+	})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	result, err := eval.Evaluate(context.Background(), Request{Expression: "state", ExpressionLib: lib})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result != "clean" {
+		t.Errorf("Expected a fresh VM after a failed evaluation, got state = %v", result)
+	}
+}
+
 func TestDefaultConfigs(t *testing.T) {
 	// Verify default configs are sensible
 	procCfg := DefaultConfig()
@@ -385,3 +524,123 @@ func TestDefaultConfigs(t *testing.T) {
 		t.Error("Rootfs should be read-only by default")
 	}
 }
+
+func TestNewEvaluator_DefaultConfig(t *testing.T) {
+	eval, err := NewEvaluator(DefaultEvaluatorConfig())
+	if err != nil {
+		t.Fatalf("NewEvaluator failed: %v", err)
+	}
+	defer eval.Close()
+
+	if _, ok := eval.(*Pool); !ok {
+		t.Fatalf("Expected a worker pool by default, got %T", eval)
+	}
+	result, err := eval.Evaluate(context.Background(), Request{
+		Expression:    "greet(inputs.name)",
+		Inputs:        map[string]interface{}{"name": "world"},
+		ExpressionLib: []string{"function greet(n) { return 'hello ' + n; }"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result != "hello world" {
+		t.Errorf("Expected 'hello world', got %v", result)
+	}
+}
+
+func TestPool_ReplacesTimedOutWorker(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.Timeout = 200 * time.Millisecond
+	pool, err := NewPool(cfg)
+	if err != nil {
+		t.Fatalf("NewPool failed: %v", err)
+	}
+	defer pool.Close()
+
+	_, err = pool.Evaluate(context.Background(), Request{Expression: "while (true) {}"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+
+	result, err := pool.Evaluate(context.Background(), Request{Expression: "1 + 2"})
+	if err != nil {
+		t.Fatalf("Evaluate after timeout failed: %v", err)
+	}
+	if toFloat64(result) != 3 {
+		t.Errorf("Expected 3, got %v", result)
+	}
+}
+
+func TestProgramCache_Reuse(t *testing.T) {
+	programs := newProgramCache()
+	first, err := programs.compile("", "1 + 1")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	second, err := programs.compile("", "1 + 1")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if first != second {
+		t.Error("Expected the cached program to be reused")
+	}
+
+	if _, err := programs.compile("", "function ("); err == nil {
+		t.Error("Expected compile error for invalid source")
+	}
+}
+
+// benchmarkLib is a scatter-style expression library that counts how many
+// times it is loaded in libLoads.
+var benchmarkLib = []string{
+	"var libLoads = (typeof libLoads === 'undefined' ? 0 : libLoads) + 1;",
+	strings.Repeat("var pad = function(n, width) { var s = String(n); while (s.length < width) { s = '0' + s; } return s; };\n", 20),
+	"function stem(path) { var b = path.split('/').pop(); var i = b.lastIndexOf('.'); return i > 0 ? b.slice(0, i) : b; }",
+}
+
+// benchmarkScatter evaluates one expression per scatter element and fails
+// unless the expression library was loaded only once.
+func benchmarkScatter(b *testing.B, eval Evaluator) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := eval.Evaluate(context.Background(), Request{
+			Expression:    "stem(inputs.path) + '_' + pad(inputs.index, 6) + '.bam'",
+			Inputs:        map[string]interface{}{"index": i, "path": "/data/sample.fastq"},
+			ExpressionLib: benchmarkLib,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	loads, err := eval.Evaluate(context.Background(), Request{Expression: "libLoads", ExpressionLib: benchmarkLib})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(toFloat64(loads), "lib-loads")
+	if toFloat64(loads) != 1 {
+		b.Fatalf("Expected the expression library to load once, loaded %v times", loads)
+	}
+}
+
+// BenchmarkPool_Scatter evaluates one expression per scatter element in
+// worker processes, the path the scheduler takes in process mode.
+func BenchmarkPool_Scatter(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	pool, err := NewPool(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+
+	benchmarkScatter(b, pool)
+}
+
+// BenchmarkInProcessEvaluator_Scatter evaluates one expression per scatter
+// element in the current process.
+func BenchmarkInProcessEvaluator_Scatter(b *testing.B) {
+	benchmarkScatter(b, NewInProcessEvaluator())
+}
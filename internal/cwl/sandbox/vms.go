@@ -0,0 +1,82 @@
+package sandbox
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// maxCachedLibraries bounds the number of expression libraries a vmCache
+// keeps VMs for; the cache is emptied when it fills.
+const maxCachedLibraries = 64
+
+// maxIdleVMs bounds the idle VMs a vmCache keeps per library.
+const maxIdleVMs = 4
+
+// vmCache holds VMs with an expressionLib already loaded, keyed by the hash
+// of the library, so a library runs once per VM rather than once per
+// evaluation. A VM serves one evaluation at a time, and evaluations only
+// reset inputs, self and runtime, so the library's globals persist between
+// evaluations that share it.
+type vmCache struct {
+	programs *programCache
+
+	mu   sync.Mutex
+	idle map[string][]*goja.Runtime
+}
+
+func newVMCache(programs *programCache) *vmCache {
+	return &vmCache{
+		programs: programs,
+		idle:     make(map[string][]*goja.Runtime),
+	}
+}
+
+// acquire returns a VM with lib loaded and the key to release it under,
+// taking an idle VM if there is one.
+func (c *vmCache) acquire(lib []string) (*goja.Runtime, string, error) {
+	key := sourceKey("expressionLib", librarySource(lib))
+
+	c.mu.Lock()
+	if vms := c.idle[key]; len(vms) > 0 {
+		vm := vms[len(vms)-1]
+		c.idle[key] = vms[:len(vms)-1]
+		c.mu.Unlock()
+		return vm, key, nil
+	}
+	c.mu.Unlock()
+
+	vm := createSandboxedVM()
+	if len(lib) > 0 {
+		prog, err := c.programs.compileLibrary(lib)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to compile expression library: %w", err)
+		}
+		timer := time.AfterFunc(hardTimeout, func() {
+			vm.Interrupt("execution timeout")
+		})
+		_, err = vm.RunProgram(prog)
+		timer.Stop()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load expression library: %w", err)
+		}
+	}
+	return vm, key, nil
+}
+
+// release returns vm for reuse by evaluations with the library under key.
+// Only VMs whose evaluation succeeded are released; an interrupted or
+// failed evaluation may have left the VM in an unknown state.
+func (c *vmCache) release(key string, vm *goja.Runtime) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.idle[key]; !ok && len(c.idle) >= maxCachedLibraries {
+		c.idle = make(map[string][]*goja.Runtime)
+	}
+	if len(c.idle[key]) < maxIdleVMs {
+		c.idle[key] = append(c.idle[key], vm)
+	}
+}
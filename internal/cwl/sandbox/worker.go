@@ -3,20 +3,39 @@ package sandbox
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"os"
 	"time"
 
 	"github.com/dop251/goja"
 )
 
+// hardTimeout interrupts any single evaluation that runs this long. The
+// parent's timeout normally fires first; this is a backstop.
+const hardTimeout = 10 * time.Second
+
+// WorkerFlag is the argument Pool starts worker processes with.
+const WorkerFlag = "--sandbox-worker"
+
+// RunWorkerIfRequested runs the worker loop and exits if the process was
+// started as a sandbox worker. Binaries that create a Pool without a
+// WorkerBinary start their own executable as the worker, so they call it
+// first thing in main.
+func RunWorkerIfRequested() {
+	if len(os.Args) > 1 && os.Args[1] == WorkerFlag {
+		RunWorker()
+		os.Exit(0)
+	}
+}
+
 // RunWorker is the main loop for a sandbox worker process.
 // This should be called when the binary is invoked with --sandbox-worker.
 func RunWorker() {
 	// Apply resource limits (platform-specific)
 	applyResourceLimits()
 
-	// Create a limited runtime
-	vm := createSandboxedVM()
+	// VMs with each expression library loaded are reused across requests
+	vms := newVMCache(newProgramCache())
 
 	// Process requests from stdin
 	dec := json.NewDecoder(os.Stdin)
@@ -29,189 +48,68 @@ func RunWorker() {
 			return
 		}
 
-		resp := evaluateInVM(vm, req)
+		resp := evaluateInVM(vms, req)
 		if err := enc.Encode(resp); err != nil {
 			return
 		}
-
-		// Reset VM state between evaluations
-		vm = createSandboxedVM()
 	}
 }
 
-// createSandboxedVM creates a goja VM with dangerous globals removed.
+// createSandboxedVM creates a goja VM for untrusted expressions. goja
+// exposes no host capabilities (filesystem, network, processes, timers or
+// module loading), so the standard ECMAScript builtins are kept intact.
+// Math.random is seeded so evaluations are reproducible.
 func createSandboxedVM() *goja.Runtime {
 	vm := goja.New()
-
-	// Set up interrupt for infinite loop detection
-	// The parent process will kill us if we timeout, but this provides
-	// an additional layer of protection
-	go func() {
-		time.Sleep(10 * time.Second) // Hard limit
-		vm.Interrupt("execution timeout")
-	}()
-
-	// Remove/neuter dangerous globals
-	// goja doesn't have these by default, but ensure they're not added
-
-	// Add safe utility functions
-	vm.Set("JSON", map[string]interface{}{
-		"parse": func(s string) (interface{}, error) {
-			var v interface{}
-			err := json.Unmarshal([]byte(s), &v)
-			return v, err
-		},
-		"stringify": func(v interface{}) (string, error) {
-			b, err := json.Marshal(v)
-			return string(b), err
-		},
-	})
-
-	// Math is safe
-	vm.Set("Math", map[string]interface{}{
-		"abs":    absFunc,
-		"ceil":   ceilFunc,
-		"floor":  floorFunc,
-		"max":    maxFunc,
-		"min":    minFunc,
-		"pow":    powFunc,
-		"random": randomFunc,
-		"round":  roundFunc,
-		"sqrt":   sqrtFunc,
-	})
-
-	// String utilities
-	vm.Set("String", map[string]interface{}{
-		"fromCharCode": fromCharCodeFunc,
-	})
-
-	// Array utilities (safe subset)
-	vm.Set("Array", map[string]interface{}{
-		"isArray": isArrayFunc,
-	})
-
+	vm.SetRandSource(rand.New(rand.NewSource(1)).Float64)
 	return vm
 }
 
-// evaluateInVM runs an expression and returns the result.
-func evaluateInVM(vm *goja.Runtime, req Request) Response {
-	defer func() {
-		if r := recover(); r != nil {
-			// Don't let panics crash the worker
-		}
-	}()
+// runRequest evaluates a request's expression, compiled through programs,
+// in a vm that already has the request's expression library loaded, and
+// returns the exported result.
+func runRequest(vm *goja.Runtime, programs *programCache, req Request) (interface{}, error) {
+	timer := time.AfterFunc(hardTimeout, func() {
+		vm.Interrupt("execution timeout")
+	})
+	defer timer.Stop()
 
-	// Set up context variables
+	// Set up context variables, replacing the previous request's
 	vm.Set("inputs", req.Inputs)
 	vm.Set("self", req.Self)
 	vm.Set("runtime", req.Runtime)
 
-	// Execute the expression
-	result, err := vm.RunString(req.Expression)
+	prog, err := programs.compile("", req.Expression)
 	if err != nil {
-		return Response{Error: fmt.Sprintf("evaluation error: %v", err)}
-	}
-
-	// Export the result to a Go value
-	exported := result.Export()
-
-	return Response{Result: exported}
-}
-
-// Safe math functions
-func absFunc(x float64) float64 {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
-
-func ceilFunc(x float64) float64 {
-	if x == float64(int64(x)) {
-		return x
+		return nil, err
 	}
-	if x > 0 {
-		return float64(int64(x) + 1)
+	result, err := vm.RunProgram(prog)
+	if err != nil {
+		return nil, err
 	}
-	return float64(int64(x))
-}
 
-func floorFunc(x float64) float64 {
-	return float64(int64(x))
-}
-
-func maxFunc(args ...float64) float64 {
-	if len(args) == 0 {
-		return 0
-	}
-	m := args[0]
-	for _, v := range args[1:] {
-		if v > m {
-			m = v
-		}
-	}
-	return m
+	// Export the result to a Go value
+	return result.Export(), nil
 }
 
-func minFunc(args ...float64) float64 {
-	if len(args) == 0 {
-		return 0
-	}
-	m := args[0]
-	for _, v := range args[1:] {
-		if v < m {
-			m = v
+// evaluateInVM runs a request in a VM from vms and returns the response for
+// the parent.
+func evaluateInVM(vms *vmCache, req Request) (resp Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			// Don't let panics crash the worker
+			resp = Response{Error: fmt.Sprintf("expression panic: %v", r)}
 		}
-	}
-	return m
-}
-
-func powFunc(base, exp float64) float64 {
-	result := 1.0
-	for i := 0; i < int(exp); i++ {
-		result *= base
-	}
-	return result
-}
-
-func randomFunc() float64 {
-	// Deterministic for reproducibility in workflows
-	// Use a seeded PRNG if true randomness needed
-	return 0.5
-}
-
-func roundFunc(x float64) float64 {
-	if x < 0 {
-		return float64(int64(x - 0.5))
-	}
-	return float64(int64(x + 0.5))
-}
-
-func sqrtFunc(x float64) float64 {
-	if x < 0 {
-		return 0
-	}
-	// Newton's method
-	z := x / 2
-	for i := 0; i < 10; i++ {
-		z = z - (z*z-x)/(2*z)
-	}
-	return z
-}
+	}()
 
-func fromCharCodeFunc(codes ...int) string {
-	runes := make([]rune, len(codes))
-	for i, c := range codes {
-		runes[i] = rune(c)
+	vm, key, err := vms.acquire(req.ExpressionLib)
+	if err != nil {
+		return Response{Error: fmt.Sprintf("evaluation error: %v", err)}
 	}
-	return string(runes)
-}
-
-func isArrayFunc(v interface{}) bool {
-	switch v.(type) {
-	case []interface{}:
-		return true
-	default:
-		return false
+	result, err := runRequest(vm, vms.programs, req)
+	if err != nil {
+		return Response{Error: fmt.Sprintf("evaluation error: %v", err)}
 	}
+	vms.release(key, vm)
+	return Response{Result: result}
 }
@@ -5,39 +5,32 @@ package sandbox
 import (
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
 // applyResourceLimits sets OS-level resource constraints on Linux.
 func applyResourceLimits() {
-	// Memory limit from environment
+	// Memory limit from environment. The limit applies to the worker's
+	// data segment, on top of what the Go runtime has already mapped:
+	// RLIMIT_AS would count the runtime's address space reservations, which
+	// alone exceed any reasonable limit
 	if memStr := os.Getenv("SANDBOX_MEMORY_MB"); memStr != "" {
 		if memMB, err := strconv.ParseInt(memStr, 10, 64); err == nil {
-			memBytes := uint64(memMB * 1024 * 1024)
+			memBytes := uint64(memMB*1024*1024) + dataSize()
 
-			// Set address space limit
 			var rLimit syscall.Rlimit
 			rLimit.Cur = memBytes
 			rLimit.Max = memBytes
-			syscall.Setrlimit(syscall.RLIMIT_AS, &rLimit)
+			syscall.Setrlimit(syscall.RLIMIT_DATA, &rLimit)
 		}
 	}
 
-	// CPU time limit
-	if timeStr := os.Getenv("SANDBOX_TIMEOUT_SEC"); timeStr != "" {
-		if timeSec, err := strconv.ParseInt(timeStr, 10, 64); err == nil {
-			var rLimit syscall.Rlimit
-			rLimit.Cur = uint64(timeSec)
-			rLimit.Max = uint64(timeSec)
-			syscall.Setrlimit(syscall.RLIMIT_CPU, &rLimit)
-		}
-	}
-
-	// Prevent fork bombs
-	var nProcLimit syscall.Rlimit
-	nProcLimit.Cur = 0
-	nProcLimit.Max = 0
-	syscall.Setrlimit(syscall.RLIMIT_NPROC, &nProcLimit)
+	// Workers are long-lived, so neither RLIMIT_CPU, which accumulates
+	// over every evaluation, nor RLIMIT_NPROC, which also counts the
+	// threads the Go runtime creates, can bound a single evaluation. The
+	// pool's timeout kills a worker whose evaluation runs too long, and
+	// goja has no way to start processes.
 
 	// No file creation
 	var fSizeLimit syscall.Rlimit
@@ -45,3 +38,27 @@ func applyResourceLimits() {
 	fSizeLimit.Max = 0
 	syscall.Setrlimit(syscall.RLIMIT_FSIZE, &fSizeLimit)
 }
+
+// dataSize returns the size of the process's data segment, VmData in
+// /proc/self/status, or 0 if it cannot be read.
+func dataSize() uint64 {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if !strings.HasPrefix(line, "VmData:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "VmData:"))
+		if len(fields) == 0 {
+			return 0
+		}
+		kb, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
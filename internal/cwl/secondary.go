@@ -0,0 +1,92 @@
+package cwl
+
+import (
+	"fmt"
+)
+
+// IsRequired reports whether a secondary file must be present. Required
+// may be a boolean or an expression; expressions are treated as required.
+// def applies when Required is unset: true for inputs, false for outputs.
+func (s SecondaryFileSpec) IsRequired(def bool) bool {
+	switch v := s.Required.(type) {
+	case nil:
+		return def
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
+
+// AttachSecondaryFiles returns a copy of file with the secondary files named
+// by specs added to its secondaryFiles list; files already listed are kept.
+// exists reports whether a candidate path is present: missing optional
+// files are skipped and missing required files are an error. A nil exists
+// attaches every candidate.
+func AttachSecondaryFiles(ee *ExpressionEvaluator, file map[string]interface{}, specs []SecondaryFileSpec, requiredByDefault bool, exists func(path string) bool) (map[string]interface{}, error) {
+	if len(specs) == 0 {
+		return file, nil
+	}
+
+	primary := filePath(file)
+	if primary == "" {
+		return nil, fmt.Errorf("file has no path or location")
+	}
+
+	out := make(map[string]interface{}, len(file)+1)
+	for k, v := range file {
+		out[k] = v
+	}
+
+	var secondaries []interface{}
+	listed := make(map[string]bool)
+	if existing, ok := file["secondaryFiles"].([]interface{}); ok {
+		for _, sf := range existing {
+			secondaries = append(secondaries, sf)
+			if m, ok := sf.(map[string]interface{}); ok {
+				listed[filePath(m)] = true
+			}
+		}
+	}
+
+	for _, spec := range specs {
+		paths, err := ee.EvaluateSecondaryFiles([]SecondaryFileSpec{spec}, primary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate secondaryFiles pattern %s: %w", spec.Pattern, err)
+		}
+		for _, p := range paths {
+			if p == "" || listed[p] {
+				continue
+			}
+			if exists != nil && !exists(p) {
+				if spec.IsRequired(requiredByDefault) {
+					return nil, fmt.Errorf("required secondary file %s not found for %s", p, primary)
+				}
+				continue
+			}
+			listed[p] = true
+			secondaries = append(secondaries, map[string]interface{}{
+				"class":    TypeFile,
+				"location": p,
+				"path":     p,
+				"basename": getBasename(p),
+			})
+		}
+	}
+
+	if len(secondaries) > 0 {
+		out["secondaryFiles"] = secondaries
+	}
+	return out, nil
+}
+
+// filePath returns the path of a File object, falling back to location.
+func filePath(file map[string]interface{}) string {
+	if p, ok := file["path"].(string); ok && p != "" {
+		return p
+	}
+	if loc, ok := file["location"].(string); ok {
+		return loc
+	}
+	return ""
+}
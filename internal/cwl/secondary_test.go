@@ -0,0 +1,70 @@
+package cwl
+
+import (
+	"testing"
+)
+
+func TestAttachSecondaryFiles(t *testing.T) {
+	file := map[string]interface{}{"class": "File", "path": "/data/reads.bam"}
+	specs := []SecondaryFileSpec{
+		{Pattern: ".bai"},
+		{Pattern: "^.csi", Required: false},
+	}
+	present := map[string]bool{"/data/reads.bam.bai": true}
+	exists := func(path string) bool { return present[path] }
+
+	attached, err := AttachSecondaryFiles(NewExpressionEvaluator(), file, specs, true, exists)
+	if err != nil {
+		t.Fatalf("AttachSecondaryFiles failed: %v", err)
+	}
+
+	secondaries, ok := attached["secondaryFiles"].([]interface{})
+	if !ok || len(secondaries) != 1 {
+		t.Fatalf("Expected 1 secondary file, got %v", attached["secondaryFiles"])
+	}
+	sf := secondaries[0].(map[string]interface{})
+	if sf["path"] != "/data/reads.bam.bai" || sf["basename"] != "reads.bam.bai" {
+		t.Errorf("Unexpected secondary file: %v", sf)
+	}
+	if _, ok := file["secondaryFiles"]; ok {
+		t.Error("Expected the original file to be left unchanged")
+	}
+}
+
+func TestAttachSecondaryFiles_MissingRequired(t *testing.T) {
+	file := map[string]interface{}{"class": "File", "path": "/data/reads.bam"}
+	specs := []SecondaryFileSpec{{Pattern: ".bai"}}
+	exists := func(string) bool { return false }
+
+	if _, err := AttachSecondaryFiles(NewExpressionEvaluator(), file, specs, true, exists); err == nil {
+		t.Error("Expected error for missing required secondary file")
+	}
+
+	// Output secondary files are optional by default
+	attached, err := AttachSecondaryFiles(NewExpressionEvaluator(), file, specs, false, exists)
+	if err != nil {
+		t.Fatalf("AttachSecondaryFiles failed: %v", err)
+	}
+	if _, ok := attached["secondaryFiles"]; ok {
+		t.Errorf("Expected no secondary files, got %v", attached["secondaryFiles"])
+	}
+}
+
+func TestAttachSecondaryFiles_KeepsListed(t *testing.T) {
+	file := map[string]interface{}{
+		"class": "File",
+		"path":  "/data/reads.bam",
+		"secondaryFiles": []interface{}{
+			map[string]interface{}{"class": "File", "path": "/data/reads.bam.bai"},
+		},
+	}
+	specs := []SecondaryFileSpec{{Pattern: ".bai"}}
+
+	attached, err := AttachSecondaryFiles(NewExpressionEvaluator(), file, specs, true, nil)
+	if err != nil {
+		t.Fatalf("AttachSecondaryFiles failed: %v", err)
+	}
+	if secondaries := attached["secondaryFiles"].([]interface{}); len(secondaries) != 1 {
+		t.Errorf("Expected listed secondary file not to be duplicated, got %v", secondaries)
+	}
+}
@@ -4,6 +4,8 @@ package cwl
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // CWLVersion represents supported CWL versions.
@@ -49,19 +51,30 @@ type Document struct {
 	Requirements []Requirement `json:"requirements,omitempty" yaml:"requirements,omitempty"`
 	Hints        []Requirement `json:"hints,omitempty" yaml:"hints,omitempty"`
 
+	// Namespaces maps the prefixes of "prefix:name" references, such as
+	// "edam:format_1929", to IRIs; Schemas lists the ontologies they use.
+	Namespaces map[string]string `json:"$namespaces,omitempty" yaml:"$namespaces,omitempty"`
+	Schemas    []string          `json:"$schemas,omitempty" yaml:"$schemas,omitempty"`
+
 	// CommandLineTool specific
-	BaseCommand interface{}       `json:"baseCommand,omitempty" yaml:"baseCommand,omitempty"`
-	Arguments   []CommandLineArg  `json:"arguments,omitempty" yaml:"arguments,omitempty"`
-	Stdin       string            `json:"stdin,omitempty" yaml:"stdin,omitempty"`
-	Stdout      string            `json:"stdout,omitempty" yaml:"stdout,omitempty"`
-	Stderr      string            `json:"stderr,omitempty" yaml:"stderr,omitempty"`
-	SuccessCodes []int            `json:"successCodes,omitempty" yaml:"successCodes,omitempty"`
+	BaseCommand        interface{}      `json:"baseCommand,omitempty" yaml:"baseCommand,omitempty"`
+	Arguments          []CommandLineArg `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	Stdin              string           `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	Stdout             string           `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr             string           `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	SuccessCodes       []int            `json:"successCodes,omitempty" yaml:"successCodes,omitempty"`
+	TemporaryFailCodes []int            `json:"temporaryFailCodes,omitempty" yaml:"temporaryFailCodes,omitempty"`
+	PermanentFailCodes []int            `json:"permanentFailCodes,omitempty" yaml:"permanentFailCodes,omitempty"`
 
 	// Workflow specific
 	Steps []WorkflowStep `json:"steps,omitempty" yaml:"steps,omitempty"`
 
 	// ExpressionTool specific
 	Expression string `json:"expression,omitempty" yaml:"expression,omitempty"`
+
+	// graph holds the processes of the packed $graph document this one was
+	// selected from, keyed by id, so "#id" run references can be resolved
+	graph map[string]map[string]interface{}
 }
 
 // Input represents a CWL input parameter.
@@ -205,6 +218,8 @@ type Requirement struct {
 	EnableReuse interface{} `json:"enableReuse,omitempty" yaml:"enableReuse,omitempty"`
 	// ToolTimeLimit
 	TimeLimit interface{} `json:"timelimit,omitempty" yaml:"timelimit,omitempty"`
+	// LoadListingRequirement
+	LoadListing string `json:"loadListing,omitempty" yaml:"loadListing,omitempty"`
 	// SubworkflowFeatureRequirement, ScatterFeatureRequirement,
 	// MultipleInputFeatureRequirement, StepInputExpressionRequirement
 	// (no additional fields - just presence indicates feature is enabled)
@@ -254,18 +269,33 @@ type CWLType struct {
 
 // CWLField represents a field in a record type.
 type CWLField struct {
-	Name string
-	Type *CWLType
-	Doc  string
+	Name         string
+	Type         *CWLType
+	Doc          string
+	InputBinding *CommandLineBinding
+
+	// Output record fields
+	OutputBinding  *CommandOutputBinding
+	Format         interface{}
+	SecondaryFiles []SecondaryFileSpec
 }
 
 // ParseType parses a CWL type specification into a structured CWLType.
+// Named type references are returned as-is; use SchemaDefs.ParseType to
+// resolve them against SchemaDefRequirement.
 func ParseType(t interface{}) (*CWLType, error) {
+	return parseType(t, nil, nil)
+}
+
+// parseType parses a type specification. When defs is non-nil, names that
+// are not built-in types are resolved against it; resolving tracks the
+// names being expanded so recursive records terminate.
+func parseType(t interface{}, defs SchemaDefs, resolving map[string]bool) (*CWLType, error) {
 	switch v := t.(type) {
 	case string:
 		// Check for nullable shorthand (type?)
 		if len(v) > 1 && v[len(v)-1] == '?' {
-			innerType, err := ParseType(v[:len(v)-1])
+			innerType, err := parseType(v[:len(v)-1], defs, resolving)
 			if err != nil {
 				return nil, err
 			}
@@ -274,12 +304,15 @@ func ParseType(t interface{}) (*CWLType, error) {
 		}
 		// Check for array shorthand (type[])
 		if len(v) > 2 && v[len(v)-2:] == "[]" {
-			itemType, err := ParseType(v[:len(v)-2])
+			itemType, err := parseType(v[:len(v)-2], defs, resolving)
 			if err != nil {
 				return nil, err
 			}
 			return &CWLType{Type: TypeArray, Items: itemType}, nil
 		}
+		if defs != nil && !builtinTypes[v] {
+			return defs.resolve(v, resolving)
+		}
 		return &CWLType{Type: v}, nil
 
 	case []interface{}:
@@ -291,7 +324,7 @@ func ParseType(t interface{}) (*CWLType, error) {
 				hasNull = true
 				continue
 			}
-			parsed, err := ParseType(item)
+			parsed, err := parseType(item, defs, resolving)
 			if err != nil {
 				return nil, err
 			}
@@ -320,47 +353,35 @@ func ParseType(t interface{}) (*CWLType, error) {
 			if !ok {
 				return nil, fmt.Errorf("array type missing 'items' field")
 			}
-			itemType, err := ParseType(items)
+			itemType, err := parseType(items, defs, resolving)
 			if err != nil {
 				return nil, err
 			}
 			return &CWLType{Type: TypeArray, Items: itemType}, nil
 
 		case TypeRecord:
-			fields, _ := v["fields"].([]interface{})
-			var cwlFields []CWLField
-			for _, f := range fields {
-				fm, ok := f.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				fieldName, _ := fm["name"].(string)
-				fieldType, err := ParseType(fm["type"])
-				if err != nil {
-					return nil, err
-				}
-				fieldDoc, _ := fm["doc"].(string)
-				cwlFields = append(cwlFields, CWLField{
-					Name: fieldName,
-					Type: fieldType,
-					Doc:  fieldDoc,
-				})
-			}
 			name, _ := v["name"].(string)
-			return &CWLType{Type: TypeRecord, Fields: cwlFields, Name: name}, nil
+			fields, err := parseRecordFields(v["fields"], defs, resolving)
+			if err != nil {
+				return nil, fmt.Errorf("record %s: %w", name, err)
+			}
+			return &CWLType{Type: TypeRecord, Fields: fields, Name: name}, nil
 
 		case TypeEnum:
 			symbols, _ := v["symbols"].([]interface{})
 			var symStrs []string
 			for _, s := range symbols {
 				if str, ok := s.(string); ok {
-					symStrs = append(symStrs, str)
+					symStrs = append(symStrs, shortName(str))
 				}
 			}
 			name, _ := v["name"].(string)
 			return &CWLType{Type: TypeEnum, Symbols: symStrs, Name: name}, nil
 
 		default:
+			if defs != nil && !builtinTypes[typeStr] {
+				return defs.resolve(typeStr, resolving)
+			}
 			return &CWLType{Type: typeStr}, nil
 		}
 
@@ -369,6 +390,252 @@ func ParseType(t interface{}) (*CWLType, error) {
 	}
 }
 
+// parseRecordFields parses record fields given as a list of field maps or
+// as a map of field name to type (or field map).
+func parseRecordFields(raw interface{}, defs SchemaDefs, resolving map[string]bool) ([]CWLField, error) {
+	var fieldMaps []map[string]interface{}
+	switch v := raw.(type) {
+	case []interface{}:
+		for _, f := range v {
+			if fm, ok := f.(map[string]interface{}); ok {
+				fieldMaps = append(fieldMaps, fm)
+			}
+		}
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fm := map[string]interface{}{"name": name}
+			if spec, ok := v[name].(map[string]interface{}); ok && !isTypeMap(spec) {
+				for k, val := range spec {
+					fm[k] = val
+				}
+				fm["name"] = name
+			} else {
+				fm["type"] = v[name]
+			}
+			fieldMaps = append(fieldMaps, fm)
+		}
+	}
+
+	var cwlFields []CWLField
+	for _, fm := range fieldMaps {
+		fieldName, _ := fm["name"].(string)
+		fieldType, err := parseType(fm["type"], defs, resolving)
+		if err != nil {
+			return nil, err
+		}
+		fieldDoc, _ := fm["doc"].(string)
+		field := CWLField{
+			Name: shortName(fieldName),
+			Type: fieldType,
+			Doc:  fieldDoc,
+		}
+		if ib, ok := fm["inputBinding"].(map[string]interface{}); ok {
+			field.InputBinding, err = (&Parser{}).parseInputBinding(ib)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ob, ok := fm["outputBinding"].(map[string]interface{}); ok {
+			field.OutputBinding, err = (&Parser{}).parseOutputBinding(ob)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if format, ok := fm["format"]; ok {
+			field.Format = format
+		}
+		if sf, ok := fm["secondaryFiles"]; ok {
+			field.SecondaryFiles, err = (&Parser{}).parseSecondaryFiles(sf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cwlFields = append(cwlFields, field)
+	}
+	return cwlFields, nil
+}
+
+// isTypeMap reports whether m is an array, record or enum type rather than
+// a record field definition.
+func isTypeMap(m map[string]interface{}) bool {
+	_, items := m["items"]
+	_, symbols := m["symbols"]
+	_, fields := m["fields"]
+	return items || symbols || fields
+}
+
+// builtinTypes are the type names that never refer to a schema definition.
+var builtinTypes = map[string]bool{
+	TypeNull: true, TypeBoolean: true, TypeInt: true, TypeLong: true,
+	TypeFloat: true, TypeDouble: true, TypeString: true, TypeFile: true,
+	TypeDirectory: true, TypeArray: true, TypeRecord: true, TypeEnum: true,
+	TypeAny: true, "stdout": true, "stderr": true,
+}
+
+// SchemaDefs maps type names to the record and enum definitions declared
+// by SchemaDefRequirement. Names are stored without any "file#" prefix so
+// that "#Sample", "Sample" and "types.yml#Sample" all resolve alike.
+type SchemaDefs map[string]interface{}
+
+// SchemaDefs collects the named types declared by SchemaDefRequirement in
+// the document's requirements and hints and in those of its steps.
+func (doc *Document) SchemaDefs() SchemaDefs {
+	defs := SchemaDefs{}
+	defs.add(doc.Requirements)
+	defs.add(doc.Hints)
+	for _, step := range doc.Steps {
+		defs.add(step.Requirements)
+		defs.add(step.Hints)
+	}
+	return defs
+}
+
+// add registers the types of any SchemaDefRequirement in reqs.
+func (s SchemaDefs) add(reqs []Requirement) {
+	for _, req := range reqs {
+		if req.Class != "SchemaDefRequirement" {
+			continue
+		}
+		for _, t := range req.Types {
+			m, ok := t.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if name, ok := m["name"].(string); ok && name != "" {
+				s[shortName(name)] = m
+			}
+		}
+	}
+}
+
+// ParseType parses a type specification, resolving named types. A
+// reference to a type that is neither built in nor defined is an error.
+func (s SchemaDefs) ParseType(t interface{}) (*CWLType, error) {
+	return parseType(t, s, map[string]bool{})
+}
+
+// resolve parses the definition of a named type.
+func (s SchemaDefs) resolve(ref string, resolving map[string]bool) (*CWLType, error) {
+	name := shortName(ref)
+	def, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", ref)
+	}
+	if resolving[name] {
+		// Recursive reference; stop expanding here
+		return &CWLType{Type: TypeRecord, Name: name}, nil
+	}
+	resolving[name] = true
+	defer delete(resolving, name)
+	return parseType(def, s, resolving)
+}
+
+// shortName strips any document prefix ("types.yml#", "#") and parent
+// path ("Record/") from a CWL identifier.
+func shortName(id string) string {
+	if i := strings.LastIndex(id, "#"); i >= 0 {
+		id = id[i+1:]
+		if j := strings.LastIndex(id, "/"); j >= 0 {
+			id = id[j+1:]
+		}
+	}
+	return id
+}
+
+// Validate checks value against the type. Records must carry their
+// required fields and enums one of their symbols; arrays are checked item
+// by item. Scalar, File and Directory values are not checked.
+func (t *CWLType) Validate(value interface{}) error {
+	if value == nil {
+		if t.IsOptional() || t.Type == TypeAny {
+			return nil
+		}
+		return fmt.Errorf("null value for non-optional type %s", t)
+	}
+
+	switch t.Type {
+	case TypeEnum:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected enum symbol, got %T", value)
+		}
+		for _, sym := range t.Symbols {
+			if s == sym {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %v", s, t.Symbols)
+
+	case TypeRecord:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected record, got %T", value)
+		}
+		for _, field := range t.Fields {
+			if err := field.Type.Validate(m[field.Name]); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+
+	case TypeArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected array, got %T", value)
+		}
+		if t.Items == nil {
+			return nil
+		}
+		for i, item := range items {
+			if err := t.Items.Validate(item); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasFieldInputBindings reports whether t is a record, or an array of
+// records, with a field that declares an inputBinding, directly or in a
+// nested record.
+func (t *CWLType) hasFieldInputBindings() bool {
+	if t == nil {
+		return false
+	}
+	if t.Type == TypeArray {
+		return t.Items != nil && t.Items.Type == TypeRecord && t.Items.hasFieldInputBindings()
+	}
+	if t.Type != TypeRecord {
+		return false
+	}
+	for _, field := range t.Fields {
+		if field.InputBinding != nil || field.Type.hasFieldInputBindings() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFieldOutputBindings reports whether t is a record with a field that
+// declares an outputBinding, directly or in a nested record. Such outputs
+// are collected field by field into an object.
+func (t *CWLType) HasFieldOutputBindings() bool {
+	if t == nil || t.Type != TypeRecord {
+		return false
+	}
+	for _, field := range t.Fields {
+		if field.OutputBinding != nil || field.Type.HasFieldOutputBindings() {
+			return true
+		}
+	}
+	return false
+}
+
 // IsOptional returns true if the type allows null values.
 func (t *CWLType) IsOptional() bool {
 	return t.Nullable || t.Type == TypeNull
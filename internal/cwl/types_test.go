@@ -216,3 +216,116 @@ func TestCWLType_BaseType(t *testing.T) {
 		}
 	}
 }
+
+func TestSchemaDefs_ParseType(t *testing.T) {
+	doc := &Document{
+		Requirements: []Requirement{
+			{
+				Class: "SchemaDefRequirement",
+				Types: []interface{}{
+					map[string]interface{}{
+						"name":    "#Strandedness",
+						"type":    "enum",
+						"symbols": []interface{}{"#Strandedness/forward", "#Strandedness/reverse"},
+					},
+					map[string]interface{}{
+						"name": "#Sample",
+						"type": "record",
+						"fields": []interface{}{
+							map[string]interface{}{"name": "id", "type": "string"},
+							map[string]interface{}{"name": "strand", "type": "#Strandedness"},
+						},
+					},
+				},
+			},
+		},
+	}
+	defs := doc.SchemaDefs()
+
+	result, err := defs.ParseType("types.yml#Sample[]")
+	if err != nil {
+		t.Fatalf("Failed to parse named type: %v", err)
+	}
+	if result.Type != TypeArray || result.Items.Type != TypeRecord {
+		t.Fatalf("Expected array of records, got %s", result)
+	}
+	fields := result.Items.Fields
+	if len(fields) != 2 || fields[1].Type.Type != TypeEnum {
+		t.Fatalf("Expected strand field of enum type, got %+v", fields)
+	}
+	if fields[1].Type.Symbols[0] != "forward" {
+		t.Errorf("Expected symbol 'forward', got %s", fields[1].Type.Symbols[0])
+	}
+
+	if _, err := defs.ParseType("#Missing"); err == nil {
+		t.Error("Expected error for unknown type reference")
+	}
+	if _, err := defs.ParseType([]interface{}{"null", "File"}); err != nil {
+		t.Errorf("Built-in types should resolve: %v", err)
+	}
+}
+
+func TestCWLType_Validate(t *testing.T) {
+	sample := &CWLType{
+		Type: TypeRecord,
+		Fields: []CWLField{
+			{Name: "id", Type: &CWLType{Type: TypeString}},
+			{Name: "strand", Type: &CWLType{Type: TypeEnum, Symbols: []string{"forward", "reverse"}}},
+			{Name: "notes", Type: &CWLType{Type: TypeString, Nullable: true}},
+		},
+	}
+
+	valid := map[string]interface{}{"id": "s1", "strand": "forward"}
+	if err := sample.Validate(valid); err != nil {
+		t.Errorf("Expected valid record, got %v", err)
+	}
+
+	badSymbol := map[string]interface{}{"id": "s1", "strand": "both"}
+	if err := sample.Validate(badSymbol); err == nil {
+		t.Error("Expected error for invalid enum symbol")
+	}
+
+	missing := map[string]interface{}{"strand": "reverse"}
+	if err := sample.Validate(missing); err == nil {
+		t.Error("Expected error for missing required field")
+	}
+
+	sheet := &CWLType{Type: TypeArray, Items: sample}
+	if err := sheet.Validate([]interface{}{valid, badSymbol}); err == nil {
+		t.Error("Expected error for invalid array item")
+	}
+}
+
+func TestCWLType_HasFieldOutputBindings(t *testing.T) {
+	recordType, err := ParseType(map[string]interface{}{
+		"type": "record",
+		"fields": map[string]interface{}{
+			"alignment": map[string]interface{}{
+				"type":           "File",
+				"format":         "http://edamontology.org/format_2572",
+				"secondaryFiles": []interface{}{".bai"},
+				"outputBinding":  map[string]interface{}{"glob": "*.bam"},
+			},
+			"name": "string",
+		},
+	})
+	if err != nil {
+		t.Fatalf("ParseType failed: %v", err)
+	}
+
+	if !recordType.HasFieldOutputBindings() {
+		t.Error("Expected record with a bound field to have field output bindings")
+	}
+	alignment := recordType.Fields[0]
+	if alignment.OutputBinding == nil || alignment.OutputBinding.Glob != "*.bam" {
+		t.Errorf("Expected alignment outputBinding glob *.bam, got %+v", alignment.OutputBinding)
+	}
+	if alignment.Format != "http://edamontology.org/format_2572" || len(alignment.SecondaryFiles) != 1 {
+		t.Errorf("Expected alignment format and secondaryFiles, got %+v", alignment)
+	}
+
+	plain, _ := ParseType(map[string]interface{}{"type": "record", "fields": map[string]interface{}{"name": "string"}})
+	if plain.HasFieldOutputBindings() {
+		t.Error("Expected record without bound fields to have no field output bindings")
+	}
+}
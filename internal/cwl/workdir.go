@@ -0,0 +1,191 @@
+package cwl
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// WorkDirEntry is a file or directory to create in a tool's working
+// directory before it runs, from InitialWorkDirRequirement.
+type WorkDirEntry struct {
+	// Entryname is the path relative to the working directory.
+	Entryname string `json:"entryname"`
+	// Contents is the literal content of a generated file.
+	Contents *string `json:"contents,omitempty"`
+	// Source is the File or Directory object to stage at Entryname.
+	Source map[string]interface{} `json:"source,omitempty"`
+	// Writable requests a copy rather than a link to Source.
+	Writable bool `json:"writable,omitempty"`
+}
+
+// BuildInitialWorkDir evaluates the tool's InitialWorkDirRequirement listing
+// into the entries to stage. Tools without the requirement return nil.
+func (cb *CommandBuilder) BuildInitialWorkDir() ([]WorkDirEntry, error) {
+	req := cb.doc.GetInitialWorkDirRequirement()
+	if req == nil || req.Listing == nil {
+		return nil, nil
+	}
+
+	ee := cb.evaluator()
+	ee.SetSelf(nil)
+
+	var entries []WorkDirEntry
+	if err := appendListing(ee, req.Listing, &entries); err != nil {
+		return nil, fmt.Errorf("InitialWorkDirRequirement: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := validateEntryname(entry.Entryname); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// appendListing evaluates a listing item (or array of items) and appends
+// the resulting entries. Null items are ignored.
+func appendListing(ee *ExpressionEvaluator, item interface{}, entries *[]WorkDirEntry) error {
+	switch v := item.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		for _, elem := range v {
+			if err := appendListing(ee, elem, entries); err != nil {
+				return err
+			}
+		}
+		return nil
+	case string:
+		if !IsExpression(v) {
+			return fmt.Errorf("listing entry must be an expression, File, Directory, or Dirent: %q", v)
+		}
+		value, err := ee.Evaluate(v)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate listing expression: %w", err)
+		}
+		if s, ok := value.(string); ok {
+			return fmt.Errorf("listing expression returned string %q, expected File, Directory, or Dirent", s)
+		}
+		return appendListing(ee, value, entries)
+	case map[string]interface{}:
+		if _, isDirent := v["entry"]; isDirent {
+			entry, err := evaluateDirent(ee, v)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				*entries = append(*entries, *entry)
+			}
+			return nil
+		}
+		class, _ := v["class"].(string)
+		if class != TypeFile && class != TypeDirectory {
+			return fmt.Errorf("listing entry has unsupported class %q", class)
+		}
+		*entries = append(*entries, WorkDirEntry{
+			Entryname: fileBasename(v),
+			Source:    v,
+		})
+		return nil
+	default:
+		return fmt.Errorf("unsupported listing entry type: %T", item)
+	}
+}
+
+// evaluateDirent evaluates a Dirent. An entry that is a single expression
+// yielding a File or Directory stages that object; any other non-string
+// result is serialized as JSON, per CWL v1.2.
+func evaluateDirent(ee *ExpressionEvaluator, dirent map[string]interface{}) (*WorkDirEntry, error) {
+	entry := &WorkDirEntry{}
+	if w, ok := dirent["writable"].(bool); ok {
+		entry.Writable = w
+	}
+
+	if name, ok := dirent["entryname"].(string); ok {
+		value, err := ee.Evaluate(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate entryname: %w", err)
+		}
+		entry.Entryname = fmt.Sprintf("%v", value)
+	}
+
+	raw, ok := dirent["entry"].(string)
+	if !ok {
+		return nil, fmt.Errorf("Dirent entry must be a string")
+	}
+
+	var value interface{} = raw
+	if containsExpression(raw) {
+		evaluated, err := ee.Evaluate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate Dirent entry: %w", err)
+		}
+		value = evaluated
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		entry.Contents = &v
+	case map[string]interface{}:
+		if class, _ := v["class"].(string); class == TypeFile || class == TypeDirectory {
+			entry.Source = v
+			if entry.Entryname == "" {
+				entry.Entryname = fileBasename(v)
+			}
+			break
+		}
+		if err := setJSONContents(entry, v); err != nil {
+			return nil, err
+		}
+	default:
+		if err := setJSONContents(entry, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if entry.Entryname == "" {
+		return nil, fmt.Errorf("Dirent with file contents requires an entryname")
+	}
+	return entry, nil
+}
+
+// setJSONContents sets an entry's contents to the JSON encoding of value.
+func setJSONContents(entry *WorkDirEntry, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to serialize Dirent entry: %w", err)
+	}
+	contents := string(data)
+	entry.Contents = &contents
+	return nil
+}
+
+// fileBasename returns the basename of a File or Directory object.
+func fileBasename(obj map[string]interface{}) string {
+	if b, ok := obj["basename"].(string); ok && b != "" {
+		return b
+	}
+	for _, key := range []string{"path", "location"} {
+		if p, ok := obj[key].(string); ok && p != "" {
+			return path.Base(p)
+		}
+	}
+	return ""
+}
+
+// validateEntryname rejects entry names outside the working directory.
+func validateEntryname(name string) error {
+	if name == "" {
+		return fmt.Errorf("InitialWorkDirRequirement entry has no name")
+	}
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("InitialWorkDirRequirement entryname %q must be inside the working directory", name)
+	}
+	return nil
+}
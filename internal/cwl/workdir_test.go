@@ -0,0 +1,121 @@
+package cwl
+
+import (
+	"testing"
+)
+
+func newWorkDirBuilder(listing interface{}, inputs map[string]interface{}) *CommandBuilder {
+	doc := &Document{
+		CWLVersion:  "v1.2",
+		Class:       ClassCommandLineTool,
+		BaseCommand: "cat",
+		Requirements: []Requirement{
+			{Class: "InlineJavascriptRequirement"},
+			{Class: "InitialWorkDirRequirement", Listing: listing},
+		},
+	}
+	return NewCommandBuilder(doc, inputs)
+}
+
+func TestBuildInitialWorkDir_NoRequirement(t *testing.T) {
+	doc := &Document{CWLVersion: "v1.2", Class: ClassCommandLineTool, BaseCommand: "true"}
+	entries, err := NewCommandBuilder(doc, nil).BuildInitialWorkDir()
+	if err != nil {
+		t.Fatalf("BuildInitialWorkDir failed: %v", err)
+	}
+	if entries != nil {
+		t.Errorf("Expected no entries, got %v", entries)
+	}
+}
+
+func TestBuildInitialWorkDir_Dirent(t *testing.T) {
+	listing := []interface{}{
+		map[string]interface{}{
+			"entryname": "config.txt",
+			"entry":     "threads=$(inputs.threads)\n",
+		},
+		map[string]interface{}{
+			"entryname": "params.json",
+			"entry":     "$(inputs.params)",
+		},
+		map[string]interface{}{
+			"entry":    "$(inputs.reads)",
+			"writable": true,
+		},
+	}
+	inputs := map[string]interface{}{
+		"threads": 4,
+		"params":  map[string]interface{}{"k": 31},
+		"reads": map[string]interface{}{
+			"class": "File",
+			"path":  "/data/reads.fq",
+		},
+	}
+
+	entries, err := newWorkDirBuilder(listing, inputs).BuildInitialWorkDir()
+	if err != nil {
+		t.Fatalf("BuildInitialWorkDir failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d: %v", len(entries), entries)
+	}
+
+	if entries[0].Entryname != "config.txt" || entries[0].Contents == nil || *entries[0].Contents != "threads=4\n" {
+		t.Errorf("Unexpected config entry: %+v", entries[0])
+	}
+	if entries[1].Contents == nil || *entries[1].Contents != `{"k":31}` {
+		t.Errorf("Expected JSON-serialized params, got %+v", entries[1])
+	}
+	if entries[2].Entryname != "reads.fq" || entries[2].Source == nil || !entries[2].Writable {
+		t.Errorf("Unexpected file entry: %+v", entries[2])
+	}
+}
+
+func TestBuildInitialWorkDir_Hint(t *testing.T) {
+	doc := &Document{
+		CWLVersion:  "v1.2",
+		Class:       ClassCommandLineTool,
+		BaseCommand: "cat",
+		Hints: []Requirement{{
+			Class: "InitialWorkDirRequirement",
+			Listing: []interface{}{
+				map[string]interface{}{"entryname": "name.txt", "entry": "$(inputs.name)"},
+			},
+		}},
+	}
+
+	entries, err := NewCommandBuilder(doc, map[string]interface{}{"name": "sample"}).BuildInitialWorkDir()
+	if err != nil {
+		t.Fatalf("BuildInitialWorkDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Entryname != "name.txt" || entries[0].Contents == nil || *entries[0].Contents != "sample" {
+		t.Errorf("Expected entry from hint, got %+v", entries)
+	}
+}
+
+func TestBuildInitialWorkDir_ExpressionArray(t *testing.T) {
+	listing := "${ return inputs.names.map(function(n) { return {entryname: n + '.txt', entry: n}; }); }"
+	inputs := map[string]interface{}{
+		"names": []interface{}{"a", "b"},
+	}
+
+	entries, err := newWorkDirBuilder(listing, inputs).BuildInitialWorkDir()
+	if err != nil {
+		t.Fatalf("BuildInitialWorkDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	if entries[1].Entryname != "b.txt" || *entries[1].Contents != "b" {
+		t.Errorf("Unexpected entry: %+v", entries[1])
+	}
+}
+
+func TestBuildInitialWorkDir_RejectsEscapingEntryname(t *testing.T) {
+	listing := []interface{}{
+		map[string]interface{}{"entryname": "../outside.txt", "entry": "x"},
+	}
+	if _, err := newWorkDirBuilder(listing, nil).BuildInitialWorkDir(); err == nil {
+		t.Error("Expected error for entryname outside the working directory")
+	}
+}
@@ -109,23 +109,26 @@ func (wa *WorkflowAnalyzer) extractStepID(source string) string {
 
 // GetStepOutputIDs returns the output IDs for a step.
 func (wa *WorkflowAnalyzer) GetStepOutputIDs(stepID string) []string {
-	for _, step := range wa.doc.Steps {
-		if step.ID == stepID {
-			var outputs []string
-			for _, out := range step.Out {
-				switch v := out.(type) {
-				case string:
-					outputs = append(outputs, v)
-				case map[string]interface{}:
-					if id, ok := v["id"].(string); ok {
-						outputs = append(outputs, id)
-					}
-				}
+	if step := wa.GetStep(stepID); step != nil {
+		return step.OutputIDs()
+	}
+	return nil
+}
+
+// OutputIDs returns the IDs of the step's outputs.
+func (step *WorkflowStep) OutputIDs() []string {
+	var outputs []string
+	for _, out := range step.Out {
+		switch v := out.(type) {
+		case string:
+			outputs = append(outputs, v)
+		case map[string]interface{}:
+			if id, ok := v["id"].(string); ok {
+				outputs = append(outputs, id)
 			}
-			return outputs
 		}
 	}
-	return nil
+	return outputs
 }
 
 // GetStep returns a step by ID.
@@ -149,10 +152,14 @@ func (wa *WorkflowAnalyzer) GetWorkflowOutputs() []Output {
 }
 
 // ResolveStepTool resolves the tool for a workflow step.
-// Returns the parsed document if run is inline, or the path if it's a file reference.
+// Returns the parsed document if run is inline or a "#id" reference into
+// the packed $graph, or the path if it's a file reference.
 func (wa *WorkflowAnalyzer) ResolveStepTool(step *WorkflowStep) (*Document, string, error) {
 	switch v := step.Run.(type) {
 	case string:
+		if strings.HasPrefix(v, "#") {
+			return wa.resolveGraphTool(v)
+		}
 		// File path or reference
 		return nil, v, nil
 	case map[string]interface{}:
@@ -163,11 +170,29 @@ func (wa *WorkflowAnalyzer) ResolveStepTool(step *WorkflowStep) (*Document, stri
 			return nil, "", fmt.Errorf("failed to parse inline tool: %w", err)
 		}
 		return doc, "", nil
+	case *Document:
+		// Already parsed tool definition
+		return v, "", nil
 	default:
 		return nil, "", fmt.Errorf("unsupported run type: %T", v)
 	}
 }
 
+// resolveGraphTool parses the $graph process a "#id" run reference names.
+// The parsed process shares the graph, so its own steps resolve alike.
+func (wa *WorkflowAnalyzer) resolveGraphTool(ref string) (*Document, string, error) {
+	entry, ok := wa.doc.graph[strings.TrimPrefix(ref, "#")]
+	if !ok {
+		return nil, "", fmt.Errorf("run reference %s not found in $graph", ref)
+	}
+	doc, err := NewParser().parseDocument(entry)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse %s: %w", ref, err)
+	}
+	doc.graph = wa.doc.graph
+	return doc, "", nil
+}
+
 // CollectOutputSources maps workflow outputs to their step sources.
 func (wa *WorkflowAnalyzer) CollectOutputSources() map[string]string {
 	sources := make(map[string]string)
@@ -224,6 +249,19 @@ func (wa *WorkflowAnalyzer) ValidateWorkflow() []error {
 		outputIDs[output.ID] = true
 	}
 
+	// Check that named types resolve against SchemaDefRequirement
+	defs := wa.doc.SchemaDefs()
+	for _, input := range wa.doc.Inputs {
+		if _, err := defs.ParseType(input.Type); err != nil {
+			errors = append(errors, fmt.Errorf("input %s: %w", input.ID, err))
+		}
+	}
+	for _, output := range wa.doc.Outputs {
+		if _, err := defs.ParseType(output.Type); err != nil {
+			errors = append(errors, fmt.Errorf("output %s: %w", output.ID, err))
+		}
+	}
+
 	// Validate step input sources exist
 	for _, step := range wa.doc.Steps {
 		for _, in := range step.In {
@@ -236,6 +274,18 @@ func (wa *WorkflowAnalyzer) ValidateWorkflow() []error {
 		}
 	}
 
+	// valueFrom on step inputs requires StepInputExpressionRequirement
+	for _, step := range wa.doc.Steps {
+		if wa.doc.HasRequirement("StepInputExpressionRequirement") || stepHasRequirement(&step, "StepInputExpressionRequirement") {
+			continue
+		}
+		for _, in := range step.In {
+			if in.ValueFrom != "" {
+				errors = append(errors, fmt.Errorf("step %s input %s uses valueFrom without StepInputExpressionRequirement", step.ID, in.ID))
+			}
+		}
+	}
+
 	// Validate workflow output sources exist
 	for _, out := range wa.doc.Outputs {
 		sources := wa.getSources(out.OutputSource)
@@ -246,6 +296,10 @@ func (wa *WorkflowAnalyzer) ValidateWorkflow() []error {
 		}
 	}
 
+	// Expressions are plain parameter references unless
+	// InlineJavascriptRequirement applies
+	errors = append(errors, wa.validateExpressions(false)...)
+
 	// Check for cycles
 	deps, err := wa.GetStepDependencies()
 	if err != nil {
@@ -259,6 +313,76 @@ func (wa *WorkflowAnalyzer) ValidateWorkflow() []error {
 	return errors
 }
 
+// validateExpressions checks the expressions of the workflow's steps and
+// their tools. javascript is set when an enclosing workflow declares
+// InlineJavascriptRequirement.
+func (wa *WorkflowAnalyzer) validateExpressions(javascript bool) []error {
+	javascript = javascript || wa.doc.HasRequirement("InlineJavascriptRequirement")
+
+	var errs []error
+	for i := range wa.doc.Steps {
+		step := &wa.doc.Steps[i]
+		stepJS := javascript || stepHasRequirement(step, "InlineJavascriptRequirement")
+		if !stepJS {
+			if err := CheckParameterReferences(step.When); err != nil {
+				errs = append(errs, fmt.Errorf("step %s when: %w", step.ID, err))
+			}
+			for _, in := range step.In {
+				if err := CheckParameterReferences(in.ValueFrom); err != nil {
+					errs = append(errs, fmt.Errorf("step %s input %s valueFrom: %w", step.ID, in.ID, err))
+				}
+			}
+		}
+
+		tool := wa.stepTool(step)
+		if tool == nil {
+			continue
+		}
+		var toolErrs []error
+		switch tool.Class {
+		case ClassWorkflow:
+			toolErrs = NewWorkflowAnalyzer(tool).validateExpressions(stepJS)
+		case ClassCommandLineTool:
+			toolErrs = validateToolExpressions(tool, stepJS)
+		}
+		for _, err := range toolErrs {
+			errs = append(errs, fmt.Errorf("step %s: %w", step.ID, err))
+		}
+	}
+
+	return errs
+}
+
+// stepTool loads a step's tool for validation. It returns nil when the
+// tool cannot be loaded; the DAG builder reports that error.
+func (wa *WorkflowAnalyzer) stepTool(step *WorkflowStep) *Document {
+	tool, path, err := wa.ResolveStepTool(step)
+	if err != nil {
+		return nil
+	}
+	if tool == nil && path != "" {
+		if tool, err = NewParser().ParseFile(path); err != nil {
+			return nil
+		}
+	}
+	return tool
+}
+
+// stepHasRequirement checks if a step declares a requirement or hint class.
+func stepHasRequirement(step *WorkflowStep, class string) bool {
+	for _, req := range step.Requirements {
+		if req.Class == class {
+			return true
+		}
+	}
+	for _, hint := range step.Hints {
+		if hint.Class == class {
+			return true
+		}
+	}
+	return false
+}
+
 // sourceExists checks if a source reference is valid.
 func (wa *WorkflowAnalyzer) sourceExists(source string, stepIDs, inputIDs map[string]bool) bool {
 	source = strings.TrimPrefix(source, "#")
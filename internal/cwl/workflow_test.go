@@ -106,6 +106,28 @@ func TestWorkflowAnalyzer_ValidateWorkflow_Invalid(t *testing.T) {
 	}
 }
 
+func TestWorkflowAnalyzer_ValidateWorkflow_ValueFrom(t *testing.T) {
+	doc := &Document{
+		CWLVersion: "v1.2",
+		Class:      ClassWorkflow,
+		Inputs:     []Input{{ID: "input1", Type: "string"}},
+		Outputs:    []Output{{ID: "out1", Type: "File", OutputSource: "step1/out"}},
+		Steps: []WorkflowStep{
+			{ID: "step1", Run: "tool.cwl", In: []WorkflowStepInput{{ID: "in1", Source: "input1", ValueFrom: "$(self).txt"}}, Out: []interface{}{"out"}},
+		},
+	}
+
+	analyzer := NewWorkflowAnalyzer(doc)
+	if errs := analyzer.ValidateWorkflow(); len(errs) != 1 {
+		t.Errorf("Expected 1 error for valueFrom without StepInputExpressionRequirement, got: %v", errs)
+	}
+
+	doc.Requirements = []Requirement{{Class: "StepInputExpressionRequirement"}}
+	if errs := analyzer.ValidateWorkflow(); len(errs) != 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+}
+
 func TestWorkflowAnalyzer_DetectCycle(t *testing.T) {
 	// Create workflow with a cycle
 	doc := &Document{
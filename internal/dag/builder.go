@@ -2,6 +2,9 @@ package dag
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
@@ -12,6 +15,7 @@ type Builder struct {
 	workflow      *cwl.Document
 	workflowInputs map[string]interface{}
 	parser        *cwl.Parser
+	expanded      *cwl.Document // workflow with subworkflows expanded, set by Build
 }
 
 // NewBuilder creates a new DAG builder.
@@ -31,8 +35,23 @@ func (b *Builder) Build(dagID string) (*DAG, error) {
 
 	dag := NewDAG(dagID, b.workflow.ID)
 
+	// Expand nested subworkflows into namespaced steps
+	workflow, tools, err := b.expandSubworkflows(b.workflow)
+	if err != nil {
+		return nil, err
+	}
+	b.expanded = workflow
+
+	// Tools inherit the requirements of the step that runs them
+	for i := range workflow.Steps {
+		step := &workflow.Steps[i]
+		if tool := tools[step.ID]; tool != nil {
+			tools[step.ID] = inheritToolRequirements(tool, step.Requirements)
+		}
+	}
+
 	// Analyze workflow dependencies
-	analyzer := cwl.NewWorkflowAnalyzer(b.workflow)
+	analyzer := cwl.NewWorkflowAnalyzer(workflow)
 	deps, err := analyzer.GetStepDependencies()
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze dependencies: %w", err)
@@ -44,8 +63,23 @@ func (b *Builder) Build(dagID string) (*DAG, error) {
 		depMap[d.StepID] = d
 	}
 
+	// Steps inlined from a subworkflow step with a gate step wait for it,
+	// whether or not they read its outputs
+	for _, gate := range workflow.Steps {
+		if tool := tools[gate.ID]; tool == nil || tool.Class != cwl.ClassWorkflow {
+			continue
+		}
+		for _, step := range workflow.Steps {
+			d := depMap[step.ID]
+			if strings.HasPrefix(step.ID, gate.ID+SubworkflowSeparator) && !containsString(d.DependsOn, gate.ID) {
+				d.DependsOn = append(d.DependsOn, gate.ID)
+				depMap[step.ID] = d
+			}
+		}
+	}
+
 	// Create nodes for each step
-	for _, step := range b.workflow.Steps {
+	for _, step := range workflow.Steps {
 		// Check for scatter
 		scatterConfig, err := cwl.ParseScatterConfig(&step)
 		if err != nil {
@@ -58,19 +92,7 @@ func (b *Builder) Build(dagID string) (*DAG, error) {
 			return nil, fmt.Errorf("failed to resolve inputs for step %s: %w", step.ID, err)
 		}
 
-		// Resolve the tool
-		tool, toolPath, err := analyzer.ResolveStepTool(&step)
-		if err != nil {
-			return nil, fmt.Errorf("failed to resolve tool for step %s: %w", step.ID, err)
-		}
-
-		// If tool is a file path, parse it
-		if tool == nil && toolPath != "" {
-			tool, err = b.parser.ParseFile(toolPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse tool %s for step %s: %w", toolPath, step.ID, err)
-			}
-		}
+		tool := tools[step.ID]
 
 		if scatterConfig != nil {
 			// Create expanded nodes for scatter
@@ -160,7 +182,7 @@ func (b *Builder) resolveStepInputs(step *cwl.WorkflowStep, scatterValues map[st
 
 		// Try to resolve from source
 		if in.Source != nil {
-			resolved, err := b.resolveSource(in.Source)
+			resolved, err := resolveLink(in.Source, in.LinkMerge, in.PickValue, b.resolveSourceString)
 			if err != nil {
 				// Source might reference a step output that isn't available yet
 				// Store the source reference for later resolution
@@ -183,28 +205,6 @@ func (b *Builder) resolveStepInputs(step *cwl.WorkflowStep, scatterValues map[st
 	return inputs, nil
 }
 
-// resolveSource resolves a source reference to its value.
-func (b *Builder) resolveSource(source interface{}) (interface{}, error) {
-	switch v := source.(type) {
-	case string:
-		return b.resolveSourceString(v)
-	case []interface{}:
-		var values []interface{}
-		for _, item := range v {
-			if s, ok := item.(string); ok {
-				val, err := b.resolveSourceString(s)
-				if err != nil {
-					return nil, err
-				}
-				values = append(values, val)
-			}
-		}
-		return values, nil
-	default:
-		return nil, fmt.Errorf("unsupported source type: %T", source)
-	}
-}
-
 // resolveSourceString resolves a single source string.
 func (b *Builder) resolveSourceString(source string) (interface{}, error) {
 	source = strings.TrimPrefix(source, "#")
@@ -257,12 +257,15 @@ func (b *Builder) linkDependencies(dag *DAG) error {
 	return nil
 }
 
-// ResolveStepOutputs resolves step outputs from a completed node.
+// ResolveStepOutputs resolves step outputs from a completed node. Skipped
+// nodes contribute null, so a scattered conditional step gathers one value
+// per element in scatter order.
 func ResolveStepOutputs(dag *DAG, stepID string, outputID string) (interface{}, error) {
 	// Find all nodes for this step
 	var nodes []*Node
 	for _, node := range dag.Nodes {
-		if node.StepID == stepID && node.GetStatus() == StatusCompleted {
+		status := node.GetStatus()
+		if node.StepID == stepID && (status == StatusCompleted || status == StatusSkipped) {
 			nodes = append(nodes, node)
 		}
 	}
@@ -271,15 +274,21 @@ func ResolveStepOutputs(dag *DAG, stepID string, outputID string) (interface{},
 		return nil, fmt.Errorf("no completed nodes for step: %s", stepID)
 	}
 
-	// If single node, return the output directly
-	if len(nodes) == 1 {
+	// If single non-scattered node, return the output directly
+	if len(nodes) == 1 && !nodes[0].IsScattered() {
 		if val, ok := nodes[0].Outputs[outputID]; ok {
 			return val, nil
 		}
+		if nodes[0].GetStatus() == StatusSkipped {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("output not found: %s/%s", stepID, outputID)
 	}
 
 	// Multiple nodes (scattered) - gather outputs into array
+	sort.Slice(nodes, func(i, j int) bool {
+		return compareScatterIndex(nodes[i].ScatterIndex, nodes[j].ScatterIndex) < 0
+	})
 	var gathered []interface{}
 	for _, node := range nodes {
 		if val, ok := node.Outputs[outputID]; ok {
@@ -292,64 +301,63 @@ func ResolveStepOutputs(dag *DAG, stepID string, outputID string) (interface{},
 	return gathered, nil
 }
 
+// compareScatterIndex orders scatter indices lexicographically.
+func compareScatterIndex(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return len(a) - len(b)
+}
+
 // PrepareNodeInputs resolves input values for a node from completed dependencies.
 func PrepareNodeInputs(dag *DAG, node *Node, workflowInputs map[string]interface{}) (map[string]interface{}, error) {
-	inputs := make(map[string]interface{})
+	inputs, err := ResolveStepInputs(dag, node, workflowInputs)
+	if err != nil {
+		return nil, err
+	}
+	if err := PrepareToolInputs(node.Tool, inputs); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
 
-	for _, in := range node.Step.In {
-		var value interface{}
+// ResolveStepInputs resolves a node's step inputs from their sources,
+// defaults and valueFrom: the inputs its when condition sees. It does not
+// touch the files they refer to.
+func ResolveStepInputs(dag *DAG, node *Node, workflowInputs map[string]interface{}) (map[string]interface{}, error) {
+	inputs := make(map[string]interface{})
 
-		// First check if we already have a resolved value
-		if v, ok := node.Inputs[in.ID]; ok {
-			// Check if it's a source reference that needs resolution
-			if src, isStr := v.(string); isStr && strings.Contains(src, "/") {
-				resolved, err := resolveRuntimeSource(dag, src, workflowInputs)
-				if err != nil {
-					return nil, fmt.Errorf("failed to resolve source %s for input %s: %w", src, in.ID, err)
-				}
-				value = resolved
-			} else if srcArr, isArr := v.([]interface{}); isArr {
-				// Check if array contains source references
-				var resolved []interface{}
-				for _, item := range srcArr {
-					if src, isStr := item.(string); isStr && strings.Contains(src, "/") {
-						r, err := resolveRuntimeSource(dag, src, workflowInputs)
-						if err != nil {
-							return nil, err
-						}
-						resolved = append(resolved, r)
-					} else {
-						resolved = append(resolved, item)
-					}
-				}
-				value = resolved
-			} else {
-				value = v
+	// Scattered inputs already hold this node's element
+	scattered := make(map[string]bool)
+	if node.IsScattered() {
+		config, err := cwl.ParseScatterConfig(node.Step)
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			for _, id := range config.InputIDs {
+				scattered[id] = true
 			}
 		}
+	}
 
-		// Try to resolve from source
-		if value == nil && in.Source != nil {
-			switch src := in.Source.(type) {
-			case string:
-				resolved, err := resolveRuntimeSource(dag, src, workflowInputs)
-				if err != nil {
-					return nil, err
-				}
-				value = resolved
-			case []interface{}:
-				var resolved []interface{}
-				for _, item := range src {
-					if s, ok := item.(string); ok {
-						r, err := resolveRuntimeSource(dag, s, workflowInputs)
-						if err != nil {
-							return nil, err
-						}
-						resolved = append(resolved, r)
-					}
-				}
-				value = resolved
+	resolve := func(src string) (interface{}, error) {
+		return resolveRuntimeSource(dag, src, workflowInputs)
+	}
+
+	for _, in := range node.Step.In {
+		var value interface{}
+
+		if scattered[in.ID] {
+			value = node.Inputs[in.ID]
+		} else if in.Source != nil {
+			resolved, err := resolveLink(in.Source, in.LinkMerge, in.PickValue, resolve)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve source for input %s: %w", in.ID, err)
 			}
+			value = resolved
 		}
 
 		// Use default if still nil
@@ -362,9 +370,261 @@ func PrepareNodeInputs(dag *DAG, node *Node, workflowInputs map[string]interface
 		}
 	}
 
+	// Evaluate valueFrom against the source values; results are not
+	// visible to other inputs' valueFrom expressions
+	var evaluated map[string]interface{}
+	var ee *cwl.ExpressionEvaluator
+	for _, in := range node.Step.In {
+		if in.ValueFrom == "" {
+			continue
+		}
+		if evaluated == nil {
+			evaluated = make(map[string]interface{}, len(node.Step.In))
+			ee = newStepEvaluator(node.Step)
+			ee.SetInputs(inputs)
+		}
+		ee.SetSelf(inputs[in.ID])
+		value, err := ee.Evaluate(in.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate valueFrom for input %s: %w", in.ID, err)
+		}
+		evaluated[in.ID] = value
+	}
+	for id, value := range evaluated {
+		if value == nil {
+			delete(inputs, id)
+		} else {
+			inputs[id] = value
+		}
+	}
+
 	return inputs, nil
 }
 
+// PrepareToolInputs completes resolved step inputs, in place, with what the
+// tool asks of its File and Directory inputs: secondaryFiles, listings and
+// contents. Steps that are skipped never need it.
+func PrepareToolInputs(tool *cwl.Document, inputs map[string]interface{}) error {
+	if err := attachInputSecondaryFiles(tool, inputs); err != nil {
+		return err
+	}
+	if err := applyInputListings(tool, inputs); err != nil {
+		return err
+	}
+	return loadInputContents(tool, inputs)
+}
+
+// attachInputSecondaryFiles discovers the secondaryFiles the tool declares
+// for its File inputs and adds them to the input values in place.
+func attachInputSecondaryFiles(tool *cwl.Document, inputs map[string]interface{}) error {
+	if tool == nil {
+		return nil
+	}
+
+	var ee *cwl.ExpressionEvaluator
+	for _, in := range tool.Inputs {
+		value, ok := inputs[in.ID]
+		if !ok || len(in.SecondaryFiles) == 0 {
+			continue
+		}
+		if ee == nil {
+			ee = cwl.NewExpressionEvaluator()
+			ee.SetInputs(inputs)
+			ee.SetRequirements(tool.Requirements, tool.Hints)
+		}
+
+		attached, err := mapFiles(value, func(file map[string]interface{}) (map[string]interface{}, error) {
+			return cwl.AttachSecondaryFiles(ee, file, in.SecondaryFiles, true, secondaryFileExists)
+		})
+		if err != nil {
+			return fmt.Errorf("input %s: %w", in.ID, err)
+		}
+		inputs[in.ID] = attached
+	}
+	return nil
+}
+
+// applyInputListings sets the listing of the tool's Directory inputs per
+// their loadListing, in place. Directory literals keep the listing that
+// defines them. Only directories visible to the scheduler can be listed;
+// others (Workspace) are listed when staged.
+func applyInputListings(tool *cwl.Document, inputs map[string]interface{}) error {
+	if tool == nil {
+		return nil
+	}
+
+	for _, in := range tool.Inputs {
+		value, ok := inputs[in.ID]
+		if !ok {
+			continue
+		}
+		loadListing := tool.InputLoadListing(in)
+
+		listed, err := mapClass(value, cwl.TypeDirectory, func(dir map[string]interface{}) (map[string]interface{}, error) {
+			return applyListing(dir, loadListing)
+		})
+		if err != nil {
+			return fmt.Errorf("input %s: %w", in.ID, err)
+		}
+		inputs[in.ID] = listed
+	}
+	return nil
+}
+
+// applyListing returns a copy of dir with its listing loaded or removed
+// per loadListing.
+func applyListing(dir map[string]interface{}, loadListing string) (map[string]interface{}, error) {
+	if cwl.IsDirectoryLiteral(dir) {
+		return dir, nil
+	}
+
+	path := objectPath(dir)
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return dir, nil
+	}
+
+	listed := make(map[string]interface{}, len(dir)+1)
+	for k, v := range dir {
+		listed[k] = v
+	}
+
+	switch loadListing {
+	case cwl.ShallowListing, cwl.DeepListing:
+		listing, err := cwl.ListDirectory(path, loadListing == cwl.DeepListing)
+		if err != nil {
+			return nil, err
+		}
+		listed["listing"] = listing
+	case cwl.NoListing:
+		delete(listed, "listing")
+	default:
+		return nil, fmt.Errorf("invalid loadListing: %q", loadListing)
+	}
+	return listed, nil
+}
+
+// loadInputContents reads the contents of the tool's File inputs that set
+// loadContents, in place, so expressions can use them. File literals
+// already carry their contents. Only files visible to the scheduler can be
+// read; others (Workspace, Shock) are left without contents.
+func loadInputContents(tool *cwl.Document, inputs map[string]interface{}) error {
+	if tool == nil {
+		return nil
+	}
+
+	for _, in := range tool.Inputs {
+		value, ok := inputs[in.ID]
+		if !ok || !in.LoadContents {
+			continue
+		}
+
+		loaded, err := mapFiles(value, loadContents)
+		if err != nil {
+			return fmt.Errorf("input %s: %w", in.ID, err)
+		}
+		inputs[in.ID] = loaded
+	}
+	return nil
+}
+
+// loadContents returns a copy of file with its contents loaded.
+func loadContents(file map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := file["contents"].(string); ok {
+		return file, nil
+	}
+
+	path := objectPath(file)
+	if _, err := os.Stat(path); err != nil {
+		return file, nil
+	}
+
+	contents, err := cwl.ReadContents(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]interface{}, len(file)+1)
+	for k, v := range file {
+		loaded[k] = v
+	}
+	loaded["contents"] = contents
+	return loaded, nil
+}
+
+// objectPath returns the path of a File or Directory object.
+func objectPath(obj map[string]interface{}) string {
+	if path, ok := obj["path"].(string); ok && path != "" {
+		return path
+	}
+	location, _ := obj["location"].(string)
+	return strings.TrimPrefix(location, "file://")
+}
+
+// mapFiles applies fn to a File value or to each File in an array.
+func mapFiles(value interface{}, fn func(map[string]interface{}) (map[string]interface{}, error)) (interface{}, error) {
+	return mapClass(value, cwl.TypeFile, fn)
+}
+
+// mapClass applies fn to an object of the given class, File or Directory,
+// or to each such object in an array.
+func mapClass(value interface{}, class string, fn func(map[string]interface{}) (map[string]interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if c, _ := v["class"].(string); c != class {
+			return value, nil
+		}
+		return fn(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			mapped, err := mapClass(item, class, fn)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = mapped
+		}
+		return out, nil
+	default:
+		return value, nil
+	}
+}
+
+// secondaryFileExists reports whether a secondary file is present. Only
+// files whose directory is visible to the scheduler can be checked; others
+// (Workspace, Shock) are assumed present and are checked when staged.
+func secondaryFileExists(path string) bool {
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// CollectOutputs resolves the workflow outputs from a completed DAG built by b.
+func (b *Builder) CollectOutputs(dag *DAG) (map[string]interface{}, error) {
+	if b.expanded == nil {
+		return nil, fmt.Errorf("workflow outputs requested before Build")
+	}
+
+	resolve := func(src string) (interface{}, error) {
+		return resolveRuntimeSource(dag, src, b.workflowInputs)
+	}
+
+	outputs := make(map[string]interface{}, len(b.expanded.Outputs))
+	for _, out := range b.expanded.Outputs {
+		if out.OutputSource == nil {
+			outputs[out.ID] = nil
+			continue
+		}
+		value, err := resolveLink(out.OutputSource, out.LinkMerge, out.PickValue, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve workflow output %s: %w", out.ID, err)
+		}
+		outputs[out.ID] = value
+	}
+
+	return outputs, nil
+}
+
 // resolveRuntimeSource resolves a source reference at runtime.
 func resolveRuntimeSource(dag *DAG, source string, workflowInputs map[string]interface{}) (interface{}, error) {
 	source = strings.TrimPrefix(source, "#")
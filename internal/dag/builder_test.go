@@ -1,6 +1,8 @@
 package dag
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
@@ -416,3 +418,345 @@ func TestPrepareNodeInputs_WorkflowInputs(t *testing.T) {
 		t.Errorf("Expected path '/path/to/input.fasta', got %v", fileMap["path"])
 	}
 }
+
+func TestPrepareNodeInputs_SecondaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	bam := filepath.Join(dir, "reads.bam")
+	os.WriteFile(bam, []byte("bam"), 0644)
+	os.WriteFile(bam+".bai", []byte("bai"), 0644)
+
+	dag := NewDAG("test", "wf")
+	node := &Node{
+		ID:     "index",
+		StepID: "index",
+		Status: StatusReady,
+		Step: &cwl.WorkflowStep{
+			ID: "index",
+			In: []cwl.WorkflowStepInput{{ID: "bam", Source: "bam"}},
+		},
+		Tool: &cwl.Document{
+			Class: cwl.ClassCommandLineTool,
+			Inputs: []cwl.Input{
+				{ID: "bam", Type: "File", SecondaryFiles: []cwl.SecondaryFileSpec{{Pattern: ".bai"}}},
+			},
+		},
+	}
+	dag.AddNode(node)
+
+	workflowInputs := map[string]interface{}{
+		"bam": map[string]interface{}{"class": "File", "path": bam},
+	}
+
+	inputs, err := PrepareNodeInputs(dag, node, workflowInputs)
+	if err != nil {
+		t.Fatalf("Failed to prepare inputs: %v", err)
+	}
+	fileMap := inputs["bam"].(map[string]interface{})
+	secondaries, ok := fileMap["secondaryFiles"].([]interface{})
+	if !ok || len(secondaries) != 1 {
+		t.Fatalf("Expected 1 secondary file, got %v", fileMap["secondaryFiles"])
+	}
+	if sf := secondaries[0].(map[string]interface{}); sf["path"] != bam+".bai" {
+		t.Errorf("Expected %s.bai, got %v", bam, sf["path"])
+	}
+
+	// A required secondary file that is missing is an error
+	os.Remove(bam + ".bai")
+	if _, err := PrepareNodeInputs(dag, node, workflowInputs); err == nil {
+		t.Error("Expected error for missing required secondary file")
+	}
+}
+
+func TestPrepareNodeInputs_LoadListing(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "blastdb")
+	os.MkdirAll(filepath.Join(db, "taxonomy"), 0755)
+	os.WriteFile(filepath.Join(db, "nr.pal"), []byte("pal"), 0644)
+	os.WriteFile(filepath.Join(db, "taxonomy", "taxdb.btd"), []byte("btd"), 0644)
+
+	tests := []struct {
+		loadListing string
+		expected    int // entries in the listing, -1 for none
+		deep        bool
+	}{
+		{loadListing: "", expected: -1},
+		{loadListing: cwl.NoListing, expected: -1},
+		{loadListing: cwl.ShallowListing, expected: 2},
+		{loadListing: cwl.DeepListing, expected: 2, deep: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.loadListing, func(t *testing.T) {
+			dag := NewDAG("test", "wf")
+			node := &Node{
+				ID:     "blast",
+				StepID: "blast",
+				Status: StatusReady,
+				Step: &cwl.WorkflowStep{
+					ID: "blast",
+					In: []cwl.WorkflowStepInput{{ID: "db", Source: "db"}},
+				},
+				Tool: &cwl.Document{
+					Class:  cwl.ClassCommandLineTool,
+					Inputs: []cwl.Input{{ID: "db", Type: "Directory", LoadListing: tt.loadListing}},
+				},
+			}
+			dag.AddNode(node)
+
+			// A listing supplied with a located Directory is replaced
+			workflowInputs := map[string]interface{}{
+				"db": map[string]interface{}{"class": "Directory", "path": db, "listing": []interface{}{}},
+			}
+
+			inputs, err := PrepareNodeInputs(dag, node, workflowInputs)
+			if err != nil {
+				t.Fatalf("Failed to prepare inputs: %v", err)
+			}
+			dir := inputs["db"].(map[string]interface{})
+			listing, ok := dir["listing"].([]interface{})
+			if tt.expected < 0 {
+				if ok {
+					t.Fatalf("Expected no listing, got %v", listing)
+				}
+				return
+			}
+			if len(listing) != tt.expected {
+				t.Fatalf("Expected %d entries, got %v", tt.expected, listing)
+			}
+			for _, item := range listing {
+				entry := item.(map[string]interface{})
+				if entry["basename"] != "taxonomy" {
+					continue
+				}
+				if _, hasListing := entry["listing"]; hasListing != tt.deep {
+					t.Errorf("Expected nested listing %v, got %v", tt.deep, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareNodeInputs_LoadContents(t *testing.T) {
+	dir := t.TempDir()
+	params := filepath.Join(dir, "params.txt")
+	os.WriteFile(params, []byte("k=31\n"), 0644)
+	large := filepath.Join(dir, "large.txt")
+	os.WriteFile(large, make([]byte, cwl.MaxContentsSize+1), 0644)
+
+	dag := NewDAG("test", "wf")
+	node := &Node{
+		ID:     "assemble",
+		StepID: "assemble",
+		Status: StatusReady,
+		Step: &cwl.WorkflowStep{
+			ID: "assemble",
+			In: []cwl.WorkflowStepInput{
+				{ID: "params", Source: "params"},
+				{ID: "extra", Source: "extra"},
+			},
+		},
+		Tool: &cwl.Document{
+			Class: cwl.ClassCommandLineTool,
+			Inputs: []cwl.Input{
+				{ID: "params", Type: "File", LoadContents: true},
+				{ID: "extra", Type: "File", LoadContents: true},
+			},
+		},
+	}
+	dag.AddNode(node)
+
+	workflowInputs := map[string]interface{}{
+		"params": map[string]interface{}{"class": "File", "path": params},
+		"extra":  map[string]interface{}{"class": "File", "basename": "extra.txt", "contents": "inline"},
+	}
+
+	inputs, err := PrepareNodeInputs(dag, node, workflowInputs)
+	if err != nil {
+		t.Fatalf("Failed to prepare inputs: %v", err)
+	}
+	if contents := inputs["params"].(map[string]interface{})["contents"]; contents != "k=31\n" {
+		t.Errorf("Expected contents loaded, got %v", contents)
+	}
+	if contents := inputs["extra"].(map[string]interface{})["contents"]; contents != "inline" {
+		t.Errorf("Expected File literal contents kept, got %v", contents)
+	}
+	if _, ok := workflowInputs["params"].(map[string]interface{})["contents"]; ok {
+		t.Error("loadContents modified the workflow input")
+	}
+
+	// Files over the limit are an error
+	workflowInputs["params"] = map[string]interface{}{"class": "File", "path": large}
+	if _, err := PrepareNodeInputs(dag, node, workflowInputs); err == nil {
+		t.Error("Expected error for loadContents beyond 64 KiB")
+	}
+}
+
+func TestPrepareNodeInputs_ValueFrom(t *testing.T) {
+	dag := NewDAG("test", "wf")
+
+	node := &Node{
+		ID:     "sort",
+		StepID: "sort",
+		Status: StatusReady,
+		Step: &cwl.WorkflowStep{
+			ID: "sort",
+			In: []cwl.WorkflowStepInput{
+				{ID: "sample_id", Source: "sample"},
+				{ID: "output_name", Source: "sample", ValueFrom: "$(self).sorted.bam"},
+				{ID: "read_group", ValueFrom: "$(inputs.sample_id + '_' + inputs.output_name)"},
+				{ID: "threads", Source: "threads", ValueFrom: "${ return self * 2; }"},
+			},
+			Requirements: []cwl.Requirement{{Class: "InlineJavascriptRequirement"}},
+		},
+	}
+	dag.AddNode(node)
+
+	workflowInputs := map[string]interface{}{
+		"sample":  "S1",
+		"threads": 4,
+	}
+
+	inputs, err := PrepareNodeInputs(dag, node, workflowInputs)
+	if err != nil {
+		t.Fatalf("Failed to prepare inputs: %v", err)
+	}
+
+	if inputs["output_name"] != "S1.sorted.bam" {
+		t.Errorf("Expected output_name 'S1.sorted.bam', got %v", inputs["output_name"])
+	}
+	// valueFrom sees source values, not other inputs' valueFrom results
+	if inputs["read_group"] != "S1_S1" {
+		t.Errorf("Expected read_group 'S1_S1', got %v", inputs["read_group"])
+	}
+	if threads, ok := inputs["threads"].(int64); !ok || threads != 8 {
+		t.Errorf("Expected threads 8, got %v (%T)", inputs["threads"], inputs["threads"])
+	}
+	if inputs["sample_id"] != "S1" {
+		t.Errorf("Expected sample_id 'S1', got %v", inputs["sample_id"])
+	}
+}
+
+func TestPrepareNodeInputs_ValueFromExpressionLib(t *testing.T) {
+	dag := NewDAG("test", "wf")
+
+	node := &Node{
+		ID:     "step1",
+		StepID: "step1",
+		Status: StatusReady,
+		Step: &cwl.WorkflowStep{
+			ID: "step1",
+			In: []cwl.WorkflowStepInput{
+				{ID: "label", Source: "sample", ValueFrom: "$(shout(self))"},
+			},
+			Requirements: []cwl.Requirement{{
+				Class:         "InlineJavascriptRequirement",
+				ExpressionLib: []string{"function shout(s) { return s.toUpperCase(); }"},
+			}},
+		},
+	}
+	dag.AddNode(node)
+
+	inputs, err := PrepareNodeInputs(dag, node, map[string]interface{}{"sample": "s1"})
+	if err != nil {
+		t.Fatalf("Failed to prepare inputs: %v", err)
+	}
+	if inputs["label"] != "S1" {
+		t.Errorf("Expected label 'S1', got %v", inputs["label"])
+	}
+}
+
+func TestBuilder_Build_RelativeRunFile(t *testing.T) {
+	dir := t.TempDir()
+	tool := `
+cwlVersion: v1.2
+class: CommandLineTool
+baseCommand: echo
+inputs: []
+outputs: []
+`
+	workflow := `
+cwlVersion: v1.2
+class: Workflow
+inputs: []
+outputs: []
+steps:
+  say:
+    run: echo.cwl
+    in: []
+    out: []
+`
+	if err := os.WriteFile(filepath.Join(dir, "echo.cwl"), []byte(tool), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wfPath := filepath.Join(dir, "workflow.cwl")
+	if err := os.WriteFile(wfPath, []byte(workflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := cwl.NewParser().ParseFile(wfPath)
+	if err != nil {
+		t.Fatalf("Failed to parse workflow: %v", err)
+	}
+
+	// The tool must be found relative to the workflow, not the test's cwd
+	dag, err := NewBuilder(doc, nil).Build("test-dag")
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+	node := dag.GetNode("say")
+	if node == nil || node.Tool == nil || node.Tool.Class != cwl.ClassCommandLineTool {
+		t.Errorf("Expected resolved CommandLineTool for step say, got %+v", node)
+	}
+}
+
+func TestBuilder_Build_PackedGraph(t *testing.T) {
+	packed := `{
+  "cwlVersion": "v1.2",
+  "$graph": [
+    {"id": "#echo", "class": "CommandLineTool", "baseCommand": "echo",
+     "inputs": [], "outputs": [{"id": "out", "type": "stdout"}]},
+    {"id": "#main", "class": "Workflow", "inputs": [], "outputs": [],
+     "steps": [{"id": "say", "run": "#echo", "in": [], "out": ["out"]}]}
+  ]
+}`
+
+	doc, err := cwl.NewParser().ParseBytes([]byte(packed))
+	if err != nil {
+		t.Fatalf("Failed to parse packed workflow: %v", err)
+	}
+
+	dag, err := NewBuilder(doc, nil).Build("test-dag")
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+	node := dag.GetNode("say")
+	if node == nil || node.Tool == nil || node.Tool.BaseCommand != "echo" {
+		t.Errorf("Expected #echo tool for step say, got %+v", node)
+	}
+}
+
+func TestBuilder_Build_CwltoolPacked(t *testing.T) {
+	doc, err := cwl.NewParser().ParseFile(filepath.Join("..", "cwl", "testdata", "packed-echo.json"))
+	if err != nil {
+		t.Fatalf("Failed to parse packed workflow: %v", err)
+	}
+	workflowInputs := map[string]interface{}{
+		"msg":   "hello",
+		"names": []interface{}{"a", "b"},
+	}
+
+	dag, err := NewBuilder(doc, workflowInputs).Build("test")
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+	node := dag.GetNode("say")
+	if node == nil {
+		t.Fatalf("Expected node for step say, got %v", dag.Nodes)
+	}
+	inputs, err := PrepareNodeInputs(dag, node, workflowInputs)
+	if err != nil {
+		t.Fatalf("Failed to prepare inputs: %v", err)
+	}
+	if inputs["msg"] != "hello" {
+		t.Errorf("Expected msg=hello, got %v", inputs["msg"])
+	}
+}
@@ -0,0 +1,34 @@
+package dag
+
+import (
+	"fmt"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+// IsConditional returns true if the node's step has a `when` condition.
+func (n *Node) IsConditional() bool {
+	return n.Step != nil && n.Step.When != ""
+}
+
+// EvaluateWhen evaluates the node's `when` condition against its resolved
+// inputs. Nodes without a condition always run.
+func EvaluateWhen(node *Node, inputs map[string]interface{}) (bool, error) {
+	if !node.IsConditional() {
+		return true, nil
+	}
+
+	run, err := newStepEvaluator(node.Step).EvaluateCondition(node.Step.When, inputs)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate when for node %s: %w", node.ID, err)
+	}
+	return run, nil
+}
+
+// newStepEvaluator creates an expression evaluator for the step's
+// requirements, including those inherited from its workflow.
+func newStepEvaluator(step *cwl.WorkflowStep) *cwl.ExpressionEvaluator {
+	ee := cwl.NewExpressionEvaluator()
+	ee.SetRequirements(step.Requirements, step.Hints)
+	return ee
+}
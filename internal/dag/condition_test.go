@@ -0,0 +1,180 @@
+package dag
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+func newConditionalNode(id string, scatterIndex []int, when string) *Node {
+	return &Node{
+		ID:           id,
+		StepID:       "qc_gate",
+		ScatterIndex: scatterIndex,
+		Status:       StatusReady,
+		Step: &cwl.WorkflowStep{
+			ID:           "qc_gate",
+			When:         when,
+			In:           []cwl.WorkflowStepInput{{ID: "score", Source: "score"}},
+			Out:          []interface{}{"report", map[string]interface{}{"id": "log"}},
+			Requirements: []cwl.Requirement{{Class: "InlineJavascriptRequirement"}},
+		},
+		Dependencies: []string{},
+		Dependents:   []string{},
+	}
+}
+
+func TestEvaluateWhen(t *testing.T) {
+	node := newConditionalNode("qc_gate", nil, "$(inputs.score > 30)")
+
+	tests := []struct {
+		score    int
+		expected bool
+	}{
+		{40, true},
+		{10, false},
+	}
+	for _, tc := range tests {
+		run, err := EvaluateWhen(node, map[string]interface{}{"score": tc.score})
+		if err != nil {
+			t.Fatalf("EvaluateWhen failed: %v", err)
+		}
+		if run != tc.expected {
+			t.Errorf("EvaluateWhen(score=%d) = %v, expected %v", tc.score, run, tc.expected)
+		}
+	}
+
+	// Unconditional nodes always run
+	node.Step.When = ""
+	if run, err := EvaluateWhen(node, nil); err != nil || !run {
+		t.Errorf("Expected unconditional node to run, got %v (err: %v)", run, err)
+	}
+}
+
+func TestEvaluateWhen_BeforeToolInputs(t *testing.T) {
+	d := NewDAG("test", "wf")
+	node := newConditionalNode("qc_gate", nil, "$(inputs.score > 30)")
+	node.Step.In = append(node.Step.In, cwl.WorkflowStepInput{ID: "reads", Source: "reads"})
+	node.Tool = &cwl.Document{
+		Class: cwl.ClassCommandLineTool,
+		Inputs: []cwl.Input{{
+			ID:             "reads",
+			Type:           "File",
+			LoadContents:   true,
+			SecondaryFiles: []cwl.SecondaryFileSpec{{Pattern: ".bai", Required: true}},
+		}},
+	}
+	d.AddNode(node)
+
+	// The reads lack their index, which only matters if the step runs
+	reads := filepath.Join(t.TempDir(), "sample.bam")
+	os.WriteFile(reads, []byte("bam"), 0644)
+	workflowInputs := map[string]interface{}{
+		"score": 10,
+		"reads": map[string]interface{}{"class": "File", "path": reads},
+	}
+	inputs, err := ResolveStepInputs(d, node, workflowInputs)
+	if err != nil {
+		t.Fatalf("ResolveStepInputs failed: %v", err)
+	}
+	if run, err := EvaluateWhen(node, inputs); err != nil || run {
+		t.Errorf("Expected the step to be skipped, got %v (err: %v)", run, err)
+	}
+	if err := PrepareToolInputs(node.Tool, inputs); err == nil {
+		t.Error("Expected PrepareToolInputs to fail for the missing index")
+	}
+}
+
+func TestDAG_SkipNode(t *testing.T) {
+	d := NewDAG("test", "wf")
+	node := newConditionalNode("qc_gate", nil, "$(false)")
+	node.Dependents = []string{"consumer"}
+	d.AddNode(node)
+	d.AddNode(&Node{ID: "consumer", StepID: "consumer", Status: StatusPending, Dependencies: []string{"qc_gate"}})
+
+	if err := d.SkipNode("qc_gate"); err != nil {
+		t.Fatalf("SkipNode failed: %v", err)
+	}
+
+	if node.GetStatus() != StatusSkipped {
+		t.Errorf("Expected qc_gate skipped, got %s", node.GetStatus())
+	}
+	expected := map[string]interface{}{"report": nil, "log": nil}
+	if !reflect.DeepEqual(node.Outputs, expected) {
+		t.Errorf("Expected null outputs %v, got %v", expected, node.Outputs)
+	}
+	if status := d.GetNode("consumer").GetStatus(); status != StatusReady {
+		t.Errorf("Expected consumer ready after skip, got %s", status)
+	}
+
+	value, err := ResolveStepOutputs(d, "qc_gate", "report")
+	if err != nil || value != nil {
+		t.Errorf("Expected null output from skipped step, got %v (err: %v)", value, err)
+	}
+}
+
+func TestScheduler_SkipsScatteredConditionalElements(t *testing.T) {
+	d := NewDAG("test", "wf")
+	scores := []int{50, 5, 70}
+	for i, score := range scores {
+		node := newConditionalNode(GenerateNodeID("qc_gate", []int{i}), []int{i}, "$(inputs.score > 30)")
+		node.Inputs = map[string]interface{}{"score": score}
+		d.AddNode(node)
+	}
+
+	executor := &recordingExecutor{}
+	s := NewScheduler(d, executor, 0)
+	s.ctx = context.Background()
+
+	if err := s.scheduleReadyNodes(); err != nil {
+		t.Fatalf("scheduleReadyNodes failed: %v", err)
+	}
+
+	if len(executor.executed) != 2 {
+		t.Errorf("Expected 2 nodes executed, got %v", executor.executed)
+	}
+	skipped := d.GetNode(GenerateNodeID("qc_gate", []int{1}))
+	if skipped.GetStatus() != StatusSkipped {
+		t.Fatalf("Expected element 1 skipped, got %s", skipped.GetStatus())
+	}
+
+	// Complete the elements that ran and gather per-element nulls
+	for _, i := range []int{0, 2} {
+		node := d.GetNode(GenerateNodeID("qc_gate", []int{i}))
+		node.SetOutputs(map[string]interface{}{"report": i})
+		node.SetStatus(StatusCompleted)
+	}
+	gathered, err := ResolveStepOutputs(d, "qc_gate", "report")
+	if err != nil {
+		t.Fatalf("ResolveStepOutputs failed: %v", err)
+	}
+	if expected := []interface{}{0, nil, 2}; !reflect.DeepEqual(gathered, expected) {
+		t.Errorf("Expected gathered %v, got %v", expected, gathered)
+	}
+}
+
+// recordingExecutor records executed nodes and leaves them running.
+type recordingExecutor struct {
+	executed []string
+}
+
+func (e *recordingExecutor) Execute(ctx context.Context, node *Node) error {
+	e.executed = append(e.executed, node.ID)
+	return nil
+}
+
+func (e *recordingExecutor) GetStatus(ctx context.Context, taskID string) (NodeStatus, error) {
+	return StatusRunning, nil
+}
+
+func (e *recordingExecutor) GetOutputs(ctx context.Context, taskID string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (e *recordingExecutor) Cancel(ctx context.Context, taskID string) error {
+	return nil
+}
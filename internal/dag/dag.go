@@ -3,7 +3,9 @@ package dag
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
 )
@@ -35,7 +37,9 @@ type Node struct {
 	Step         *cwl.WorkflowStep
 	Tool         *cwl.Document // Resolved tool for this step
 	Error        string
-	TaskID       string // BV-BRC Task ID when running
+	TaskID       string    // BV-BRC Task ID when running
+	Retries      int       // Times the node was retried after a temporary failure
+	RetryAt      time.Time // Earliest time of the next retry
 	mu           sync.RWMutex
 }
 
@@ -112,11 +116,11 @@ func (d *DAG) UpdateNodeStatus(nodeID string, status NodeStatus) error {
 
 	node.SetStatus(status)
 
-	// If node completed, check if dependents are now ready
-	if status == StatusCompleted {
+	// If node completed or was skipped, check if dependents are now ready
+	if status == StatusCompleted || status == StatusSkipped {
 		for _, depID := range node.Dependents {
 			if dep, ok := d.Nodes[depID]; ok {
-				if d.areDependenciesSatisfiedLocked(dep) {
+				if dep.GetStatus() == StatusPending && d.areDependenciesSatisfiedLocked(dep) {
 					dep.SetStatus(StatusReady)
 				}
 			}
@@ -131,6 +135,47 @@ func (d *DAG) UpdateNodeStatus(nodeID string, status NodeStatus) error {
 	return nil
 }
 
+// SkipNode marks a node skipped because its `when` condition is false.
+// All of its step outputs are set to null. Skipping the gate node of a
+// subworkflow step also skips the steps inlined from the subworkflow.
+func (d *DAG) SkipNode(nodeID string) error {
+	node := d.GetNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("node not found: %s", nodeID)
+	}
+
+	outputs := make(map[string]interface{})
+	if node.Step != nil {
+		for _, id := range node.Step.OutputIDs() {
+			outputs[id] = nil
+		}
+	}
+	node.SetOutputs(outputs)
+
+	if err := d.UpdateNodeStatus(nodeID, StatusSkipped); err != nil {
+		return err
+	}
+	if !node.RunsSubworkflow() {
+		return nil
+	}
+
+	d.mu.RLock()
+	var inner []string
+	for id, n := range d.Nodes {
+		if strings.HasPrefix(n.StepID, node.StepID+SubworkflowSeparator) {
+			inner = append(inner, id)
+		}
+	}
+	d.mu.RUnlock()
+
+	for _, id := range inner {
+		if err := d.SkipNode(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // areDependenciesSatisfiedLocked checks if all dependencies are completed.
 // Must be called with d.mu held.
 func (d *DAG) areDependenciesSatisfiedLocked(node *Node) bool {
@@ -0,0 +1,121 @@
+package dag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
+)
+
+// ExpressionToolRunner evaluates ExpressionTool nodes in the scheduler
+// process instead of submitting them as BV-BRC tasks.
+type ExpressionToolRunner struct {
+	evaluator sandbox.Evaluator
+	timeout   time.Duration
+}
+
+// NewExpressionToolRunner creates a runner backed by a sandbox evaluator.
+// A zero timeout leaves the deadline to the evaluator and the caller's context.
+func NewExpressionToolRunner(evaluator sandbox.Evaluator, timeout time.Duration) *ExpressionToolRunner {
+	return &ExpressionToolRunner{
+		evaluator: evaluator,
+		timeout:   timeout,
+	}
+}
+
+// IsExpressionTool returns true if the node runs an ExpressionTool.
+func (n *Node) IsExpressionTool() bool {
+	return n.Tool != nil && n.Tool.Class == cwl.ClassExpressionTool
+}
+
+// Run evaluates the node's expression with its inputs bound and returns
+// the tool outputs. Outputs declared by the tool but missing from the
+// expression result are set to null.
+func (r *ExpressionToolRunner) Run(ctx context.Context, node *Node) (map[string]interface{}, error) {
+	if !node.IsExpressionTool() {
+		return nil, fmt.Errorf("node %s is not an ExpressionTool", node.ID)
+	}
+
+	tool := node.Tool
+	script, err := expressionToolScript(tool)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", node.ID, err)
+	}
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	// runtime reflects the tool's ResourceRequirement, as for a
+	// CommandLineTool, though there is no working directory
+	inputs := bindToolInputs(tool, node.Inputs)
+	res, err := tool.ResolveResources(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve resources for node %s: %w", node.ID, err)
+	}
+
+	result, err := r.evaluator.Evaluate(ctx, sandbox.Request{
+		Expression:    script,
+		Inputs:        inputs,
+		Runtime:       res.RuntimeContext(cwl.DefaultOutdir, cwl.DefaultTmpdir),
+		ExpressionLib: tool.ExpressionLib(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate expression for node %s: %w", node.ID, err)
+	}
+
+	resultMap, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expression for node %s returned %T, expected an object", node.ID, result)
+	}
+
+	outputs := make(map[string]interface{}, len(tool.Outputs))
+	for _, out := range tool.Outputs {
+		outputs[out.ID] = resultMap[out.ID]
+	}
+
+	return outputs, nil
+}
+
+// expressionToolScript converts an ExpressionTool expression into a
+// JavaScript program whose completion value is the expression result.
+func expressionToolScript(tool *cwl.Document) (string, error) {
+	expr := strings.TrimSpace(tool.Expression)
+
+	var body string
+	switch {
+	case strings.HasPrefix(expr, "${") && strings.HasSuffix(expr, "}"):
+		body = fmt.Sprintf("(function() { %s })()", expr[2:len(expr)-1])
+	case strings.HasPrefix(expr, "$(") && strings.HasSuffix(expr, ")"):
+		body = fmt.Sprintf("(%s)", expr[2:len(expr)-1])
+	case expr == "":
+		return "", fmt.Errorf("ExpressionTool has no expression")
+	default:
+		return "", fmt.Errorf("ExpressionTool expression must be $(...) or ${...}")
+	}
+
+	return body, nil
+}
+
+// bindToolInputs merges step inputs with the tool's input defaults.
+func bindToolInputs(tool *cwl.Document, inputs map[string]interface{}) map[string]interface{} {
+	bound := make(map[string]interface{}, len(tool.Inputs))
+	for _, in := range tool.Inputs {
+		if in.Default != nil {
+			bound[in.ID] = in.Default
+		} else {
+			bound[in.ID] = nil
+		}
+	}
+	for k, v := range inputs {
+		if v != nil || bound[k] == nil {
+			bound[k] = v
+		}
+	}
+	return bound
+}
@@ -0,0 +1,151 @@
+package dag
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl/sandbox"
+)
+
+func newExpressionToolNode(expression string, inputs map[string]interface{}) *Node {
+	return &Node{
+		ID:     "pick",
+		StepID: "pick",
+		Status: StatusReady,
+		Inputs: inputs,
+		Tool: &cwl.Document{
+			CWLVersion: "v1.2",
+			Class:      cwl.ClassExpressionTool,
+			Inputs: []cwl.Input{
+				{ID: "files", Type: "File[]"},
+				{ID: "suffix", Type: "string", Default: ".fa"},
+			},
+			Outputs: []cwl.Output{
+				{ID: "best", Type: "File"},
+				{ID: "count", Type: "int"},
+			},
+			Requirements: []cwl.Requirement{{Class: "InlineJavascriptRequirement"}},
+			Expression:   expression,
+		},
+	}
+}
+
+func TestExpressionToolRunner_Run(t *testing.T) {
+	runner := NewExpressionToolRunner(sandbox.NewInProcessEvaluator(), time.Second)
+
+	node := newExpressionToolNode(
+		`${ return {"best": inputs.files[inputs.files.length - 1], "count": inputs.files.length}; }`,
+		map[string]interface{}{
+			"files": []interface{}{
+				map[string]interface{}{"class": "File", "path": "/data/a.fa"},
+				map[string]interface{}{"class": "File", "path": "/data/b.fa"},
+			},
+		},
+	)
+
+	outputs, err := runner.Run(context.Background(), node)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	best, ok := outputs["best"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected best to be a File object, got %T", outputs["best"])
+	}
+	if best["path"] != "/data/b.fa" {
+		t.Errorf("Expected best path '/data/b.fa', got %v", best["path"])
+	}
+	if count, ok := outputs["count"].(int64); !ok || count != 2 {
+		t.Errorf("Expected count 2, got %v (%T)", outputs["count"], outputs["count"])
+	}
+}
+
+func TestExpressionToolRunner_Runtime(t *testing.T) {
+	runner := NewExpressionToolRunner(sandbox.NewInProcessEvaluator(), time.Second)
+
+	node := newExpressionToolNode(`$({"count": runtime.cores, "best": runtime.ram})`, map[string]interface{}{
+		"files": []interface{}{"a", "b", "c"},
+	})
+	node.Tool.Requirements = append(node.Tool.Requirements, cwl.Requirement{
+		Class:    "ResourceRequirement",
+		CoresMin: "$(inputs.files.length)",
+	})
+
+	outputs, err := runner.Run(context.Background(), node)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if cores, ok := outputs["count"].(int64); !ok || cores != 3 {
+		t.Errorf("Expected runtime.cores 3 from the ResourceRequirement, got %v (%T)", outputs["count"], outputs["count"])
+	}
+	if ram, ok := outputs["best"].(int64); !ok || int(ram) != cwl.DefaultResources().RAM {
+		t.Errorf("Expected default runtime.ram %d, got %v (%T)", cwl.DefaultResources().RAM, outputs["best"], outputs["best"])
+	}
+}
+
+func TestExpressionToolRunner_DefaultsAndMissingOutputs(t *testing.T) {
+	runner := NewExpressionToolRunner(sandbox.NewInProcessEvaluator(), time.Second)
+
+	node := newExpressionToolNode(`$({"suffix": inputs.suffix})`, nil)
+	node.Tool.Outputs = append(node.Tool.Outputs, cwl.Output{ID: "suffix", Type: "string"})
+
+	outputs, err := runner.Run(context.Background(), node)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if outputs["suffix"] != ".fa" {
+		t.Errorf("Expected tool default '.fa', got %v", outputs["suffix"])
+	}
+	if v, ok := outputs["best"]; !ok || v != nil {
+		t.Errorf("Expected undeclared result output 'best' to be null, got %v", v)
+	}
+}
+
+func TestExpressionToolRunner_Errors(t *testing.T) {
+	runner := NewExpressionToolRunner(sandbox.NewInProcessEvaluator(), 50*time.Millisecond)
+
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{"non-object result", `$(42)`},
+		{"not an expression", `inputs.files`},
+		{"timeout", `${ while (true) {} }`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newExpressionToolNode(tc.expression, map[string]interface{}{})
+			if _, err := runner.Run(context.Background(), node); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestScheduler_RunsExpressionToolInProcess(t *testing.T) {
+	d := NewDAG("test", "wf")
+	node := newExpressionToolNode(`$({"count": inputs.files.length})`, map[string]interface{}{
+		"files": []interface{}{"a", "b", "c"},
+	})
+	d.AddNode(node)
+
+	// A nil executor would panic if the scheduler tried to submit the node
+	s := NewScheduler(d, nil, 0)
+	s.SetExpressionToolRunner(NewExpressionToolRunner(sandbox.NewInProcessEvaluator(), time.Second))
+	s.ctx = context.Background()
+
+	if err := s.scheduleReadyNodes(); err != nil {
+		t.Fatalf("scheduleReadyNodes failed: %v", err)
+	}
+
+	if node.GetStatus() != StatusCompleted {
+		t.Fatalf("Expected node completed, got %s (error: %s)", node.GetStatus(), node.Error)
+	}
+	if count, ok := node.Outputs["count"].(int64); !ok || count != 3 {
+		t.Errorf("Expected count 3, got %v", node.Outputs["count"])
+	}
+}
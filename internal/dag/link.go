@@ -0,0 +1,119 @@
+package dag
+
+import (
+	"fmt"
+)
+
+// CWL linkMerge methods.
+const (
+	LinkMergeNested    = "merge_nested"
+	LinkMergeFlattened = "merge_flattened"
+)
+
+// CWL pickValue methods.
+const (
+	PickFirstNonNull   = "first_non_null"
+	PickTheOnlyNonNull = "the_only_non_null"
+	PickAllNonNull     = "all_non_null"
+)
+
+// resolveLink resolves the source of a step input or workflow output and
+// applies linkMerge and then pickValue. A single source is merged only when
+// linkMerge is set explicitly, as in CWL v1.2.
+func resolveLink(source interface{}, linkMerge, pickValue string, resolve func(string) (interface{}, error)) (interface{}, error) {
+	var value interface{}
+
+	switch src := source.(type) {
+	case string:
+		v, err := resolve(src)
+		if err != nil {
+			return nil, err
+		}
+		value = v
+		if linkMerge != "" {
+			if value, err = mergeLinks([]interface{}{v}, linkMerge); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		values := make([]interface{}, 0, len(src))
+		for _, item := range src {
+			s, ok := item.(string)
+			if !ok {
+				continue
+			}
+			v, err := resolve(s)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		merged, err := mergeLinks(values, linkMerge)
+		if err != nil {
+			return nil, err
+		}
+		value = merged
+	default:
+		return nil, fmt.Errorf("unsupported source type: %T", source)
+	}
+
+	if pickValue == "" {
+		return value, nil
+	}
+	return pickLinkValue(value, pickValue)
+}
+
+// mergeLinks combines the values of a multi-source link.
+func mergeLinks(values []interface{}, linkMerge string) (interface{}, error) {
+	switch linkMerge {
+	case "", LinkMergeNested:
+		return values, nil
+	case LinkMergeFlattened:
+		merged := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			if list, ok := v.([]interface{}); ok {
+				merged = append(merged, list...)
+			} else {
+				merged = append(merged, v)
+			}
+		}
+		return merged, nil
+	default:
+		return nil, fmt.Errorf("unsupported linkMerge method: %s", linkMerge)
+	}
+}
+
+// pickLinkValue applies a pickValue method to a merged link value.
+func pickLinkValue(value interface{}, pickValue string) (interface{}, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	var nonNull []interface{}
+	for _, v := range values {
+		if v != nil {
+			nonNull = append(nonNull, v)
+		}
+	}
+
+	switch pickValue {
+	case PickFirstNonNull:
+		if len(nonNull) == 0 {
+			return nil, fmt.Errorf("%s: all source values are null", pickValue)
+		}
+		return nonNull[0], nil
+	case PickTheOnlyNonNull:
+		if len(nonNull) != 1 {
+			return nil, fmt.Errorf("%s: expected exactly one non-null value, got %d", pickValue, len(nonNull))
+		}
+		return nonNull[0], nil
+	case PickAllNonNull:
+		if nonNull == nil {
+			nonNull = []interface{}{}
+		}
+		return nonNull, nil
+	default:
+		return nil, fmt.Errorf("unsupported pickValue method: %s", pickValue)
+	}
+}
@@ -0,0 +1,175 @@
+package dag
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+func TestMergeLinks(t *testing.T) {
+	values := []interface{}{[]interface{}{"a", "b"}, "c", nil}
+
+	tests := []struct {
+		linkMerge string
+		expected  []interface{}
+	}{
+		{"", []interface{}{[]interface{}{"a", "b"}, "c", nil}},
+		{LinkMergeNested, []interface{}{[]interface{}{"a", "b"}, "c", nil}},
+		{LinkMergeFlattened, []interface{}{"a", "b", "c", nil}},
+	}
+
+	for _, tc := range tests {
+		merged, err := mergeLinks(values, tc.linkMerge)
+		if err != nil {
+			t.Fatalf("mergeLinks(%q) failed: %v", tc.linkMerge, err)
+		}
+		if !reflect.DeepEqual(merged, tc.expected) {
+			t.Errorf("mergeLinks(%q) = %v, expected %v", tc.linkMerge, merged, tc.expected)
+		}
+	}
+
+	if _, err := mergeLinks(values, "merge_sideways"); err == nil {
+		t.Error("Expected error for unknown linkMerge method")
+	}
+}
+
+func TestPickLinkValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		pickValue string
+		expected  interface{}
+		wantErr   bool
+	}{
+		{"first non-null", []interface{}{nil, "a", "b"}, PickFirstNonNull, "a", false},
+		{"first non-null all null", []interface{}{nil, nil}, PickFirstNonNull, nil, true},
+		{"the only non-null", []interface{}{nil, "b"}, PickTheOnlyNonNull, "b", false},
+		{"the only non-null multiple", []interface{}{"a", "b"}, PickTheOnlyNonNull, nil, true},
+		{"the only non-null none", []interface{}{nil}, PickTheOnlyNonNull, nil, true},
+		{"all non-null", []interface{}{"a", nil, "c"}, PickAllNonNull, []interface{}{"a", "c"}, false},
+		{"all non-null empty", []interface{}{nil}, PickAllNonNull, []interface{}{}, false},
+		{"single value", "a", PickFirstNonNull, "a", false},
+		{"unknown method", []interface{}{"a"}, "pick_any", nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			picked, err := pickLinkValue(tc.value, tc.pickValue)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %v", picked)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pickLinkValue failed: %v", err)
+			}
+			if !reflect.DeepEqual(picked, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, picked)
+			}
+		})
+	}
+}
+
+func TestPrepareNodeInputs_MultipleSources(t *testing.T) {
+	dag := NewDAG("test", "wf")
+	dag.AddNode(&Node{
+		ID:      "branch_a",
+		StepID:  "branch_a",
+		Status:  StatusCompleted,
+		Outputs: map[string]interface{}{"report": nil},
+	})
+	dag.AddNode(&Node{
+		ID:      "branch_b",
+		StepID:  "branch_b",
+		Status:  StatusCompleted,
+		Outputs: map[string]interface{}{"report": "b.txt"},
+	})
+
+	node := &Node{
+		ID:     "merge",
+		StepID: "merge",
+		Status: StatusReady,
+		Step: &cwl.WorkflowStep{
+			ID: "merge",
+			In: []cwl.WorkflowStepInput{
+				{ID: "report", Source: []interface{}{"branch_a/report", "branch_b/report"}, PickValue: PickTheOnlyNonNull},
+				{ID: "all", Source: []interface{}{"extra", "branch_b/report"}, LinkMerge: LinkMergeFlattened},
+				{ID: "wrapped", Source: "branch_b/report", LinkMerge: LinkMergeNested},
+			},
+		},
+	}
+	dag.AddNode(node)
+
+	inputs, err := PrepareNodeInputs(dag, node, map[string]interface{}{
+		"extra": []interface{}{"x.txt", "y.txt"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to prepare inputs: %v", err)
+	}
+
+	if inputs["report"] != "b.txt" {
+		t.Errorf("Expected report 'b.txt', got %v", inputs["report"])
+	}
+	if expected := []interface{}{"x.txt", "y.txt", "b.txt"}; !reflect.DeepEqual(inputs["all"], expected) {
+		t.Errorf("Expected all %v, got %v", expected, inputs["all"])
+	}
+	if expected := []interface{}{"b.txt"}; !reflect.DeepEqual(inputs["wrapped"], expected) {
+		t.Errorf("Expected wrapped %v, got %v", expected, inputs["wrapped"])
+	}
+
+	// Both branches producing a value is an error for the_only_non_null
+	dag.GetNode("branch_a").Outputs["report"] = "a.txt"
+	if _, err := PrepareNodeInputs(dag, node, map[string]interface{}{"extra": []interface{}{}}); err == nil {
+		t.Error("Expected error when the_only_non_null sees multiple values")
+	}
+}
+
+func TestBuilder_CollectOutputs(t *testing.T) {
+	doc := &cwl.Document{
+		CWLVersion: "v1.2",
+		Class:      cwl.ClassWorkflow,
+		Inputs:     []cwl.Input{{ID: "message", Type: "string"}},
+		Outputs: []cwl.Output{
+			{ID: "out", Type: "File", OutputSource: "echo_step/output"},
+			{ID: "picked", Type: "File", OutputSource: []interface{}{"echo_step/missing", "echo_step/output"}, PickValue: PickFirstNonNull},
+			{ID: "echoed", Type: "string", OutputSource: "message"},
+		},
+		Steps: []cwl.WorkflowStep{
+			{
+				ID:  "echo_step",
+				Run: echoTool("msg", "output"),
+				In:  []cwl.WorkflowStepInput{{ID: "msg", Source: "message"}},
+				Out: []interface{}{"output", "missing"},
+			},
+		},
+	}
+
+	builder := NewBuilder(doc, map[string]interface{}{"message": "hi"})
+	if _, err := builder.CollectOutputs(NewDAG("x", "wf")); err == nil {
+		t.Error("Expected error collecting outputs before Build")
+	}
+
+	d, err := builder.Build("outputs-test")
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+	node := d.GetNode("echo_step")
+	node.SetOutputs(map[string]interface{}{"output": "out.txt", "missing": nil})
+	node.SetStatus(StatusCompleted)
+
+	outputs, err := builder.CollectOutputs(d)
+	if err != nil {
+		t.Fatalf("CollectOutputs failed: %v", err)
+	}
+	if outputs["out"] != "out.txt" {
+		t.Errorf("Expected out 'out.txt', got %v", outputs["out"])
+	}
+	if outputs["picked"] != "out.txt" {
+		t.Errorf("Expected picked 'out.txt', got %v", outputs["picked"])
+	}
+	if outputs["echoed"] != "hi" {
+		t.Errorf("Expected echoed 'hi', got %v", outputs["echoed"])
+	}
+}
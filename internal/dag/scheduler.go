@@ -15,6 +15,9 @@ type Scheduler struct {
 	pollInterval time.Duration
 	mu          sync.Mutex
 	running     map[string]bool
+	exprRunner  *ExpressionToolRunner
+	maxRetries  int
+	retryDelay  time.Duration
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
@@ -27,6 +30,13 @@ type Executor interface {
 	Cancel(ctx context.Context, taskID string) error
 }
 
+// FailureClassifier is implemented by executors that can tell a temporary
+// task failure, such as an exit code in the tool's temporaryFailCodes,
+// from a permanent one. The scheduler retries temporary failures.
+type FailureClassifier interface {
+	IsTemporaryFailure(ctx context.Context, taskID string) (bool, error)
+}
+
 // NewScheduler creates a new scheduler.
 func NewScheduler(dag *DAG, executor Executor, maxParallel int) *Scheduler {
 	return &Scheduler{
@@ -43,6 +53,20 @@ func (s *Scheduler) SetPollInterval(interval time.Duration) {
 	s.pollInterval = interval
 }
 
+// SetRetryPolicy sets how many times a node that fails temporarily is
+// retried, and how long to wait before each retry. Permanent failures are
+// never retried.
+func (s *Scheduler) SetRetryPolicy(maxRetries int, delay time.Duration) {
+	s.maxRetries = maxRetries
+	s.retryDelay = delay
+}
+
+// SetExpressionToolRunner sets the runner used to evaluate ExpressionTool
+// nodes in-process. Without one, ExpressionTool nodes go to the executor.
+func (s *Scheduler) SetExpressionToolRunner(runner *ExpressionToolRunner) {
+	s.exprRunner = runner
+}
+
 // Run executes the DAG until completion or failure.
 func (s *Scheduler) Run(ctx context.Context) error {
 	s.ctx, s.cancel = context.WithCancel(ctx)
@@ -91,8 +115,42 @@ func (s *Scheduler) scheduleReadyNodes() error {
 			break
 		}
 
-		// Skip if already running
-		if s.running[node.ID] {
+		// Skip if already running or waiting to be retried
+		if s.running[node.ID] || time.Now().Before(node.RetryAt) {
+			continue
+		}
+
+		// Skip nodes whose when condition is false
+		run, err := EvaluateWhen(node, node.Inputs)
+		if err != nil {
+			node.SetError(err.Error())
+			if err := s.dag.UpdateNodeStatus(node.ID, StatusFailed); err != nil {
+				return err
+			}
+			continue
+		}
+		if !run {
+			if err := s.dag.SkipNode(node.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Subworkflow gate steps complete in place; the steps inlined from
+		// the subworkflow do its work
+		if node.RunsSubworkflow() {
+			node.SetOutputs(SubworkflowOutputs(node, node.Inputs))
+			if err := s.dag.UpdateNodeStatus(node.ID, StatusCompleted); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// ExpressionTools complete in-process without an executor task
+		if node.IsExpressionTool() && s.exprRunner != nil {
+			if err := s.runExpressionTool(node); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -119,6 +177,18 @@ func (s *Scheduler) executeNode(node *Node) error {
 	return s.executor.Execute(s.ctx, node)
 }
 
+// runExpressionTool evaluates an ExpressionTool node and records its result.
+func (s *Scheduler) runExpressionTool(node *Node) error {
+	outputs, err := s.exprRunner.Run(s.ctx, node)
+	if err != nil {
+		node.SetError(err.Error())
+		return s.dag.UpdateNodeStatus(node.ID, StatusFailed)
+	}
+
+	node.SetOutputs(outputs)
+	return s.dag.UpdateNodeStatus(node.ID, StatusCompleted)
+}
+
 // updateRunningNodes checks status of running nodes.
 func (s *Scheduler) updateRunningNodes() error {
 	s.mu.Lock()
@@ -159,10 +229,19 @@ func (s *Scheduler) updateRunningNodes() error {
 			delete(s.running, nodeID)
 
 		case StatusFailed:
+			delete(s.running, nodeID)
+			if s.shouldRetry(node, taskID) {
+				node.Retries++
+				node.RetryAt = time.Now().Add(s.retryDelay)
+				node.SetTaskID("")
+				if err := s.dag.UpdateNodeStatus(nodeID, StatusReady); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := s.dag.UpdateNodeStatus(nodeID, StatusFailed); err != nil {
 				return err
 			}
-			delete(s.running, nodeID)
 
 		case StatusRunning:
 			// Still running, continue monitoring
@@ -172,6 +251,20 @@ func (s *Scheduler) updateRunningNodes() error {
 	return nil
 }
 
+// shouldRetry reports whether a failed node's task failed temporarily and
+// the node has retries left.
+func (s *Scheduler) shouldRetry(node *Node, taskID string) bool {
+	if node.Retries >= s.maxRetries {
+		return false
+	}
+	classifier, ok := s.executor.(FailureClassifier)
+	if !ok {
+		return false
+	}
+	temporary, err := classifier.IsTemporaryFailure(s.ctx, taskID)
+	return err == nil && temporary
+}
+
 // Cancel cancels the scheduler and all running tasks.
 func (s *Scheduler) Cancel() error {
 	if s.cancel != nil {
@@ -0,0 +1,113 @@
+package dag
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduler_RetriesTemporaryFailures(t *testing.T) {
+	d := NewDAG("test", "wf")
+	d.AddNode(&Node{ID: "flaky", StepID: "flaky", Status: StatusReady})
+
+	executor := &failingExecutor{temporary: true}
+	s := NewScheduler(d, executor, 0)
+	s.ctx = context.Background()
+	s.SetRetryPolicy(2, 0)
+
+	// The first run and two retries fail, then the node fails for good
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err := s.scheduleReadyNodes(); err != nil {
+			t.Fatalf("scheduleReadyNodes failed: %v", err)
+		}
+		if err := s.updateRunningNodes(); err != nil {
+			t.Fatalf("updateRunningNodes failed: %v", err)
+		}
+		if executor.executed != attempt {
+			t.Fatalf("Expected %d executions, got %d", attempt, executor.executed)
+		}
+	}
+
+	node := d.GetNode("flaky")
+	if node.GetStatus() != StatusFailed {
+		t.Errorf("Expected node failed after retries, got %s", node.GetStatus())
+	}
+	if node.Retries != 2 {
+		t.Errorf("Expected 2 retries, got %d", node.Retries)
+	}
+}
+
+func TestScheduler_DoesNotRetryPermanentFailures(t *testing.T) {
+	d := NewDAG("test", "wf")
+	d.AddNode(&Node{ID: "broken", StepID: "broken", Status: StatusReady})
+
+	executor := &failingExecutor{}
+	s := NewScheduler(d, executor, 0)
+	s.ctx = context.Background()
+	s.SetRetryPolicy(2, 0)
+
+	if err := s.scheduleReadyNodes(); err != nil {
+		t.Fatalf("scheduleReadyNodes failed: %v", err)
+	}
+	if err := s.updateRunningNodes(); err != nil {
+		t.Fatalf("updateRunningNodes failed: %v", err)
+	}
+
+	if status := d.GetNode("broken").GetStatus(); status != StatusFailed {
+		t.Errorf("Expected node failed, got %s", status)
+	}
+}
+
+func TestScheduler_WaitsForRetryDelay(t *testing.T) {
+	d := NewDAG("test", "wf")
+	d.AddNode(&Node{ID: "flaky", StepID: "flaky", Status: StatusReady})
+
+	executor := &failingExecutor{temporary: true}
+	s := NewScheduler(d, executor, 0)
+	s.ctx = context.Background()
+	s.SetRetryPolicy(1, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if err := s.scheduleReadyNodes(); err != nil {
+			t.Fatalf("scheduleReadyNodes failed: %v", err)
+		}
+		if err := s.updateRunningNodes(); err != nil {
+			t.Fatalf("updateRunningNodes failed: %v", err)
+		}
+	}
+
+	if executor.executed != 1 {
+		t.Errorf("Expected retry to wait for its delay, got %d executions", executor.executed)
+	}
+	if status := d.GetNode("flaky").GetStatus(); status != StatusReady {
+		t.Errorf("Expected node ready for retry, got %s", status)
+	}
+}
+
+// failingExecutor fails every task, temporarily or permanently.
+type failingExecutor struct {
+	temporary bool
+	executed  int
+}
+
+func (e *failingExecutor) Execute(ctx context.Context, node *Node) error {
+	e.executed++
+	node.SetTaskID(node.ID)
+	return nil
+}
+
+func (e *failingExecutor) GetStatus(ctx context.Context, taskID string) (NodeStatus, error) {
+	return StatusFailed, nil
+}
+
+func (e *failingExecutor) GetOutputs(ctx context.Context, taskID string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (e *failingExecutor) Cancel(ctx context.Context, taskID string) error {
+	return nil
+}
+
+func (e *failingExecutor) IsTemporaryFailure(ctx context.Context, taskID string) (bool, error) {
+	return e.temporary, nil
+}
@@ -0,0 +1,506 @@
+package dag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+// SubworkflowSeparator joins a subworkflow step ID and the IDs of its inner
+// steps, so step "assemble" running a Workflow with step "spades" becomes
+// the DAG step "assemble.spades".
+const SubworkflowSeparator = "."
+
+// sourceBinding describes where a value comes from after subworkflow
+// expansion: one or more parent-level sources plus the merge rules and
+// default that apply to them.
+type sourceBinding struct {
+	sources   []string
+	list      bool // sources were given as a list, even of one
+	linkMerge string
+	pickValue string
+	def       interface{}
+	constant  bool // bound to def, a scatter element, rather than to sources
+}
+
+// expandSubworkflows returns a copy of wf in which every step that runs a
+// nested Workflow is replaced by that workflow's steps, with namespaced IDs
+// and sources rewired to the parent. It also returns the resolved tool for
+// every remaining step, keyed by its (namespaced) step ID.
+func (b *Builder) expandSubworkflows(wf *cwl.Document) (*cwl.Document, map[string]*cwl.Document, error) {
+	return b.expandWorkflow(wf, b.resolveSourceString)
+}
+
+// expandWorkflow expands the subworkflow steps of wf. resolve returns the
+// value of one of wf's inputs when it is known while building, which is
+// what scattered subworkflow steps are expanded over.
+func (b *Builder) expandWorkflow(wf *cwl.Document, resolve func(string) (interface{}, error)) (*cwl.Document, map[string]*cwl.Document, error) {
+	analyzer := cwl.NewWorkflowAnalyzer(wf)
+	flat := *wf
+	flat.Steps = nil
+	flat.Outputs = append([]cwl.Output(nil), wf.Outputs...)
+	tools := make(map[string]*cwl.Document)
+
+	// Outputs of expanded subworkflow steps, keyed by "step/output"
+	subOutputs := make(map[string]*sourceBinding)
+
+	for i := range wf.Steps {
+		step := wf.Steps[i]
+
+		tool, err := b.resolveTool(analyzer, &step)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve tool for step %s: %w", step.ID, err)
+		}
+
+		if tool.Class != cwl.ClassWorkflow {
+			// Copy inputs so rewiring leaves wf untouched
+			step.In = append([]cwl.WorkflowStepInput(nil), step.In...)
+			flat.Steps = append(flat.Steps, step)
+			tools[step.ID] = tool
+			continue
+		}
+
+		innerSteps, innerTools, outputs, err := b.expandStep(&step, tool, resolve)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to expand subworkflow step %s: %w", step.ID, err)
+		}
+		flat.Steps = append(flat.Steps, innerSteps...)
+		for id, t := range innerTools {
+			tools[id] = t
+		}
+		for outID, binding := range outputs {
+			subOutputs[step.ID+"/"+outID] = binding
+		}
+	}
+
+	// Steps inherit the workflow's requirements
+	for i := range flat.Steps {
+		inheritRequirements(&flat.Steps[i], wf.Requirements)
+	}
+
+	if len(subOutputs) == 0 {
+		return &flat, tools, nil
+	}
+
+	// Rewire references to subworkflow outputs in the remaining steps and
+	// in the workflow outputs
+	resolveBinding := func(src string) *sourceBinding {
+		return subOutputs[strings.TrimPrefix(src, "#")]
+	}
+	for i := range flat.Steps {
+		for j := range flat.Steps[i].In {
+			in := &flat.Steps[i].In[j]
+			if err := rewireStepInput(in, resolveBinding); err != nil {
+				return nil, nil, fmt.Errorf("step %s input %s: %w", flat.Steps[i].ID, in.ID, err)
+			}
+		}
+	}
+	for i := range flat.Outputs {
+		out := &flat.Outputs[i]
+		source, linkMerge, pickValue, _, err := rewireSources(out.OutputSource, out.LinkMerge, out.PickValue, nil, resolveBinding)
+		if err != nil {
+			return nil, nil, fmt.Errorf("workflow output %s: %w", out.ID, err)
+		}
+		out.OutputSource, out.LinkMerge, out.PickValue = source, linkMerge, pickValue
+	}
+
+	return &flat, tools, nil
+}
+
+// expandStep expands a single step whose run is a Workflow. It returns the
+// inner steps with namespaced IDs, their tools, and the subworkflow outputs
+// expressed as parent-level source bindings. A scattered step is expanded
+// once per scatter element, so its scattered inputs must be known while
+// building, as for any scattered step; its outputs gather the elements'
+// outputs in scatter order.
+func (b *Builder) expandStep(step *cwl.WorkflowStep, sub *cwl.Document, resolve func(string) (interface{}, error)) ([]cwl.WorkflowStep, map[string]*cwl.Document, map[string]*sourceBinding, error) {
+	values := knownStepInputs(step, resolve)
+
+	config, err := cwl.ParseScatterConfig(step)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if config == nil {
+		return b.expandInstance(step, sub, step.ID, nil, values)
+	}
+
+	elements, err := cwl.NewScatterExpander(*config, values).Expand()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to expand scatter: %w", err)
+	}
+
+	var steps []cwl.WorkflowStep
+	tools := make(map[string]*cwl.Document)
+	outputs := make(map[string]*sourceBinding, len(sub.Outputs))
+	for _, out := range sub.Outputs {
+		outputs[out.ID] = &sourceBinding{list: true, linkMerge: LinkMergeNested}
+	}
+
+	for _, element := range elements {
+		scattered := make(map[string]interface{}, len(config.InputIDs))
+		for _, id := range config.InputIDs {
+			scattered[id] = element.Values[id]
+		}
+
+		instanceID := GenerateNodeID(step.ID, element.Index)
+		instSteps, instTools, instOutputs, err := b.expandInstance(step, sub, instanceID, scattered, element.Values)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("scatter element %s: %w", cwl.IndexToString(element.Index), err)
+		}
+		steps = append(steps, instSteps...)
+		for id, tool := range instTools {
+			tools[id] = tool
+		}
+		for id, binding := range instOutputs {
+			if len(binding.sources) != 1 || binding.list || binding.linkMerge != "" || binding.pickValue != "" {
+				return nil, nil, nil, fmt.Errorf("subworkflow output %s must come from a single step output to be gathered", id)
+			}
+			outputs[id].sources = append(outputs[id].sources, binding.sources[0])
+		}
+	}
+
+	return steps, tools, outputs, nil
+}
+
+// expandInstance inlines one run of sub for step under instanceID: the
+// step ID, or a scatter element's node ID. scattered holds the element's
+// values of the scattered inputs, and values the step input values known
+// while building.
+//
+// A step with a when condition or valueFrom also gets a step of its own
+// under instanceID that runs sub, so the scheduler evaluates them: its
+// outputs are the subworkflow's inputs, which the inner steps read, and
+// skipping it skips them.
+func (b *Builder) expandInstance(step *cwl.WorkflowStep, sub *cwl.Document, instanceID string, scattered, values map[string]interface{}) ([]cwl.WorkflowStep, map[string]*cwl.Document, map[string]*sourceBinding, error) {
+	gated := step.When != ""
+	for _, in := range step.In {
+		gated = gated || in.ValueFrom != ""
+	}
+
+	// Flatten nested subworkflows first, with what is known of their inputs
+	inner, innerTools, err := b.expandWorkflow(sub, func(src string) (interface{}, error) {
+		src = strings.TrimPrefix(src, "#")
+		for _, in := range step.In {
+			if in.ID != src {
+				continue
+			}
+			value, ok := values[src]
+			if !ok || in.ValueFrom != "" {
+				return nil, fmt.Errorf("subworkflow input %s is not known until the workflow runs", src)
+			}
+			if value != nil {
+				return value, nil
+			}
+		}
+		for _, input := range sub.Inputs {
+			if input.ID == src {
+				return input.Default, nil
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Bind each subworkflow input to the parent step's input sources, or
+	// to the gate step's outputs
+	inputs := make(map[string]*sourceBinding, len(inner.Inputs))
+	for _, input := range inner.Inputs {
+		inputs[input.ID] = &sourceBinding{def: input.Default}
+	}
+	var gate *cwl.WorkflowStep
+	if gated {
+		gate = &cwl.WorkflowStep{
+			ID:           instanceID,
+			When:         step.When,
+			Requirements: step.Requirements,
+			Hints:        step.Hints,
+		}
+	}
+	for _, in := range step.In {
+		subDefault := inputs[in.ID]
+		binding := &sourceBinding{
+			linkMerge: in.LinkMerge,
+			pickValue: in.PickValue,
+			def:       in.Default,
+		}
+		value, isScattered := scattered[in.ID]
+
+		switch {
+		case gate != nil:
+			gateIn := in
+			if isScattered {
+				gateIn = cwl.WorkflowStepInput{ID: in.ID, Default: value, ValueFrom: in.ValueFrom}
+			}
+			gate.In = append(gate.In, gateIn)
+			gate.Out = append(gate.Out, in.ID)
+			binding = &sourceBinding{sources: []string{instanceID + "/" + in.ID}}
+		case isScattered:
+			binding = &sourceBinding{def: value, constant: true}
+		default:
+			_, isList := in.Source.([]interface{})
+			binding.sources = sourceList(in.Source)
+			binding.list = isList
+		}
+
+		if binding.def == nil && subDefault != nil {
+			binding.def = subDefault.def
+		}
+		inputs[in.ID] = binding
+	}
+
+	prefix := instanceID + SubworkflowSeparator
+	resolve := func(src string) *sourceBinding {
+		src = strings.TrimPrefix(src, "#")
+		if strings.Contains(src, "/") {
+			return &sourceBinding{sources: []string{prefix + src}}
+		}
+		if binding, ok := inputs[src]; ok {
+			return binding
+		}
+		// Undeclared subworkflow input: always null
+		return &sourceBinding{}
+	}
+
+	var steps []cwl.WorkflowStep
+	tools := make(map[string]*cwl.Document, len(innerTools)+1)
+	if gate != nil {
+		steps = append(steps, *gate)
+		tools[gate.ID] = sub
+	}
+	for _, innerStep := range inner.Steps {
+		s := innerStep
+		s.ID = prefix + innerStep.ID
+		s.In = append([]cwl.WorkflowStepInput(nil), innerStep.In...)
+		inheritRequirements(&s, step.Requirements)
+		for j := range s.In {
+			if err := rewireStepInput(&s.In[j], resolve); err != nil {
+				return nil, nil, nil, fmt.Errorf("step %s input %s: %w", s.ID, s.In[j].ID, err)
+			}
+		}
+		steps = append(steps, s)
+	}
+
+	for id, tool := range innerTools {
+		tools[prefix+id] = tool
+	}
+
+	outputs := make(map[string]*sourceBinding, len(inner.Outputs))
+	for _, out := range inner.Outputs {
+		source, linkMerge, pickValue, def, err := rewireSources(out.OutputSource, out.LinkMerge, out.PickValue, nil, resolve)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("subworkflow output %s: %w", out.ID, err)
+		}
+		_, isList := source.([]interface{})
+		outputs[out.ID] = &sourceBinding{
+			sources:   sourceList(source),
+			list:      isList,
+			linkMerge: linkMerge,
+			pickValue: pickValue,
+			def:       def,
+		}
+	}
+
+	return steps, tools, outputs, nil
+}
+
+// knownStepInputs resolves the step inputs whose values are known while
+// building: those bound to workflow inputs and defaults. valueFrom is left
+// for the scheduler.
+func knownStepInputs(step *cwl.WorkflowStep, resolve func(string) (interface{}, error)) map[string]interface{} {
+	values := make(map[string]interface{}, len(step.In))
+	for _, in := range step.In {
+		var value interface{}
+		if in.Source != nil {
+			resolved, err := resolveLink(in.Source, in.LinkMerge, in.PickValue, resolve)
+			if err != nil {
+				continue
+			}
+			value = resolved
+		}
+		if value == nil {
+			value = in.Default
+		}
+		values[in.ID] = value
+	}
+	return values
+}
+
+// RunsSubworkflow reports whether the node is the gate step of a
+// subworkflow step with a when condition or valueFrom. Its inputs are
+// resolved like any step's and become its outputs; the steps inlined from
+// the subworkflow read them.
+func (n *Node) RunsSubworkflow() bool {
+	return n.Tool != nil && n.Tool.Class == cwl.ClassWorkflow
+}
+
+// SubworkflowOutputs returns the outputs of a gate node from its resolved
+// inputs.
+func SubworkflowOutputs(node *Node, inputs map[string]interface{}) map[string]interface{} {
+	outputs := make(map[string]interface{})
+	for _, id := range node.Step.OutputIDs() {
+		outputs[id] = inputs[id]
+	}
+	return outputs
+}
+
+// resolveTool resolves and parses the tool a step runs.
+func (b *Builder) resolveTool(analyzer *cwl.WorkflowAnalyzer, step *cwl.WorkflowStep) (*cwl.Document, error) {
+	tool, toolPath, err := analyzer.ResolveStepTool(step)
+	if err != nil {
+		return nil, err
+	}
+
+	// If tool is a file path, parse it
+	if tool == nil && toolPath != "" {
+		tool, err = b.parser.ParseFile(toolPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tool %s: %w", toolPath, err)
+		}
+	}
+	if tool == nil {
+		return nil, fmt.Errorf("step has no run")
+	}
+
+	return tool, nil
+}
+
+// inheritRequirements adds to step each requirement whose class it does not
+// already declare, so the nearest definition wins.
+func inheritRequirements(step *cwl.WorkflowStep, reqs []cwl.Requirement) {
+	var inherited []cwl.Requirement
+	for _, req := range reqs {
+		declared := false
+		for _, own := range step.Requirements {
+			if own.Class == req.Class {
+				declared = true
+				break
+			}
+		}
+		if !declared {
+			inherited = append(inherited, req)
+		}
+	}
+	if len(inherited) > 0 {
+		step.Requirements = append(append([]cwl.Requirement(nil), step.Requirements...), inherited...)
+	}
+}
+
+// inheritToolRequirements returns tool with each requirement whose class it
+// does not declare added. The tool is copied, since tool documents may be
+// shared between steps.
+func inheritToolRequirements(tool *cwl.Document, reqs []cwl.Requirement) *cwl.Document {
+	declared := make(map[string]bool, len(tool.Requirements))
+	for _, own := range tool.Requirements {
+		declared[own.Class] = true
+	}
+	var inherited []cwl.Requirement
+	for _, req := range reqs {
+		if !declared[req.Class] {
+			inherited = append(inherited, req)
+		}
+	}
+	if len(inherited) == 0 {
+		return tool
+	}
+	copied := *tool
+	copied.Requirements = append(append([]cwl.Requirement(nil), tool.Requirements...), inherited...)
+	return &copied
+}
+
+// rewireStepInput rewrites a step input's sources in place.
+func rewireStepInput(in *cwl.WorkflowStepInput, resolve func(string) *sourceBinding) error {
+	source, linkMerge, pickValue, def, err := rewireSources(in.Source, in.LinkMerge, in.PickValue, in.Default, resolve)
+	if err != nil {
+		return err
+	}
+	in.Source, in.LinkMerge, in.PickValue, in.Default = source, linkMerge, pickValue, def
+	return nil
+}
+
+// rewireSources substitutes source references using resolve, which returns
+// nil for references that should be kept unchanged. The link's own merge
+// rules win; a single-source link inherits the rules of what it resolves to.
+func rewireSources(source interface{}, linkMerge, pickValue string, def interface{}, resolve func(string) *sourceBinding) (interface{}, string, string, interface{}, error) {
+	switch v := source.(type) {
+	case string:
+		binding := resolve(v)
+		if binding == nil {
+			return v, linkMerge, pickValue, def, nil
+		}
+		if def == nil || (binding.constant && binding.def != nil) {
+			def = binding.def
+		}
+		if binding.list || binding.linkMerge != "" || binding.pickValue != "" {
+			if linkMerge != "" || pickValue != "" {
+				return nil, "", "", nil, fmt.Errorf("cannot combine linkMerge/pickValue with multi-source %s", v)
+			}
+			return stringsToSource(binding.sources), binding.linkMerge, binding.pickValue, def, nil
+		}
+		if len(binding.sources) == 0 {
+			return nil, linkMerge, pickValue, def, nil
+		}
+		return binding.sources[0], linkMerge, pickValue, def, nil
+
+	case []interface{}:
+		var sources []interface{}
+		for _, item := range v {
+			src, ok := item.(string)
+			if !ok {
+				continue
+			}
+			binding := resolve(src)
+			if binding == nil {
+				sources = append(sources, src)
+				continue
+			}
+			if len(binding.sources) != 1 || binding.list || binding.linkMerge != "" || binding.pickValue != "" {
+				return nil, "", "", nil, fmt.Errorf("source %s must resolve to exactly one link inside a multi-source input", src)
+			}
+			sources = append(sources, binding.sources[0])
+		}
+		return sources, linkMerge, pickValue, def, nil
+
+	default:
+		return source, linkMerge, pickValue, def, nil
+	}
+}
+
+// sourceList normalizes a source field to a string slice.
+func sourceList(source interface{}) []string {
+	switch v := source.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var sources []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				sources = append(sources, s)
+			}
+		}
+		return sources
+	default:
+		return nil
+	}
+}
+
+// stringsToSource converts a string slice back to a source field.
+func stringsToSource(sources []string) interface{} {
+	list := make([]interface{}, len(sources))
+	for i, s := range sources {
+		list[i] = s
+	}
+	return list
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,372 @@
+package dag
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+func echoTool(input, output string) map[string]interface{} {
+	return map[string]interface{}{
+		"cwlVersion":  "v1.2",
+		"class":       "CommandLineTool",
+		"baseCommand": "echo",
+		"inputs":      []interface{}{map[string]interface{}{"id": input, "type": "File"}},
+		"outputs":     []interface{}{map[string]interface{}{"id": output, "type": "File"}},
+	}
+}
+
+func newSubworkflowDoc() *cwl.Document {
+	inner := map[string]interface{}{
+		"cwlVersion": "v1.2",
+		"class":      "Workflow",
+		"inputs": []interface{}{
+			map[string]interface{}{"id": "reads", "type": "File"},
+		},
+		"outputs": []interface{}{
+			map[string]interface{}{"id": "contigs", "type": "File", "outputSource": "polish/polished"},
+		},
+		"steps": []interface{}{
+			map[string]interface{}{
+				"id":  "spades",
+				"run": echoTool("reads", "contigs"),
+				"in":  []interface{}{map[string]interface{}{"id": "reads", "source": "reads"}},
+				"out": []interface{}{"contigs"},
+			},
+			map[string]interface{}{
+				"id":  "polish",
+				"run": echoTool("contigs", "polished"),
+				"in":  []interface{}{map[string]interface{}{"id": "contigs", "source": "spades/contigs"}},
+				"out": []interface{}{"polished"},
+			},
+		},
+	}
+
+	return &cwl.Document{
+		CWLVersion: "v1.2",
+		Class:      cwl.ClassWorkflow,
+		Inputs: []cwl.Input{
+			{ID: "raw_reads", Type: "File"},
+		},
+		Outputs: []cwl.Output{
+			{ID: "annotation", Type: "File", OutputSource: "annotate/annotation"},
+			{ID: "contigs", Type: "File", OutputSource: "assemble/contigs"},
+		},
+		Requirements: []cwl.Requirement{{Class: "SubworkflowFeatureRequirement"}},
+		Steps: []cwl.WorkflowStep{
+			{
+				ID:  "trim",
+				Run: echoTool("reads", "trimmed"),
+				In:  []cwl.WorkflowStepInput{{ID: "reads", Source: "raw_reads"}},
+				Out: []interface{}{"trimmed"},
+			},
+			{
+				ID:  "assemble",
+				Run: inner,
+				In:  []cwl.WorkflowStepInput{{ID: "reads", Source: "trim/trimmed"}},
+				Out: []interface{}{"contigs"},
+			},
+			{
+				ID:  "annotate",
+				Run: echoTool("contigs", "annotation"),
+				In:  []cwl.WorkflowStepInput{{ID: "contigs", Source: "assemble/contigs"}},
+				Out: []interface{}{"annotation"},
+			},
+		},
+	}
+}
+
+func TestBuilder_Build_Subworkflow(t *testing.T) {
+	builder := NewBuilder(newSubworkflowDoc(), map[string]interface{}{
+		"raw_reads": map[string]interface{}{"class": "File", "path": "/data/reads.fq"},
+	})
+	dag, err := builder.Build("subworkflow-test")
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+
+	if len(dag.Nodes) != 4 {
+		t.Fatalf("Expected 4 nodes, got %d", len(dag.Nodes))
+	}
+	if dag.GetNode("assemble") != nil {
+		t.Error("Expected subworkflow step to be expanded, found node 'assemble'")
+	}
+
+	spades := dag.GetNode("assemble.spades")
+	if spades == nil {
+		t.Fatal("Expected node assemble.spades")
+	}
+	if len(spades.Dependencies) != 1 || spades.Dependencies[0] != "trim" {
+		t.Errorf("Expected assemble.spades to depend on trim, got %v", spades.Dependencies)
+	}
+	if src := spades.Step.In[0].Source; src != "trim/trimmed" {
+		t.Errorf("Expected assemble.spades source 'trim/trimmed', got %v", src)
+	}
+	if len(spades.Step.Requirements) != 1 || spades.Step.Requirements[0].Class != "SubworkflowFeatureRequirement" {
+		t.Errorf("Expected assemble.spades to inherit workflow requirements, got %v", spades.Step.Requirements)
+	}
+
+	polish := dag.GetNode("assemble.polish")
+	if polish == nil {
+		t.Fatal("Expected node assemble.polish")
+	}
+	if src := polish.Step.In[0].Source; src != "assemble.spades/contigs" {
+		t.Errorf("Expected assemble.polish source 'assemble.spades/contigs', got %v", src)
+	}
+
+	annotate := dag.GetNode("annotate")
+	if len(annotate.Dependencies) != 1 || annotate.Dependencies[0] != "assemble.polish" {
+		t.Errorf("Expected annotate to depend on assemble.polish, got %v", annotate.Dependencies)
+	}
+	if src := annotate.Step.In[0].Source; src != "assemble.polish/polished" {
+		t.Errorf("Expected annotate source 'assemble.polish/polished', got %v", src)
+	}
+}
+
+func TestBuilder_ExpandSubworkflows_Nested(t *testing.T) {
+	doc := newSubworkflowDoc()
+
+	// Wrap the whole pipeline in another workflow level
+	outer := &cwl.Document{
+		CWLVersion: "v1.2",
+		Class:      cwl.ClassWorkflow,
+		Inputs: []cwl.Input{
+			{ID: "sample", Type: "File"},
+		},
+		Outputs: []cwl.Output{
+			{ID: "contigs", Type: "File", OutputSource: "pipeline/contigs"},
+		},
+		Steps: []cwl.WorkflowStep{
+			{
+				ID:  "pipeline",
+				Run: doc,
+				In:  []cwl.WorkflowStepInput{{ID: "raw_reads", Source: "sample"}},
+				Out: []interface{}{"contigs"},
+			},
+		},
+	}
+
+	builder := NewBuilder(outer, nil)
+	flat, tools, err := builder.expandSubworkflows(outer)
+	if err != nil {
+		t.Fatalf("expandSubworkflows failed: %v", err)
+	}
+
+	expected := []string{"pipeline.trim", "pipeline.assemble.spades", "pipeline.assemble.polish", "pipeline.annotate"}
+	if len(flat.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %d", len(expected), len(flat.Steps))
+	}
+	for i, id := range expected {
+		if flat.Steps[i].ID != id {
+			t.Errorf("Expected step %d to be %s, got %s", i, id, flat.Steps[i].ID)
+		}
+		if tools[id] == nil {
+			t.Errorf("Expected tool for step %s", id)
+		}
+	}
+
+	if src := flat.Steps[0].In[0].Source; src != "sample" {
+		t.Errorf("Expected pipeline.trim source 'sample', got %v", src)
+	}
+	if src := flat.Outputs[0].OutputSource; src != "pipeline.assemble.polish/polished" {
+		t.Errorf("Expected workflow output source 'pipeline.assemble.polish/polished', got %v", src)
+	}
+}
+
+func TestBuilder_ExpandSubworkflows_ScatterOverStepOutput(t *testing.T) {
+	doc := newSubworkflowDoc()
+	doc.Steps[1].Scatter = "reads"
+
+	// Scatter elements must be known while building, as for any step
+	builder := NewBuilder(doc, nil)
+	if _, _, err := builder.expandSubworkflows(doc); err == nil {
+		t.Error("Expected error for a subworkflow step scattered over a step output")
+	}
+}
+
+// runToCompletion builds doc and runs it the way the scheduler daemon does,
+// completing each tool node with a File named after its input path and
+// output ID. It returns the DAG and the workflow outputs.
+func runToCompletion(t *testing.T, doc *cwl.Document, inputs map[string]interface{}) (*DAG, map[string]interface{}) {
+	t.Helper()
+	builder := NewBuilder(doc, inputs)
+	d, err := builder.Build("subworkflow-run")
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+
+	for !d.IsComplete() {
+		ready := d.GetReadyNodes()
+		if len(ready) == 0 {
+			t.Fatal("DAG stalled with no ready nodes")
+		}
+		for _, node := range ready {
+			resolved, err := ResolveStepInputs(d, node, inputs)
+			if err != nil {
+				t.Fatalf("ResolveStepInputs(%s) failed: %v", node.ID, err)
+			}
+			run, err := EvaluateWhen(node, resolved)
+			if err != nil {
+				t.Fatalf("EvaluateWhen(%s) failed: %v", node.ID, err)
+			}
+			if !run {
+				if err := d.SkipNode(node.ID); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if node.RunsSubworkflow() {
+				node.SetOutputs(SubworkflowOutputs(node, resolved))
+			} else {
+				outputs := make(map[string]interface{})
+				for _, id := range node.Step.OutputIDs() {
+					outputs[id] = nil
+					for _, value := range resolved {
+						file, _ := value.(map[string]interface{})
+						path, _ := file["path"].(string)
+						outputs[id] = map[string]interface{}{"class": "File", "path": path + "." + id}
+					}
+				}
+				node.SetOutputs(outputs)
+			}
+			if err := d.UpdateNodeStatus(node.ID, StatusCompleted); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	outputs, err := builder.CollectOutputs(d)
+	if err != nil {
+		t.Fatalf("CollectOutputs failed: %v", err)
+	}
+	return d, outputs
+}
+
+// fileAt returns a File object for path.
+func fileAt(path string) map[string]interface{} {
+	return map[string]interface{}{"class": "File", "path": path}
+}
+
+// newScatteredSubworkflowDoc returns the subworkflow pipeline with the
+// assemble step scattered over the samples input.
+func newScatteredSubworkflowDoc() *cwl.Document {
+	doc := newSubworkflowDoc()
+	doc.Inputs = append(doc.Inputs, cwl.Input{ID: "samples", Type: "File[]"})
+	doc.Requirements = append(doc.Requirements, cwl.Requirement{Class: "ScatterFeatureRequirement"})
+	doc.Steps[1].In = []cwl.WorkflowStepInput{{ID: "reads", Source: "samples"}}
+	doc.Steps[1].Scatter = "reads"
+	doc.Outputs = doc.Outputs[1:]
+	return doc
+}
+
+func TestBuilder_Build_ScatteredSubworkflow(t *testing.T) {
+	inputs := map[string]interface{}{
+		"raw_reads": fileAt("/data/reads.fq"),
+		"samples":   []interface{}{fileAt("/data/a.fq"), fileAt("/data/b.fq")},
+	}
+	d, outputs := runToCompletion(t, newScatteredSubworkflowDoc(), inputs)
+
+	for _, id := range []string{"assemble_0.spades", "assemble_0.polish", "assemble_1.spades", "assemble_1.polish"} {
+		if d.GetNode(id) == nil {
+			t.Errorf("Expected node %s", id)
+		}
+	}
+	annotate := d.GetNode("annotate")
+	if len(annotate.Dependencies) != 2 {
+		t.Errorf("Expected annotate to depend on both scatter elements, got %v", annotate.Dependencies)
+	}
+
+	expected := []interface{}{
+		fileAt("/data/a.fq.contigs.polished"),
+		fileAt("/data/b.fq.contigs.polished"),
+	}
+	if !reflect.DeepEqual(outputs["contigs"], expected) {
+		t.Errorf("Expected gathered contigs %v, got %v", expected, outputs["contigs"])
+	}
+}
+
+func TestBuilder_Build_ScatteredSubworkflowNested(t *testing.T) {
+	outer := &cwl.Document{
+		CWLVersion: "v1.2",
+		Class:      cwl.ClassWorkflow,
+		Inputs:     []cwl.Input{{ID: "sample"}, {ID: "batch"}},
+		Outputs:    []cwl.Output{{ID: "contigs", OutputSource: "pipeline/contigs"}},
+		Steps: []cwl.WorkflowStep{{
+			ID:  "pipeline",
+			Run: newScatteredSubworkflowDoc(),
+			In: []cwl.WorkflowStepInput{
+				{ID: "raw_reads", Source: "sample"},
+				{ID: "samples", Source: "batch"},
+			},
+			Out: []interface{}{"contigs"},
+		}},
+	}
+	inputs := map[string]interface{}{
+		"sample": fileAt("/data/reads.fq"),
+		"batch":  []interface{}{fileAt("/data/a.fq")},
+	}
+	d, outputs := runToCompletion(t, outer, inputs)
+
+	if d.GetNode("pipeline.assemble_0.polish") == nil {
+		t.Error("Expected node pipeline.assemble_0.polish")
+	}
+	expected := []interface{}{fileAt("/data/a.fq.contigs.polished")}
+	if !reflect.DeepEqual(outputs["contigs"], expected) {
+		t.Errorf("Expected gathered contigs %v, got %v", expected, outputs["contigs"])
+	}
+}
+
+func TestBuilder_Build_ConditionalSubworkflow(t *testing.T) {
+	for _, assemble := range []bool{true, false} {
+		doc := newSubworkflowDoc()
+		doc.Inputs = append(doc.Inputs, cwl.Input{ID: "assemble", Type: "boolean"})
+		doc.Steps[1].In = append(doc.Steps[1].In, cwl.WorkflowStepInput{ID: "enabled", Source: "assemble"})
+		doc.Steps[1].When = "$(inputs.enabled)"
+
+		d, outputs := runToCompletion(t, doc, map[string]interface{}{
+			"raw_reads": fileAt("/data/reads.fq"),
+			"assemble":  assemble,
+		})
+
+		spades := d.GetNode("assemble.spades")
+		if len(spades.Dependencies) != 1 || spades.Dependencies[0] != "assemble" {
+			t.Errorf("Expected assemble.spades to read its inputs through the gate, got %v", spades.Dependencies)
+		}
+		if !assemble {
+			for _, id := range []string{"assemble", "assemble.spades", "assemble.polish"} {
+				if status := d.GetNode(id).GetStatus(); status != StatusSkipped {
+					t.Errorf("Expected %s skipped, got %s", id, status)
+				}
+			}
+			if outputs["contigs"] != nil {
+				t.Errorf("Expected null contigs from a skipped subworkflow, got %v", outputs["contigs"])
+			}
+			continue
+		}
+		expected := fileAt("/data/reads.fq.trimmed.contigs.polished")
+		if !reflect.DeepEqual(outputs["contigs"], expected) {
+			t.Errorf("Expected contigs %v, got %v", expected, outputs["contigs"])
+		}
+	}
+}
+
+func TestBuilder_Build_ScatteredConditionalSubworkflow(t *testing.T) {
+	doc := newScatteredSubworkflowDoc()
+	doc.Requirements = append(doc.Requirements, cwl.Requirement{Class: "InlineJavascriptRequirement"})
+	doc.Steps[1].When = "$(inputs.reads.path != '/data/b.fq')"
+
+	inputs := map[string]interface{}{
+		"raw_reads": fileAt("/data/reads.fq"),
+		"samples":   []interface{}{fileAt("/data/a.fq"), fileAt("/data/b.fq")},
+	}
+	d, outputs := runToCompletion(t, doc, inputs)
+
+	if status := d.GetNode("assemble_1.polish").GetStatus(); status != StatusSkipped {
+		t.Errorf("Expected the second element's steps skipped, got %s", status)
+	}
+	expected := []interface{}{fileAt("/data/a.fq.contigs.polished"), nil}
+	if !reflect.DeepEqual(outputs["contigs"], expected) {
+		t.Errorf("Expected contigs %v, got %v", expected, outputs["contigs"])
+	}
+}
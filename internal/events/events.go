@@ -29,6 +29,7 @@ type TaskCompletionEvent struct {
 	Status    string `json:"status"`
 	ExitCode  int    `json:"exit_code,omitempty"`
 	Error     string `json:"error,omitempty"`
+	Reason    string `json:"reason,omitempty"` // failure reason, e.g. "timeout"
 	Timestamp int64  `json:"time"`
 }
 
@@ -204,6 +205,9 @@ func (h *WorkflowEventHandler) HandleTaskCompletion(ctx context.Context, event T
 	if event.Error != "" {
 		update.ErrorMessage = event.Error
 	}
+	if stepStatus == state.StepFailed && event.Reason != "" {
+		update.FailureReason = state.FailureReason(event.Reason)
+	}
 
 	if err := h.store.UpdateStepExecution(ctx, exec.ID, update); err != nil {
 		return fmt.Errorf("failed to update step execution: %w", err)
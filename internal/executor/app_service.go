@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -38,14 +39,31 @@ func (e *AppServiceExecutor) Execute(ctx context.Context, node *dag.Node) error
 	if node.Tool == nil {
 		return fmt.Errorf("node %s has no resolved tool", node.ID)
 	}
+	if node.IsExpressionTool() {
+		return fmt.Errorf("node %s is an ExpressionTool and must be evaluated by the scheduler", node.ID)
+	}
+
+	// Size the step from its inputs
+	res, err := nodeResources(node, e.config.Executor)
+	if err != nil {
+		return fmt.Errorf("failed to resolve resources for node %s: %w", node.ID, err)
+	}
+
+	timeLimit, err := node.Tool.ResolveTimeLimit(node.Inputs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve time limit for node %s: %w", node.ID, err)
+	}
 
-	params, err := buildTaskParamsForNode(node)
+	params, err := buildTaskParamsForNode(node, res, e.config.Executor.Checksums)
 	if err != nil {
 		return fmt.Errorf("failed to build task params: %w", err)
 	}
+	addStorageParams(params, node, e.config.Storage)
+	if timeLimit > 0 {
+		params["cwl_time_limit"] = int(timeLimit / time.Second)
+	}
 
-	// Get resource requirements
-	cores, ramMB, _ := node.Tool.GetResourceRequirements()
+	cores, ramMB := res.Cores, res.RAM
 	if cores == 0 {
 		cores = e.config.Executor.DefaultCPU
 	}
@@ -60,7 +78,7 @@ func (e *AppServiceExecutor) Execute(ctx context.Context, node *dag.Node) error
 		Params:        params,
 		ReqCPU:        cores,
 		ReqMemory:     ramMB,
-		ReqRuntime:    e.config.Executor.DefaultRuntime,
+		ReqRuntime:    requestedRuntime(timeLimit, e.config.Executor),
 		ContainerID:   containerID,
 		OutputPath:    node.OutputPath,
 		OutputFile:    defaultOutputFile,
@@ -86,6 +104,16 @@ func (e *AppServiceExecutor) GetStatus(ctx context.Context, taskID string) (dag.
 	return mapStateCode(resp.StateCode), nil
 }
 
+// IsTemporaryFailure reports whether a failed BV-BRC Task failed
+// temporarily, which cwl-step-runner signals with cwl.TemporaryFailExitCode.
+func (e *AppServiceExecutor) IsTemporaryFailure(ctx context.Context, taskID string) (bool, error) {
+	resp, err := e.client.GetTaskStatus(ctx, taskID)
+	if err != nil {
+		return false, err
+	}
+	return resp.ExitCode != nil && *resp.ExitCode == cwl.TemporaryFailExitCode, nil
+}
+
 // GetOutputs retrieves outputs from a completed BV-BRC Task via app_service.
 func (e *AppServiceExecutor) GetOutputs(ctx context.Context, taskID string) (map[string]interface{}, error) {
 	resp, err := e.client.GetTaskOutputs(ctx, taskID)
@@ -104,35 +132,41 @@ func (e *AppServiceExecutor) Cancel(ctx context.Context, taskID string) error {
 }
 
 // buildTaskParamsForNode builds the parameters for CWLStepRunner.
-func buildTaskParamsForNode(node *dag.Node) (map[string]interface{}, error) {
+func buildTaskParamsForNode(node *dag.Node, res cwl.Resources, checksums bool) (map[string]interface{}, error) {
 	tool := node.Tool
 
-	builder := cwl.NewCommandBuilder(tool, node.Inputs)
+	// The step runner chooses the directories, so the command refers to
+	// them through placeholders it replaces
+	runtime := res.RuntimeContext(cwl.OutdirPlaceholder, cwl.TmpdirPlaceholder)
+	inputs := cwl.PlaceInputs(node.Inputs, cwl.OutdirPlaceholder)
+	builder := cwl.NewCommandBuilder(tool, inputs)
+	builder.SetRuntime(runtime)
 	command, err := builder.BuildCommand()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build command: %w", err)
 	}
 
-	outputBindings := make(map[string]interface{})
-	for _, out := range tool.Outputs {
-		if out.OutputBinding != nil {
-			outputBindings[out.ID] = map[string]interface{}{
-				"glob":         out.OutputBinding.Glob,
-				"loadContents": out.OutputBinding.LoadContents,
-				"loadListing":  out.OutputBinding.LoadListing,
-				"outputEval":   out.OutputBinding.OutputEval,
-			}
-		}
-	}
-
 	params := map[string]interface{}{
 		"cwl_command": command,
-		"cwl_inputs":  node.Inputs,
-		"cwl_outputs": outputBindings,
+		"cwl_inputs":  inputs,
+		"cwl_outputs": outputBindingParams(tool, checksums),
+		"cwl_runtime": runtime,
 		"cwl_step_id": node.StepID,
 		"cwl_node_id": node.ID,
 	}
 
+	// Add InitialWorkDirRequirement entries
+	workDir, err := builder.BuildInitialWorkDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build initial working directory: %w", err)
+	}
+	if len(workDir) > 0 {
+		params["cwl_initial_workdir"] = workDir
+	}
+	if lib := tool.ExpressionLib(); len(lib) > 0 {
+		params["cwl_expression_lib"] = lib
+	}
+
 	if tool.Stdin != "" {
 		params["cwl_stdin"] = tool.Stdin
 	}
@@ -143,6 +177,17 @@ func buildTaskParamsForNode(node *dag.Node) (map[string]interface{}, error) {
 		params["cwl_stderr"] = tool.Stderr
 	}
 
+	// Add exit code interpretation
+	if len(tool.SuccessCodes) > 0 {
+		params["cwl_success_codes"] = tool.SuccessCodes
+	}
+	if len(tool.TemporaryFailCodes) > 0 {
+		params["cwl_temporary_fail_codes"] = tool.TemporaryFailCodes
+	}
+	if len(tool.PermanentFailCodes) > 0 {
+		params["cwl_permanent_fail_codes"] = tool.PermanentFailCodes
+	}
+
 	for _, req := range tool.Requirements {
 		if req.Class == "EnvVarRequirement" {
 			envVars := make(map[string]string)
@@ -156,6 +201,71 @@ func buildTaskParamsForNode(node *dag.Node) (map[string]interface{}, error) {
 	return params, nil
 }
 
+// outputBindingParams describes the tool's output bindings in the form
+// cwl-step-runner reads from cwl_outputs.
+func outputBindingParams(tool *cwl.Document, checksums bool) []map[string]interface{} {
+	defs := tool.SchemaDefs()
+	var bindings []map[string]interface{}
+	for _, out := range tool.Outputs {
+		outType, _ := defs.ParseType(out.Type)
+		if out.OutputBinding == nil && !outType.HasFieldOutputBindings() {
+			continue
+		}
+		bindings = append(bindings, outputBindingParam(out.ID, out.OutputBinding, outType, out.Format, out.SecondaryFiles, checksums))
+	}
+	return bindings
+}
+
+// addStorageParams tells the step runner where to stage the node's inputs
+// from and where to upload its outputs.
+func addStorageParams(params map[string]interface{}, node *dag.Node, storage config.StorageConfig) {
+	params["cwl_storage"] = map[string]interface{}{
+		"local_path":    storage.LocalPath,
+		"workspace_url": storage.WorkspaceURL,
+		"shock_url":     storage.ShockURL,
+	}
+	if node.OutputPath != "" {
+		params["cwl_output_path"] = path.Join(node.OutputPath, node.ID)
+	}
+}
+
+// outputBindingParam describes how the step runner collects one output,
+// or one field of a record output. Records collected field by field carry
+// a binding per field.
+func outputBindingParam(id string, ob *cwl.CommandOutputBinding, outType *cwl.CWLType, format interface{}, secondaryFiles []cwl.SecondaryFileSpec, checksums bool) map[string]interface{} {
+	binding := map[string]interface{}{
+		"id":       id,
+		"checksum": checksums,
+	}
+	if outType != nil {
+		binding["type"] = outType.String()
+	}
+	if ob != nil {
+		binding["glob"] = ob.Glob
+		binding["loadContents"] = ob.LoadContents
+		binding["loadListing"] = ob.LoadListing
+		binding["outputEval"] = ob.OutputEval
+	}
+	if format, ok := format.(string); ok {
+		binding["format"] = format
+	}
+	if len(secondaryFiles) > 0 {
+		binding["secondaryFiles"] = secondaryFiles
+	}
+
+	if outType.HasFieldOutputBindings() {
+		var fields []map[string]interface{}
+		for _, field := range outType.Fields {
+			if field.OutputBinding == nil && !field.Type.HasFieldOutputBindings() {
+				continue
+			}
+			fields = append(fields, outputBindingParam(field.Name, field.OutputBinding, field.Type, field.Format, field.SecondaryFiles, checksums))
+		}
+		binding["fields"] = fields
+	}
+	return binding
+}
+
 func resolveContainerID(tool *cwl.Document) string {
 	dockerImage := tool.GetDockerImage()
 	if dockerImage == "" {
@@ -0,0 +1,38 @@
+package executor
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+func TestOutputBindingParams_ArrayGlob(t *testing.T) {
+	tool := &cwl.Document{
+		Class: cwl.ClassCommandLineTool,
+		Outputs: []cwl.Output{{
+			ID:            "reports",
+			Type:          "File[]",
+			OutputBinding: &cwl.CommandOutputBinding{Glob: []interface{}{"*.txt", "*.$(inputs.ext)"}},
+		}},
+	}
+
+	// The step runner reads the bindings back from JSON
+	data, err := json.Marshal(outputBindingParams(tool, false))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var bindings []map[string]interface{}
+	if err := json.Unmarshal(data, &bindings); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if len(bindings) != 1 {
+		t.Fatalf("Expected 1 binding, got %d", len(bindings))
+	}
+	expected := []interface{}{"*.txt", "*.$(inputs.ext)"}
+	if !reflect.DeepEqual(bindings[0]["glob"], expected) {
+		t.Errorf("Expected glob %v, got %v", expected, bindings[0]["glob"])
+	}
+}
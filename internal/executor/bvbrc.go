@@ -92,7 +92,10 @@ func (e *BVBRCExecutor) SubmitJob(ctx context.Context, token string, jobSpec *bv
 	}
 
 	// Extract resource requirements from the CWL tool
-	cpu, memoryMB := jobSpec.GetResourceRequirements()
+	cpu, memoryMB, err := jobSpec.GetResourceRequirements()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve resource requirements: %w", err)
+	}
 	if cpu > 0 {
 		taskParams["req_cpu"] = fmt.Sprintf("%d", cpu)
 	}
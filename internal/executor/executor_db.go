@@ -6,6 +6,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BV-BRC/cwe-cwl/internal/config"
@@ -37,15 +39,32 @@ func (e *DBExecutor) Execute(ctx context.Context, node *dag.Node) error {
 	if node.Tool == nil {
 		return fmt.Errorf("node %s has no resolved tool", node.ID)
 	}
+	if node.IsExpressionTool() {
+		return fmt.Errorf("node %s is an ExpressionTool and must be evaluated by the scheduler", node.ID)
+	}
 
 	// Build task parameters
-	params, err := e.buildTaskParams(node)
+	// Size the step from its inputs
+	res, err := nodeResources(node, e.config.Executor)
+	if err != nil {
+		return fmt.Errorf("failed to resolve resources for node %s: %w", node.ID, err)
+	}
+
+	timeLimit, err := node.Tool.ResolveTimeLimit(node.Inputs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve time limit for node %s: %w", node.ID, err)
+	}
+
+	params, err := e.buildTaskParams(node, res)
 	if err != nil {
 		return fmt.Errorf("failed to build task params: %w", err)
 	}
+	addStorageParams(params, node, e.config.Storage)
+	if timeLimit > 0 {
+		params["cwl_time_limit"] = int(timeLimit / time.Second)
+	}
 
-	// Get resource requirements
-	cores, ramMB, _ := node.Tool.GetResourceRequirements()
+	cores, ramMB := res.Cores, res.RAM
 	if cores == 0 {
 		cores = e.config.Executor.DefaultCPU
 	}
@@ -66,7 +85,7 @@ func (e *DBExecutor) Execute(ctx context.Context, node *dag.Node) error {
 		Params:        params,
 		ReqCPU:        cores,
 		ReqMemory:     ramMB,
-		ReqRuntime:    e.config.Executor.DefaultRuntime,
+		ReqRuntime:    requestedRuntime(timeLimit, e.config.Executor),
 		ContainerID:   containerID,
 	})
 	if err != nil {
@@ -99,37 +118,42 @@ type TaskParams struct {
 }
 
 // buildTaskParams builds the parameters for CWLStepRunner.
-func (e *DBExecutor) buildTaskParams(node *dag.Node) (map[string]interface{}, error) {
+func (e *DBExecutor) buildTaskParams(node *dag.Node, res cwl.Resources) (map[string]interface{}, error) {
 	tool := node.Tool
 
 	// Build command line
-	builder := cwl.NewCommandBuilder(tool, node.Inputs)
+	// The step runner chooses the directories, so the command refers to
+	// them through placeholders it replaces
+	runtime := res.RuntimeContext(cwl.OutdirPlaceholder, cwl.TmpdirPlaceholder)
+	inputs := cwl.PlaceInputs(node.Inputs, cwl.OutdirPlaceholder)
+	builder := cwl.NewCommandBuilder(tool, inputs)
+	builder.SetRuntime(runtime)
 	command, err := builder.BuildCommand()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build command: %w", err)
 	}
 
-	// Collect output bindings
-	outputBindings := make(map[string]interface{})
-	for _, out := range tool.Outputs {
-		if out.OutputBinding != nil {
-			outputBindings[out.ID] = map[string]interface{}{
-				"glob":         out.OutputBinding.Glob,
-				"loadContents": out.OutputBinding.LoadContents,
-				"loadListing":  out.OutputBinding.LoadListing,
-				"outputEval":   out.OutputBinding.OutputEval,
-			}
-		}
-	}
-
 	params := map[string]interface{}{
 		"cwl_command":       command,
-		"cwl_inputs":        node.Inputs,
-		"cwl_outputs":       outputBindings,
+		"cwl_inputs":        inputs,
+		"cwl_outputs":       outputBindingParams(tool, e.config.Executor.Checksums),
+		"cwl_runtime":       runtime,
 		"cwl_step_id":       node.StepID,
 		"cwl_node_id":       node.ID,
 	}
 
+	// Add InitialWorkDirRequirement entries
+	workDir, err := builder.BuildInitialWorkDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build initial working directory: %w", err)
+	}
+	if len(workDir) > 0 {
+		params["cwl_initial_workdir"] = workDir
+	}
+	if lib := tool.ExpressionLib(); len(lib) > 0 {
+		params["cwl_expression_lib"] = lib
+	}
+
 	// Add stdin/stdout/stderr if specified
 	if tool.Stdin != "" {
 		params["cwl_stdin"] = tool.Stdin
@@ -141,6 +165,17 @@ func (e *DBExecutor) buildTaskParams(node *dag.Node) (map[string]interface{}, er
 		params["cwl_stderr"] = tool.Stderr
 	}
 
+	// Add exit code interpretation
+	if len(tool.SuccessCodes) > 0 {
+		params["cwl_success_codes"] = tool.SuccessCodes
+	}
+	if len(tool.TemporaryFailCodes) > 0 {
+		params["cwl_temporary_fail_codes"] = tool.TemporaryFailCodes
+	}
+	if len(tool.PermanentFailCodes) > 0 {
+		params["cwl_permanent_fail_codes"] = tool.PermanentFailCodes
+	}
+
 	// Add environment variables
 	for _, req := range tool.Requirements {
 		if req.Class == "EnvVarRequirement" {
@@ -271,6 +306,40 @@ func (e *DBExecutor) GetStatus(ctx context.Context, taskID string) (dag.NodeStat
 	}
 }
 
+// IsTemporaryFailure reports whether a failed BV-BRC Task failed
+// temporarily: its latest cluster job exited with cwl.TemporaryFailExitCode,
+// which cwl-step-runner uses for the tool's temporaryFailCodes.
+func (e *DBExecutor) IsTemporaryFailure(ctx context.Context, taskID string) (bool, error) {
+	var exitCode sql.NullString
+	err := e.db.QueryRowContext(ctx, `
+		SELECT cj.exitcode FROM TaskExecution te
+		JOIN ClusterJob cj ON cj.id = te.cluster_job_id
+		WHERE te.task_id = $1
+		ORDER BY cj.id DESC LIMIT 1
+	`, taskID).Scan(&exitCode)
+
+	if err == sql.ErrNoRows {
+		// The task never reached the cluster
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get task exit code: %w", err)
+	}
+
+	return parseExitCode(exitCode.String) == cwl.TemporaryFailExitCode, nil
+}
+
+// parseExitCode parses a cluster job exit code, which Slurm reports as
+// "code:signal", returning -1 if there is none.
+func parseExitCode(s string) int {
+	code, _, _ := strings.Cut(s, ":")
+	n, err := strconv.Atoi(strings.TrimSpace(code))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
 // GetOutputs retrieves outputs from a completed BV-BRC Task.
 func (e *DBExecutor) GetOutputs(ctx context.Context, taskID string) (map[string]interface{}, error) {
 	var outputPath string
@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+// exitCodeDriver is a database/sql driver whose queries return the exit
+// code it holds for the task ID given as the first argument, or no rows.
+type exitCodeDriver struct {
+	codes map[string]string
+}
+
+func (d *exitCodeDriver) Open(name string) (driver.Conn, error) { return &exitCodeConn{d}, nil }
+
+type exitCodeConn struct{ d *exitCodeDriver }
+
+func (c *exitCodeConn) Prepare(query string) (driver.Stmt, error) { return &exitCodeStmt{c.d}, nil }
+func (c *exitCodeConn) Close() error                              { return nil }
+func (c *exitCodeConn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("not supported") }
+
+type exitCodeStmt struct{ d *exitCodeDriver }
+
+func (s *exitCodeStmt) Close() error  { return nil }
+func (s *exitCodeStmt) NumInput() int { return -1 }
+func (s *exitCodeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (s *exitCodeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &exitCodeRows{}
+	if code, ok := s.d.codes[fmt.Sprint(args[0])]; ok {
+		rows.values = []string{code}
+	}
+	return rows, nil
+}
+
+type exitCodeRows struct{ values []string }
+
+func (r *exitCodeRows) Columns() []string { return []string{"exitcode"} }
+func (r *exitCodeRows) Close() error      { return nil }
+func (r *exitCodeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestDBExecutor_IsTemporaryFailure(t *testing.T) {
+	sql.Register("exitcode", &exitCodeDriver{codes: map[string]string{
+		"1": fmt.Sprintf("%d:0", cwl.TemporaryFailExitCode),
+		"2": "1:0",
+		"3": "0:9",
+	}})
+	db, err := sql.Open("exitcode", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	e := NewDBExecutor(nil, db, nil)
+
+	tests := map[string]bool{
+		"1": true,  // the step runner's temporary failure code
+		"2": false, // a permanent failure
+		"3": false, // killed by a signal
+		"4": false, // never reached the cluster
+	}
+	for taskID, want := range tests {
+		got, err := e.IsTemporaryFailure(context.Background(), taskID)
+		if err != nil {
+			t.Fatalf("IsTemporaryFailure(%s) failed: %v", taskID, err)
+		}
+		if got != want {
+			t.Errorf("IsTemporaryFailure(%s) = %v, want %v", taskID, got, want)
+		}
+	}
+}
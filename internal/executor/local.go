@@ -2,22 +2,27 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"sync"
 
+	"github.com/BV-BRC/cwe-cwl/internal/config"
 	"github.com/BV-BRC/cwe-cwl/internal/cwl"
 	"github.com/BV-BRC/cwe-cwl/internal/dag"
+	"github.com/BV-BRC/cwe-cwl/internal/process"
+	"github.com/BV-BRC/cwe-cwl/internal/staging"
 )
 
 // LocalExecutor executes CWL steps locally for development/testing.
 type LocalExecutor struct {
-	workDir string
-	tasks   map[string]*localTask
-	mu      sync.RWMutex
+	workDir   string
+	stager    *staging.Stager
+	checksums bool
+	tasks     map[string]*localTask
+	mu        sync.RWMutex
 }
 
 type localTask struct {
@@ -25,24 +30,64 @@ type localTask struct {
 	status  dag.NodeStatus
 	outputs map[string]interface{}
 	err     error
+	// temporary is set when the command failed with a temporaryFailCodes
+	// exit code.
+	temporary bool
 }
 
 // NewLocalExecutor creates a new local executor.
 func NewLocalExecutor(workDir string) *LocalExecutor {
 	return &LocalExecutor{
-		workDir: workDir,
-		tasks:   make(map[string]*localTask),
+		workDir:   workDir,
+		stager:    staging.NewStager(&config.StorageConfig{}),
+		checksums: true,
+		tasks:     make(map[string]*localTask),
 	}
 }
 
+// SetChecksums sets whether output Files get sha1 checksums.
+func (e *LocalExecutor) SetChecksums(enabled bool) {
+	e.checksums = enabled
+}
+
 // Execute starts execution of a DAG node locally.
 func (e *LocalExecutor) Execute(ctx context.Context, node *dag.Node) error {
 	if node.Tool == nil {
 		return fmt.Errorf("node %s has no resolved tool", node.ID)
 	}
+	if node.IsExpressionTool() {
+		return fmt.Errorf("node %s is an ExpressionTool and must be evaluated by the scheduler", node.ID)
+	}
+
+	// Create work and temporary directories for this task
+	taskDir := filepath.Join(e.workDir, node.ID)
+	if err := os.MkdirAll(taskDir, 0755); err != nil {
+		return fmt.Errorf("failed to create task directory: %w", err)
+	}
+	tmpDir := filepath.Join(e.workDir, node.ID+".tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to create task temporary directory: %w", err)
+	}
+
+	// Stage File and Directory inputs into the task directory
+	inputs, err := e.stager.StageInputs(ctx, cwl.PlaceInputs(node.Inputs, taskDir), taskDir)
+	if err != nil {
+		return fmt.Errorf("failed to stage inputs: %w", err)
+	}
+
+	res, err := node.Tool.ResolveResources(inputs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve resources for node %s: %w", node.ID, err)
+	}
+	runtime := res.RuntimeContext(taskDir, tmpDir)
+	timeLimit, err := node.Tool.ResolveTimeLimit(inputs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve time limit for node %s: %w", node.ID, err)
+	}
 
 	// Build command line
-	builder := cwl.NewCommandBuilder(node.Tool, node.Inputs)
+	builder := cwl.NewCommandBuilder(node.Tool, inputs)
+	builder.SetRuntime(runtime)
 	command, err := builder.BuildCommand()
 	if err != nil {
 		return fmt.Errorf("failed to build command: %w", err)
@@ -52,10 +97,13 @@ func (e *LocalExecutor) Execute(ctx context.Context, node *dag.Node) error {
 		return fmt.Errorf("empty command for node %s", node.ID)
 	}
 
-	// Create work directory for this task
-	taskDir := filepath.Join(e.workDir, node.ID)
-	if err := os.MkdirAll(taskDir, 0755); err != nil {
-		return fmt.Errorf("failed to create task directory: %w", err)
+	// Stage InitialWorkDirRequirement entries
+	workDirEntries, err := builder.BuildInitialWorkDir()
+	if err != nil {
+		return fmt.Errorf("failed to build initial working directory: %w", err)
+	}
+	if err := staging.StageWorkDir(taskDir, workDirEntries); err != nil {
+		return fmt.Errorf("failed to stage working directory: %w", err)
 	}
 
 	// Create the command
@@ -103,18 +151,43 @@ func (e *LocalExecutor) Execute(ctx context.Context, node *dag.Node) error {
 
 	// Start command asynchronously
 	go func() {
-		err := cmd.Run()
+		err := process.Run(cmd, timeLimit)
 
 		e.mu.Lock()
 		defer e.mu.Unlock()
 
+		// Interpret the exit code per the tool's successCodes,
+		// temporaryFailCodes and permanentFailCodes
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			exitCode = exitErr.ExitCode()
+			err = nil
+		}
+		if err == nil {
+			switch node.Tool.ClassifyExitCode(exitCode) {
+			case cwl.ExitTemporaryFailure:
+				task.temporary = true
+				err = fmt.Errorf("command failed with temporary failure code %d", exitCode)
+			case cwl.ExitPermanentFailure:
+				err = fmt.Errorf("command failed with exit code %d", exitCode)
+			}
+		}
+
+		// Collect outputs
+		if err == nil {
+			runtime["exitCode"] = exitCode
+			task.outputs, err = e.collectOutputs(taskDir, node.Tool, inputs, runtime)
+			if err != nil {
+				err = fmt.Errorf("failed to collect outputs: %w", err)
+			}
+		}
+
 		if err != nil {
 			task.status = dag.StatusFailed
 			task.err = err
 		} else {
 			task.status = dag.StatusCompleted
-			// Collect outputs
-			task.outputs, _ = e.collectOutputs(taskDir, node.Tool)
 		}
 	}()
 
@@ -122,71 +195,113 @@ func (e *LocalExecutor) Execute(ctx context.Context, node *dag.Node) error {
 }
 
 // collectOutputs collects outputs from a completed task.
-func (e *LocalExecutor) collectOutputs(taskDir string, tool *cwl.Document) (map[string]interface{}, error) {
+func (e *LocalExecutor) collectOutputs(taskDir string, tool *cwl.Document, inputs map[string]interface{}, runtime map[string]interface{}) (map[string]interface{}, error) {
 	outputs := make(map[string]interface{})
 	evaluator := cwl.NewExpressionEvaluator()
+	evaluator.SetInputs(inputs)
+	evaluator.SetRuntime(runtime)
+	evaluator.SetRequirements(tool.Requirements, tool.Hints)
 
+	defs := tool.SchemaDefs()
 	for _, out := range tool.Outputs {
-		if out.OutputBinding == nil {
+		outType, _ := defs.ParseType(out.Type)
+		if out.OutputBinding == nil && !outType.HasFieldOutputBindings() {
 			continue
 		}
 
-		// Evaluate glob patterns
-		patterns, err := evaluator.EvaluateGlob(out.OutputBinding.Glob)
+		value, err := e.collectOutput(taskDir, evaluator, out.OutputBinding, outType, out.Format, out.SecondaryFiles)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("output %s: %w", out.ID, err)
 		}
+		if value != nil {
+			outputs[out.ID] = value
+		}
+	}
 
-		var files []interface{}
-		for _, pattern := range patterns {
-			matches, err := filepath.Glob(filepath.Join(taskDir, pattern))
-			if err != nil {
+	return outputs, nil
+}
+
+// collectOutput collects one output, or one field of a record output. A
+// record whose fields have outputBindings is collected field by field.
+func (e *LocalExecutor) collectOutput(taskDir string, evaluator *cwl.ExpressionEvaluator, binding *cwl.CommandOutputBinding, outType *cwl.CWLType, format interface{}, secondaryFiles []cwl.SecondaryFileSpec) (interface{}, error) {
+	if outType.HasFieldOutputBindings() {
+		record := make(map[string]interface{})
+		for _, field := range outType.Fields {
+			if field.OutputBinding == nil && !field.Type.HasFieldOutputBindings() {
 				continue
 			}
+			value, err := e.collectOutput(taskDir, evaluator, field.OutputBinding, field.Type, field.Format, field.SecondaryFiles)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			if value != nil {
+				record[field.Name] = value
+			}
+		}
+		return record, nil
+	}
 
-			for _, match := range matches {
-				info, err := os.Stat(match)
-				if err != nil {
-					continue
-				}
+	// Evaluate glob patterns; without a glob there are no files
+	patterns, err := evaluator.EvaluateGlob(binding.Glob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate glob: %w", err)
+	}
 
-				basename := filepath.Base(match)
-				nameroot := strings.TrimSuffix(basename, filepath.Ext(basename))
-				nameext := filepath.Ext(basename)
-
-				fileValue := cwl.FileValue{
-					Class:    cwl.TypeFile,
-					Location: match,
-					Path:     match,
-					Basename: basename,
-					Dirname:  filepath.Dir(match),
-					Nameroot: nameroot,
-					Nameext:  nameext,
-					Size:     info.Size(),
-				}
+	evaluator.SetSelf(nil)
+	fileFormat, err := evaluator.EvaluateFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate format: %w", err)
+	}
+	opts := cwl.FileObjectOptions{
+		Checksum:     e.checksums,
+		LoadContents: binding.LoadContents,
+		LoadListing:  binding.LoadListing,
+		Format:       fileFormat,
+	}
 
-				// Load contents if requested
-				if out.OutputBinding.LoadContents {
-					contents, err := os.ReadFile(match)
-					if err == nil && len(contents) <= 64*1024 { // Max 64KB
-						fileValue.Contents = string(contents)
-					}
-				}
+	files := []interface{}{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(taskDir, pattern))
+		if err != nil {
+			continue
+		}
 
-				files = append(files, fileValue)
+		for _, match := range matches {
+			fileObj, err := cwl.BuildFileObject(match, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			// Collect secondary files next to the output
+			if len(secondaryFiles) > 0 && fileObj["class"] == cwl.TypeFile {
+				fileObj, err = e.collectSecondaryFiles(evaluator, fileObj, secondaryFiles)
+				if err != nil {
+					return nil, err
+				}
 			}
+
+			files = append(files, fileObj)
 		}
+	}
 
-		// Return single file or array
-		parsedType, _ := cwl.ParseType(out.Type)
-		if parsedType != nil && parsedType.BaseType() == cwl.TypeArray {
-			outputs[out.ID] = files
-		} else if len(files) > 0 {
-			outputs[out.ID] = files[0]
+	// outputEval sees the matched files as self
+	if binding.OutputEval != "" {
+		evaluator.SetSelf(files)
+		value, err := evaluator.Evaluate(binding.OutputEval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate outputEval: %w", err)
 		}
+		return value, nil
 	}
 
-	return outputs, nil
+	// Return single file or array
+	if outType != nil && outType.BaseType() == cwl.TypeArray {
+		return files, nil
+	}
+	if len(files) > 0 {
+		return files[0], nil
+	}
+	return nil, nil
 }
 
 // GetStatus gets the status of a local task.
@@ -219,6 +334,20 @@ func (e *LocalExecutor) GetOutputs(ctx context.Context, taskID string) (map[stri
 	return task.outputs, nil
 }
 
+// IsTemporaryFailure reports whether a failed local task exited with one of
+// its tool's temporaryFailCodes.
+func (e *LocalExecutor) IsTemporaryFailure(ctx context.Context, taskID string) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	task, ok := e.tasks[taskID]
+	if !ok {
+		return false, fmt.Errorf("task not found: %s", taskID)
+	}
+
+	return task.status == dag.StatusFailed && task.temporary, nil
+}
+
 // Cancel cancels a running local task.
 func (e *LocalExecutor) Cancel(ctx context.Context, taskID string) error {
 	e.mu.RLock()
@@ -235,3 +364,33 @@ func (e *LocalExecutor) Cancel(ctx context.Context, taskID string) error {
 
 	return nil
 }
+
+// collectSecondaryFiles attaches the secondary files that exist next to an
+// output File, each described as fully as its primary.
+func (e *LocalExecutor) collectSecondaryFiles(evaluator *cwl.ExpressionEvaluator, fileObj map[string]interface{}, specs []cwl.SecondaryFileSpec) (map[string]interface{}, error) {
+	attached, err := cwl.AttachSecondaryFiles(evaluator, fileObj, specs, false, fileExists)
+	if err != nil {
+		return nil, err
+	}
+
+	secondaries, _ := attached["secondaryFiles"].([]interface{})
+	for i, sf := range secondaries {
+		sfMap, ok := sf.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path, _ := sfMap["path"].(string)
+		sfObj, err := cwl.BuildFileObject(path, cwl.FileObjectOptions{Checksum: e.checksums})
+		if err != nil {
+			return nil, err
+		}
+		secondaries[i] = sfObj
+	}
+	return attached, nil
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
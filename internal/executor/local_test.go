@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/dag"
+)
+
+// runLocal executes node on a LocalExecutor and waits for it to finish.
+func runLocal(t *testing.T, node *dag.Node) (*LocalExecutor, dag.NodeStatus) {
+	t.Helper()
+	e := NewLocalExecutor(t.TempDir())
+	if err := e.Execute(context.Background(), node); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := e.GetStatus(context.Background(), node.ID)
+		if err != nil {
+			t.Fatalf("GetStatus failed: %v", err)
+		}
+		if status != dag.StatusRunning {
+			return e, status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for task")
+	return nil, ""
+}
+
+func TestLocalExecutor_OutputEvalWithoutGlob(t *testing.T) {
+	node := &dag.Node{
+		ID: "check",
+		Tool: &cwl.Document{
+			Class:        cwl.ClassCommandLineTool,
+			BaseCommand:  []interface{}{"sh", "-c", "exit 1"},
+			SuccessCodes: []int{1},
+			Outputs: []cwl.Output{{
+				ID:            "code",
+				Type:          "int",
+				OutputBinding: &cwl.CommandOutputBinding{OutputEval: "$(runtime.exitCode)"},
+			}},
+		},
+	}
+
+	e, status := runLocal(t, node)
+	if status != dag.StatusCompleted {
+		t.Fatalf("Expected completed, got %s", status)
+	}
+	outputs, err := e.GetOutputs(context.Background(), node.ID)
+	if err != nil {
+		t.Fatalf("GetOutputs failed: %v", err)
+	}
+	if outputs["code"] != 1 {
+		t.Errorf("Expected exit code output 1, got %v (%T)", outputs["code"], outputs["code"])
+	}
+}
+
+func TestLocalExecutor_OutputCollectionFailure(t *testing.T) {
+	node := &dag.Node{
+		ID: "broken",
+		Tool: &cwl.Document{
+			Class:       cwl.ClassCommandLineTool,
+			BaseCommand: []interface{}{"true"},
+			Outputs: []cwl.Output{{
+				ID:            "out",
+				Type:          "File",
+				OutputBinding: &cwl.CommandOutputBinding{Glob: "$(inputs.missing.path)"},
+			}},
+		},
+	}
+
+	_, status := runLocal(t, node)
+	if status != dag.StatusFailed {
+		t.Errorf("Expected failed when outputs cannot be collected, got %s", status)
+	}
+}
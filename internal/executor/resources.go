@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BV-BRC/cwe-cwl/internal/config"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/dag"
+)
+
+// nodeResources evaluates the node's ResourceRequirement with its inputs
+// and fits it to the cluster limits. Maximums above a limit are capped; a
+// minimum above one is an error, since no cluster node could run the step.
+func nodeResources(node *dag.Node, cfg config.ExecutorConfig) (cwl.Resources, error) {
+	res, err := node.Tool.ResolveResources(node.Inputs)
+	if err != nil {
+		return res, err
+	}
+
+	if cfg.MaxCPU > 0 {
+		if res.Cores > cfg.MaxCPU {
+			return res, fmt.Errorf("step requires %d cores, more than the cluster limit of %d", res.Cores, cfg.MaxCPU)
+		}
+		if res.CoresMax > cfg.MaxCPU {
+			res.CoresMax = cfg.MaxCPU
+		}
+	}
+	if cfg.MaxMemory > 0 {
+		if res.RAM > cfg.MaxMemory {
+			return res, fmt.Errorf("step requires %d MB of RAM, more than the cluster limit of %d MB", res.RAM, cfg.MaxMemory)
+		}
+		if res.RAMMax > cfg.MaxMemory {
+			res.RAMMax = cfg.MaxMemory
+		}
+	}
+
+	return res, nil
+}
+
+// timeLimitGrace is added to a step's time limit when requesting task
+// runtime, so cwl-step-runner reports the timeout before the cluster kills
+// the job.
+const timeLimitGrace = 5 * time.Minute
+
+// requestedRuntime returns the task runtime to request, in seconds, for a
+// step with the given ToolTimeLimit, or the configured default if it has
+// none.
+func requestedRuntime(limit time.Duration, cfg config.ExecutorConfig) int {
+	if limit <= 0 {
+		return cfg.DefaultRuntime
+	}
+	return int((limit + timeLimitGrace) / time.Second)
+}
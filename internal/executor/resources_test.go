@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BV-BRC/cwe-cwl/internal/config"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+	"github.com/BV-BRC/cwe-cwl/internal/dag"
+)
+
+func TestNodeResources(t *testing.T) {
+	tool := &cwl.Document{
+		Class: cwl.ClassCommandLineTool,
+		Requirements: []cwl.Requirement{
+			{Class: "ResourceRequirement", CoresMin: 4, CoresMax: 64, RAMMin: "$(inputs.memory)"},
+		},
+	}
+	limits := config.ExecutorConfig{MaxCPU: 32, MaxMemory: 65536}
+
+	node := &dag.Node{ID: "assemble", Tool: tool, Inputs: map[string]interface{}{"memory": 32768}}
+	res, err := nodeResources(node, limits)
+	if err != nil {
+		t.Fatalf("nodeResources failed: %v", err)
+	}
+	if res.Cores != 4 || res.CoresMax != 32 || res.RAM != 32768 {
+		t.Errorf("Unexpected resources: %+v", res)
+	}
+
+	node.Inputs = map[string]interface{}{"memory": 131072}
+	if _, err := nodeResources(node, limits); err == nil {
+		t.Error("Expected error for RAM above the cluster limit")
+	}
+}
+
+func TestRequestedRuntime(t *testing.T) {
+	cfg := config.ExecutorConfig{DefaultRuntime: 86400}
+
+	if got := requestedRuntime(0, cfg); got != 86400 {
+		t.Errorf("Expected default runtime, got %d", got)
+	}
+	if got := requestedRuntime(2*time.Hour, cfg); got != 7200+300 {
+		t.Errorf("Expected time limit plus grace, got %d", got)
+	}
+}
@@ -0,0 +1,40 @@
+// Package process runs tool commands under CWL time limits.
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTimeLimit is returned by Run when a command exceeds its time limit.
+var ErrTimeLimit = errors.New("time limit exceeded")
+
+// Run runs cmd and waits for it to finish. With a positive limit, cmd runs
+// in its own process group and the whole group is killed when the limit
+// expires, so processes the tool started do not outlive it.
+func Run(cmd *exec.Cmd, limit time.Duration) error {
+	if limit <= 0 {
+		return cmd.Run()
+	}
+
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var expired atomic.Bool
+	timer := time.AfterFunc(limit, func() {
+		expired.Store(true)
+		killProcessGroup(cmd)
+	})
+	err := cmd.Wait()
+	timer.Stop()
+
+	if expired.Load() {
+		return fmt.Errorf("%w after %s", ErrTimeLimit, limit)
+	}
+	return err
+}
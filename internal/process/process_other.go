@@ -0,0 +1,16 @@
+//go:build !linux
+
+package process
+
+import "os/exec"
+
+// setProcessGroup is a no-op on non-Linux platforms.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills only cmd's process on non-Linux platforms, where
+// it does not lead a process group.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
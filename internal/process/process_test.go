@@ -0,0 +1,33 @@
+package process
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRun_TimeLimit(t *testing.T) {
+	// The shell's child sleep must be killed with it for Run to return
+	cmd := exec.Command("/bin/sh", "-c", "sleep 30; true")
+	start := time.Now()
+	err := Run(cmd, 200*time.Millisecond)
+	if !errors.Is(err, ErrTimeLimit) {
+		t.Fatalf("Expected ErrTimeLimit, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run returned after %s", elapsed)
+	}
+}
+
+func TestRun_WithinLimit(t *testing.T) {
+	if err := Run(exec.Command("true"), time.Minute); err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+
+	err := Run(exec.Command("false"), time.Minute)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("Expected exit status 1, got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package staging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+// workspaceEntry is an item in a Workspace folder listing.
+type workspaceEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// StageDirectory stages a CWL Directory object into targetDir. A Directory
+// with a location is copied recursively from its backend; a Directory
+// literal is created from its listing, staging each entry in turn. It
+// returns a copy of the Directory pointing at the staged tree. A listing
+// on a located Directory is rebuilt from the staged tree.
+func (s *Stager) StageDirectory(ctx context.Context, dir map[string]interface{}, targetDir string) (map[string]interface{}, error) {
+	literal := cwl.IsDirectoryLiteral(dir)
+
+	basename, _ := dir["basename"].(string)
+	var ref *FileRef
+	if !literal {
+		var err error
+		ref, err = s.ParseFileRef(dir)
+		if err != nil {
+			return nil, err
+		}
+		if basename == "" {
+			basename = filepath.Base(ref.Path)
+		}
+	}
+	if basename == "" {
+		return nil, fmt.Errorf("directory literal has no basename")
+	}
+	targetPath := filepath.Join(targetDir, basename)
+
+	staged := make(map[string]interface{}, len(dir))
+	for k, v := range dir {
+		staged[k] = v
+	}
+
+	if literal {
+		listing, err := s.stageListing(ctx, dir["listing"], targetPath)
+		if err != nil {
+			return nil, fmt.Errorf("directory %s: %w", basename, err)
+		}
+		staged["listing"] = listing
+	} else {
+		if err := s.Stage(ctx, ref, targetPath); err != nil {
+			return nil, fmt.Errorf("failed to stage %s: %w", ref.Path, err)
+		}
+		if listing, ok := dir["listing"].([]interface{}); ok {
+			relisted, err := cwl.ListDirectory(targetPath, hasNestedListing(listing))
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", targetPath, err)
+			}
+			staged["listing"] = relisted
+		}
+	}
+
+	staged["path"] = targetPath
+	staged["location"] = targetPath
+	staged["basename"] = basename
+	return staged, nil
+}
+
+// stageListing creates the entries of a Directory literal's listing in
+// dirPath and returns the staged entries.
+func (s *Stager) stageListing(ctx context.Context, listing interface{}, dirPath string) ([]interface{}, error) {
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, err
+	}
+
+	items, _ := listing.([]interface{})
+	staged := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid listing entry: %T", item)
+		}
+
+		var entry map[string]interface{}
+		var err error
+		switch class, _ := obj["class"].(string); class {
+		case cwl.TypeDirectory:
+			entry, err = s.StageDirectory(ctx, obj, dirPath)
+		case cwl.TypeFile:
+			entry, err = s.StageFile(ctx, obj, dirPath)
+		default:
+			err = fmt.Errorf("invalid listing entry class: %q", class)
+		}
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, entry)
+	}
+	return staged, nil
+}
+
+// hasNestedListing reports whether any Directory in listing carries its own
+// listing, meaning the listing was loaded deeply.
+func hasNestedListing(listing []interface{}) bool {
+	for _, item := range listing {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := obj["listing"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// stageDirectory copies a directory tree from its backend.
+func (s *Stager) stageDirectory(ctx context.Context, ref *FileRef, targetPath string) error {
+	switch ref.Backend {
+	case BackendLocal:
+		return copyPath(ref.Path, targetPath)
+	case BackendWorkspace:
+		return s.stageWorkspaceFolder(ctx, ref.Path, targetPath)
+	case BackendShock:
+		return fmt.Errorf("shock nodes cannot hold directories: %s", ref.Path)
+	default:
+		return fmt.Errorf("unknown backend: %s", ref.Backend)
+	}
+}
+
+// stageWorkspaceFolder downloads a Workspace folder recursively.
+func (s *Stager) stageWorkspaceFolder(ctx context.Context, path, targetPath string) error {
+	entries, err := s.listWorkspaceFolder(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(targetPath, 0755); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		child := strings.TrimSuffix(path, "/") + "/" + entry.Name
+		target := filepath.Join(targetPath, entry.Name)
+		if entry.Type == "folder" {
+			err = s.stageWorkspaceFolder(ctx, child, target)
+		} else {
+			err = s.stageFromWorkspace(ctx, &FileRef{Backend: BackendWorkspace, Path: child}, target)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stage %s: %w", child, err)
+		}
+	}
+	return nil
+}
+
+// listWorkspaceFolder lists the items in a Workspace folder.
+func (s *Stager) listWorkspaceFolder(ctx context.Context, path string) ([]workspaceEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.config.WorkspaceURL+"/ls"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", s.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("workspace API error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("folder not found: %s", path)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("unauthorized access to: %s", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("workspace error: %d", resp.StatusCode)
+	}
+
+	var entries []workspaceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// uploadDirectory uploads a directory tree. Shock has no directories, so
+// trees bound for Shock must go through UploadDirectory.
+func (s *Stager) uploadDirectory(ctx context.Context, localPath string, targetBackend Backend, targetPath string) (*FileRef, error) {
+	switch targetBackend {
+	case BackendLocal:
+		if err := copyPath(localPath, targetPath); err != nil {
+			return nil, err
+		}
+	case BackendWorkspace:
+		if _, err := s.UploadDirectory(ctx, localPath, targetBackend, targetPath); err != nil {
+			return nil, err
+		}
+	case BackendShock:
+		return nil, fmt.Errorf("shock nodes cannot hold directories: %s", localPath)
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", targetBackend)
+	}
+
+	return &FileRef{
+		Backend:   targetBackend,
+		Path:      targetPath,
+		Directory: true,
+	}, nil
+}
+
+// UploadDirectory uploads a directory tree file by file and returns a CWL
+// Directory object with a deep listing of the uploaded entries. Shock has
+// no directories, so for Shock the result is a Directory literal whose
+// listing points at the uploaded nodes.
+func (s *Stager) UploadDirectory(ctx context.Context, localPath string, targetBackend Backend, targetPath string) (map[string]interface{}, error) {
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		src := filepath.Join(localPath, entry.Name())
+		dst := strings.TrimSuffix(targetPath, "/") + "/" + entry.Name()
+
+		if entry.IsDir() {
+			sub, err := s.UploadDirectory(ctx, src, targetBackend, dst)
+			if err != nil {
+				return nil, err
+			}
+			listing = append(listing, sub)
+			continue
+		}
+
+		ref, err := s.Upload(ctx, src, targetBackend, dst)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", src, err)
+		}
+		obj, err := uploadedFileObject(src, ref)
+		if err != nil {
+			return nil, err
+		}
+		listing = append(listing, obj)
+	}
+
+	dir := map[string]interface{}{
+		"class":    cwl.TypeDirectory,
+		"basename": filepath.Base(localPath),
+		"listing":  listing,
+	}
+	if targetBackend != BackendShock {
+		dir["location"] = targetPath
+		dir["path"] = targetPath
+	}
+	return dir, nil
+}
+
+// uploadedFileObject describes the local file src as a CWL File object at
+// the location it was uploaded to. The name comes from src because Shock
+// locations do not carry it.
+func uploadedFileObject(src string, ref *FileRef) (map[string]interface{}, error) {
+	obj, err := cwl.BuildFileObject(src, cwl.FileObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	relocate(obj, ref)
+	return obj, nil
+}
+
+// relocate points a File object at the location it was uploaded to.
+func relocate(obj map[string]interface{}, ref *FileRef) {
+	obj["location"] = ref.Path
+	obj["path"] = ref.Path
+	if ref.Backend == BackendShock {
+		delete(obj, "dirname")
+	} else {
+		obj["dirname"] = path.Dir(ref.Path)
+	}
+	if strings.Contains(ref.Checksum, "$") {
+		obj["checksum"] = ref.Checksum
+	}
+}
@@ -0,0 +1,115 @@
+package staging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/config"
+)
+
+func TestStageDirectory_Located(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "refdb")
+	os.MkdirAll(filepath.Join(src, "index"), 0755)
+	os.WriteFile(filepath.Join(src, "index", "ref.fai"), []byte("fai"), 0644)
+
+	stager := NewStager(&config.StorageConfig{})
+	target := t.TempDir()
+	dir := map[string]interface{}{"class": "Directory", "path": src, "listing": []interface{}{}}
+
+	staged, err := stager.StageDirectory(context.Background(), dir, target)
+	if err != nil {
+		t.Fatalf("StageDirectory failed: %v", err)
+	}
+	if staged["path"] != filepath.Join(target, "refdb") {
+		t.Errorf("Unexpected staged path %v", staged["path"])
+	}
+	if _, err := os.Stat(filepath.Join(target, "refdb", "index", "ref.fai")); err != nil {
+		t.Errorf("Expected tree copied recursively: %v", err)
+	}
+	if listing := staged["listing"].([]interface{}); len(listing) != 1 {
+		t.Errorf("Expected listing rebuilt from the staged tree, got %v", listing)
+	}
+}
+
+func TestStageDirectory_Literal(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "reads.fq")
+	os.WriteFile(src, []byte("@r1"), 0644)
+
+	stager := NewStager(&config.StorageConfig{})
+	target := t.TempDir()
+	dir := map[string]interface{}{
+		"class":    "Directory",
+		"basename": "inputs",
+		"listing": []interface{}{
+			map[string]interface{}{"class": "File", "basename": "samples.txt", "contents": "s1\n"},
+			map[string]interface{}{"class": "File", "path": src},
+			map[string]interface{}{
+				"class":    "Directory",
+				"basename": "empty",
+				"listing":  []interface{}{},
+			},
+		},
+	}
+
+	staged, err := stager.StageDirectory(context.Background(), dir, target)
+	if err != nil {
+		t.Fatalf("StageDirectory failed: %v", err)
+	}
+
+	root := filepath.Join(target, "inputs")
+	if data, err := os.ReadFile(filepath.Join(root, "samples.txt")); err != nil || string(data) != "s1\n" {
+		t.Errorf("Expected file literal written, got %q (err: %v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "reads.fq")); err != nil {
+		t.Errorf("Expected listed file staged: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("Expected nested directory created: %v", err)
+	}
+	listing := staged["listing"].([]interface{})
+	if path := listing[0].(map[string]interface{})["path"]; path != filepath.Join(root, "samples.txt") {
+		t.Errorf("Unexpected staged entry path %v", path)
+	}
+
+	// A literal must be named
+	delete(dir, "basename")
+	if _, err := stager.StageDirectory(context.Background(), dir, target); err == nil {
+		t.Error("Expected error for directory literal without basename")
+	}
+}
+
+func TestUploadDirectory_Local(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "results")
+	os.MkdirAll(filepath.Join(src, "plots"), 0755)
+	os.WriteFile(filepath.Join(src, "summary.tsv"), []byte("a\tb\n"), 0644)
+	os.WriteFile(filepath.Join(src, "plots", "pca.png"), []byte("png"), 0644)
+
+	stager := NewStager(&config.StorageConfig{})
+	target := filepath.Join(t.TempDir(), "published")
+
+	ref, err := stager.Upload(context.Background(), src, BackendLocal, target)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if !ref.Directory || ref.Path != target {
+		t.Errorf("Unexpected ref %+v", ref)
+	}
+	if _, err := os.Stat(filepath.Join(target, "plots", "pca.png")); err != nil {
+		t.Errorf("Expected tree uploaded recursively: %v", err)
+	}
+
+	dir, err := stager.UploadDirectory(context.Background(), src, BackendLocal, filepath.Join(t.TempDir(), "copy"))
+	if err != nil {
+		t.Fatalf("UploadDirectory failed: %v", err)
+	}
+	listing := dir["listing"].([]interface{})
+	if len(listing) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", listing)
+	}
+	plots := listing[0].(map[string]interface{})
+	if plots["class"] != "Directory" || len(plots["listing"].([]interface{})) != 1 {
+		t.Errorf("Expected deep listing of plots, got %v", plots)
+	}
+}
@@ -0,0 +1,116 @@
+package staging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+// StageInputs materializes the Files and Directories among inputs that
+// cwl.PlaceInputs placed under root, at their paths: literals are written
+// out, and located objects staged from their location, Files along with
+// their secondaryFiles. It returns a copy of inputs pointing at the staged
+// copies.
+func (s *Stager) StageInputs(ctx context.Context, inputs map[string]interface{}, root string) (map[string]interface{}, error) {
+	staged := make(map[string]interface{}, len(inputs))
+	for id, value := range inputs {
+		v, err := s.stageInput(ctx, value, root)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", id, err)
+		}
+		staged[id] = v
+	}
+	return staged, nil
+}
+
+func (s *Stager) stageInput(ctx context.Context, value interface{}, root string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		obj, dir, ok := unplace(v, root)
+		if !ok {
+			return value, nil
+		}
+
+		switch class, _ := v["class"].(string); class {
+		case cwl.TypeDirectory:
+			return s.StageDirectory(ctx, obj, dir)
+		case cwl.TypeFile:
+			if secondaries, ok := obj["secondaryFiles"].([]interface{}); ok {
+				unplaced := make([]interface{}, len(secondaries))
+				for i, sf := range secondaries {
+					unplaced[i] = sf
+					if sfMap, ok := sf.(map[string]interface{}); ok {
+						if sfObj, _, ok := unplace(sfMap, root); ok {
+							unplaced[i] = sfObj
+						}
+					}
+				}
+				obj["secondaryFiles"] = unplaced
+			}
+			return s.StageFile(ctx, obj, dir)
+		}
+		return value, nil
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			staged, err := s.stageInput(ctx, item, root)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = staged
+		}
+		return items, nil
+	}
+	return value, nil
+}
+
+// unplace strips the path cwl.PlaceInputs gave an object under root to
+// recover the literal, or the located object with only its source
+// location, and returns it with the directory it was placed in.
+func unplace(v map[string]interface{}, root string) (map[string]interface{}, string, bool) {
+	path, _ := v["path"].(string)
+	if !strings.HasPrefix(path, root+"/") {
+		return nil, "", false
+	}
+
+	obj := make(map[string]interface{}, len(v))
+	for k, val := range v {
+		obj[k] = val
+	}
+	delete(obj, "path")
+	if location, _ := v["location"].(string); location == path {
+		delete(obj, "location")
+	}
+	obj["basename"] = filepath.Base(path)
+	return obj, filepath.Dir(path), true
+}
+
+// writeFileLiteral writes a File literal's contents into targetDir under
+// its basename and returns a copy pointing at the written file.
+func writeFileLiteral(file map[string]interface{}, targetDir string) (map[string]interface{}, error) {
+	basename, _ := file["basename"].(string)
+	if basename == "" {
+		return nil, fmt.Errorf("file literal has no basename")
+	}
+	contents, _ := file["contents"].(string)
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return nil, err
+	}
+	targetPath := filepath.Join(targetDir, basename)
+	if err := os.WriteFile(targetPath, []byte(contents), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", basename, err)
+	}
+
+	staged := make(map[string]interface{}, len(file)+2)
+	for k, v := range file {
+		staged[k] = v
+	}
+	staged["path"] = targetPath
+	staged["location"] = targetPath
+	return staged, nil
+}
@@ -0,0 +1,121 @@
+package staging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BV-BRC/cwe-cwl/internal/config"
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+func TestStageInputs(t *testing.T) {
+	workDir := t.TempDir()
+	refdb := filepath.Join(t.TempDir(), "refdb")
+	os.MkdirAll(refdb, 0755)
+	os.WriteFile(filepath.Join(refdb, "ref.fa"), []byte(">chr1"), 0644)
+
+	inputs := cwl.PlaceInputs(map[string]interface{}{
+		"params": map[string]interface{}{
+			"class":    "File",
+			"basename": "params.json",
+			"contents": `{"k": 31}`,
+		},
+		"samples": map[string]interface{}{
+			"class": "Directory",
+			"listing": []interface{}{
+				map[string]interface{}{"class": "File", "basename": "s1.txt", "contents": "s1"},
+			},
+		},
+		"db": map[string]interface{}{"class": "Directory", "location": refdb},
+	}, workDir)
+
+	stager := NewStager(&config.StorageConfig{})
+	inputs, err := stager.StageInputs(context.Background(), inputs, workDir)
+	if err != nil {
+		t.Fatalf("StageInputs failed: %v", err)
+	}
+
+	path := inputs["params"].(map[string]interface{})["path"].(string)
+	if data, err := os.ReadFile(path); err != nil || string(data) != `{"k": 31}` {
+		t.Errorf("Expected File literal written to %s, got %q (err: %v)", path, data, err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "samples", "samples", "s1.txt")); err != nil {
+		t.Errorf("Expected Directory literal materialized: %v", err)
+	}
+	db := inputs["db"].(map[string]interface{})
+	if db["location"] != filepath.Join(workDir, "db", "refdb") || db["path"] != db["location"] {
+		t.Errorf("Expected located Directory staged under the working directory, got %v", db)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "db", "refdb", "ref.fa")); err != nil {
+		t.Errorf("Expected located Directory copied: %v", err)
+	}
+}
+
+func TestStageInputs_SecondaryFiles(t *testing.T) {
+	workDir := t.TempDir()
+	dataDir := t.TempDir()
+	bam := filepath.Join(dataDir, "sample.bam")
+	os.WriteFile(bam, []byte("bam"), 0644)
+	os.WriteFile(bam+".bai", []byte("bai"), 0644)
+
+	inputs := cwl.PlaceInputs(map[string]interface{}{
+		"reads": map[string]interface{}{
+			"class":    "File",
+			"location": bam,
+			"dirname":  dataDir,
+			"secondaryFiles": []interface{}{
+				map[string]interface{}{"class": "File", "location": bam + ".bai"},
+			},
+		},
+	}, workDir)
+
+	// The command line sees the paths placed in the working directory
+	reads := inputs["reads"].(map[string]interface{})
+	placed := filepath.Join(workDir, "reads", "sample.bam")
+	if reads["path"] != placed || reads["location"] != bam || reads["dirname"] != filepath.Dir(placed) {
+		t.Fatalf("Unexpected placed File %v", reads)
+	}
+
+	stager := NewStager(&config.StorageConfig{})
+	inputs, err := stager.StageInputs(context.Background(), inputs, workDir)
+	if err != nil {
+		t.Fatalf("StageInputs failed: %v", err)
+	}
+
+	reads = inputs["reads"].(map[string]interface{})
+	if reads["path"] != placed {
+		t.Errorf("Expected reads staged at %s, got %v", placed, reads["path"])
+	}
+	sf := reads["secondaryFiles"].([]interface{})[0].(map[string]interface{})
+	if sf["path"] != placed+".bai" {
+		t.Errorf("Expected index staged next to reads, got %v", sf["path"])
+	}
+	for _, p := range []string{placed, placed + ".bai"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("Expected %s staged: %v", p, err)
+		}
+	}
+}
+
+func TestStageFile_Literal(t *testing.T) {
+	stager := NewStager(&config.StorageConfig{})
+	target := t.TempDir()
+
+	staged, err := stager.StageFile(context.Background(), map[string]interface{}{
+		"class":    "File",
+		"basename": "config.yaml",
+		"contents": "threads: 4\n",
+	}, target)
+	if err != nil {
+		t.Fatalf("StageFile failed: %v", err)
+	}
+	if staged["path"] != filepath.Join(target, "config.yaml") {
+		t.Errorf("Unexpected staged path %v", staged["path"])
+	}
+
+	if _, err := stager.StageFile(context.Background(), map[string]interface{}{"class": "File", "contents": "x"}, target); err == nil {
+		t.Error("Expected error for File literal without basename")
+	}
+}
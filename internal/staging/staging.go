@@ -27,11 +27,12 @@ const (
 
 // FileRef represents a reference to a file in any backend.
 type FileRef struct {
-	Backend  Backend `json:"backend"`
-	Path     string  `json:"path"`     // Path in Workspace or local FS
-	NodeID   string  `json:"node_id"`  // Shock node ID
-	Size     int64   `json:"size"`
-	Checksum string  `json:"checksum"`
+	Backend   Backend `json:"backend"`
+	Path      string  `json:"path"`    // Path in Workspace or local FS
+	NodeID    string  `json:"node_id"` // Shock node ID
+	Size      int64   `json:"size"`
+	Checksum  string  `json:"checksum"`
+	Directory bool    `json:"directory,omitempty"` // Reference is to a directory tree
 }
 
 // Stager handles file staging between backends.
@@ -65,7 +66,7 @@ func (s *Stager) ParseFileRef(file interface{}) (*FileRef, error) {
 			return nil, fmt.Errorf("not a File or Directory: %s", class)
 		}
 
-		ref := &FileRef{}
+		ref := &FileRef{Directory: class == cwl.TypeDirectory}
 
 		// Get path or location
 		if path, ok := v["path"].(string); ok {
@@ -242,8 +243,12 @@ func (s *Stager) validateShock(ctx context.Context, ref *FileRef) error {
 	return nil
 }
 
-// Stage copies a file to a target location.
+// Stage copies a file, or a directory tree, to a target location.
 func (s *Stager) Stage(ctx context.Context, ref *FileRef, targetPath string) error {
+	if ref.Directory {
+		return s.stageDirectory(ctx, ref, targetPath)
+	}
+
 	switch ref.Backend {
 	case BackendLocal:
 		return s.stageFromLocal(ref, targetPath)
@@ -256,6 +261,114 @@ func (s *Stager) Stage(ctx context.Context, ref *FileRef, targetPath string) err
 	}
 }
 
+// StageFile stages a CWL File object into targetDir along with its
+// secondaryFiles, so indexes such as .bai and .fai sit next to their
+// primary. A File literal is written out from its contents. It returns a
+// copy of the File with paths pointing at the staged copies.
+func (s *Stager) StageFile(ctx context.Context, file map[string]interface{}, targetDir string) (map[string]interface{}, error) {
+	staged, err := s.stageFileObject(ctx, file, targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	secondaries, ok := file["secondaryFiles"].([]interface{})
+	if !ok {
+		return staged, nil
+	}
+
+	var stagedSecondaries []interface{}
+	for _, sf := range secondaries {
+		sfMap, ok := sf.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stagedSF, err := s.stageFileObject(ctx, sfMap, targetDir)
+		if err != nil {
+			return nil, fmt.Errorf("secondary file: %w", err)
+		}
+		stagedSecondaries = append(stagedSecondaries, stagedSF)
+	}
+	staged["secondaryFiles"] = stagedSecondaries
+
+	return staged, nil
+}
+
+// stageFileObject stages a single File object into targetDir under its
+// basename and returns a copy pointing at the staged file.
+func (s *Stager) stageFileObject(ctx context.Context, file map[string]interface{}, targetDir string) (map[string]interface{}, error) {
+	if cwl.IsFileLiteral(file) {
+		return writeFileLiteral(file, targetDir)
+	}
+
+	ref, err := s.ParseFileRef(file)
+	if err != nil {
+		return nil, err
+	}
+
+	basename, _ := file["basename"].(string)
+	if basename == "" {
+		basename = filepath.Base(ref.Path)
+	}
+	targetPath := filepath.Join(targetDir, basename)
+
+	if err := s.Stage(ctx, ref, targetPath); err != nil {
+		return nil, fmt.Errorf("failed to stage %s: %w", ref.Path, err)
+	}
+
+	staged := make(map[string]interface{}, len(file))
+	for k, v := range file {
+		staged[k] = v
+	}
+	staged["path"] = targetPath
+	staged["location"] = targetPath
+	staged["basename"] = basename
+	return staged, nil
+}
+
+// UploadFile uploads a local CWL File object into targetDir on a backend,
+// along with its secondaryFiles, and returns a copy of the File pointing at
+// the uploaded copies.
+func (s *Stager) UploadFile(ctx context.Context, file map[string]interface{}, targetBackend Backend, targetDir string) (map[string]interface{}, error) {
+	src, _ := file["path"].(string)
+	if src == "" {
+		return nil, fmt.Errorf("file has no path")
+	}
+	target := strings.TrimSuffix(targetDir, "/") + "/" + filepath.Base(src)
+	if class, _ := file["class"].(string); class == cwl.TypeDirectory {
+		return s.UploadDirectory(ctx, src, targetBackend, target)
+	}
+
+	uploaded := make(map[string]interface{}, len(file))
+	for k, v := range file {
+		uploaded[k] = v
+	}
+	ref, err := s.Upload(ctx, src, targetBackend, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", src, err)
+	}
+	relocate(uploaded, ref)
+
+	secondaries, ok := file["secondaryFiles"].([]interface{})
+	if !ok {
+		return uploaded, nil
+	}
+	uploadedSecondaries := make([]interface{}, 0, len(secondaries))
+	for _, sf := range secondaries {
+		sfMap, ok := sf.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		uploadedSF, err := s.UploadFile(ctx, sfMap, targetBackend, targetDir)
+		if err != nil {
+			return nil, fmt.Errorf("secondary file: %w", err)
+		}
+		uploadedSecondaries = append(uploadedSecondaries, uploadedSF)
+	}
+	uploaded["secondaryFiles"] = uploadedSecondaries
+
+	return uploaded, nil
+}
+
 // stageFromLocal copies a local file.
 func (s *Stager) stageFromLocal(ref *FileRef, targetPath string) error {
 	src, err := os.Open(ref.Path)
@@ -346,8 +459,12 @@ func (s *Stager) stageFromShock(ctx context.Context, ref *FileRef, targetPath st
 	return err
 }
 
-// Upload uploads a file to a backend.
+// Upload uploads a file, or a directory tree, to a backend.
 func (s *Stager) Upload(ctx context.Context, localPath string, targetBackend Backend, targetPath string) (*FileRef, error) {
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		return s.uploadDirectory(ctx, localPath, targetBackend, targetPath)
+	}
+
 	switch targetBackend {
 	case BackendLocal:
 		return s.uploadToLocal(localPath, targetPath)
@@ -462,12 +579,22 @@ func (s *Stager) uploadToShock(ctx context.Context, localPath string) (*FileRef,
 	}, nil
 }
 
-// ToCWLFile converts a FileRef to a CWL File value.
+// ToCWLFile converts a FileRef to a CWL File value. A local file without a
+// sha1 checksum gets one computed.
 func (ref *FileRef) ToCWLFile() cwl.FileValue {
 	basename := filepath.Base(ref.Path)
 	nameroot := strings.TrimSuffix(basename, filepath.Ext(basename))
 	nameext := filepath.Ext(basename)
 
+	// Only keep checksums in CWL's "<algorithm>$<hex>" form
+	checksum := ref.Checksum
+	if !strings.Contains(checksum, "$") {
+		checksum = ""
+		if ref.Backend == BackendLocal {
+			checksum, _ = cwl.FileChecksum(ref.Path)
+		}
+	}
+
 	return cwl.FileValue{
 		Class:    cwl.TypeFile,
 		Location: ref.Path,
@@ -477,6 +604,6 @@ func (ref *FileRef) ToCWLFile() cwl.FileValue {
 		Nameroot: nameroot,
 		Nameext:  nameext,
 		Size:     ref.Size,
-		Checksum: ref.Checksum,
+		Checksum: checksum,
 	}
 }
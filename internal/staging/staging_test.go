@@ -0,0 +1,30 @@
+package staging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileRef_ToCWLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contigs.fa")
+	os.WriteFile(path, []byte("test"), 0644)
+
+	local := (&FileRef{Backend: BackendLocal, Path: path, Size: 4}).ToCWLFile()
+	if local.Checksum != "sha1$a94a8fe5ccb19ba61c4c0873d391e987982fbbd3" {
+		t.Errorf("Expected computed checksum, got %q", local.Checksum)
+	}
+	if local.Nameroot != "contigs" || local.Nameext != ".fa" || local.Dirname != filepath.Dir(path) {
+		t.Errorf("Unexpected name fields %+v", local)
+	}
+
+	supplied := (&FileRef{Backend: BackendWorkspace, Path: "/user/home/contigs.fa", Checksum: "sha1$abc"}).ToCWLFile()
+	if supplied.Checksum != "sha1$abc" {
+		t.Errorf("Expected supplied checksum kept, got %q", supplied.Checksum)
+	}
+
+	bare := (&FileRef{Backend: BackendShock, Path: "shock://host/node/1", Checksum: "d41d8cd9"}).ToCWLFile()
+	if bare.Checksum != "" {
+		t.Errorf("Expected checksum without an algorithm dropped, got %q", bare.Checksum)
+	}
+}
@@ -0,0 +1,103 @@
+package staging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BV-BRC/cwe-cwl/internal/cwl"
+)
+
+// StageWorkDir creates InitialWorkDirRequirement entries in workDir.
+// Generated files are written directly; File and Directory sources are
+// symlinked, or copied when the entry is writable.
+func StageWorkDir(workDir string, entries []cwl.WorkDirEntry) error {
+	for _, entry := range entries {
+		target := filepath.Join(workDir, filepath.FromSlash(entry.Entryname))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", entry.Entryname, err)
+		}
+
+		if entry.Contents != nil {
+			if err := os.WriteFile(target, []byte(*entry.Contents), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", entry.Entryname, err)
+			}
+			continue
+		}
+
+		src := sourcePath(entry.Source)
+		if src == "" {
+			return fmt.Errorf("entry %s has no source path", entry.Entryname)
+		}
+
+		var err error
+		if entry.Writable {
+			err = copyPath(src, target)
+		} else {
+			err = os.Symlink(src, target)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stage %s: %w", entry.Entryname, err)
+		}
+	}
+
+	return nil
+}
+
+// sourcePath returns the local path of a File or Directory object.
+func sourcePath(obj map[string]interface{}) string {
+	if p, ok := obj["path"].(string); ok && p != "" {
+		return p
+	}
+	if loc, ok := obj["location"].(string); ok {
+		return strings.TrimPrefix(loc, "file://")
+	}
+	return ""
+}
+
+// copyPath copies a file or directory tree.
+func copyPath(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return copyFile(src, dst, info.Mode())
+	}
+
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(p, target, fi.Mode())
+	})
+}
+
+// copyFile copies a single file, keeping its mode.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -29,6 +29,20 @@ const (
 	StepSkipped   StepStatus = "skipped"
 )
 
+// FailureReason classifies why a step execution failed.
+type FailureReason string
+
+const (
+	// FailureTimeout means the step exceeded its ToolTimeLimit.
+	FailureTimeout FailureReason = "timeout"
+	// FailureTemporary means the tool exited with one of its
+	// temporaryFailCodes and the step may succeed if retried.
+	FailureTemporary FailureReason = "temporary_failure"
+	// FailurePermanent means the tool exited with one of its
+	// permanentFailCodes, or any other code not in its successCodes.
+	FailurePermanent FailureReason = "permanent_failure"
+)
+
 // Workflow represents a cached CWL document.
 type Workflow struct {
 	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
@@ -36,6 +50,7 @@ type Workflow struct {
 	ContentHash string                 `bson:"content_hash" json:"content_hash"`
 	CWLVersion  string                 `bson:"cwl_version" json:"cwl_version"`
 	Document    map[string]interface{} `bson:"document" json:"document"`
+	EntryPoint  string                 `bson:"entry_point,omitempty" json:"entry_point,omitempty"`
 	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
 }
 
@@ -70,6 +85,8 @@ type NodeState struct {
 	Inputs       map[string]interface{} `bson:"inputs,omitempty" json:"inputs,omitempty"`
 	Outputs      map[string]interface{} `bson:"outputs,omitempty" json:"outputs,omitempty"`
 	Error        string                 `bson:"error,omitempty" json:"error,omitempty"`
+	Retries      int                    `bson:"retries,omitempty" json:"retries,omitempty"`
+	RetryAt      time.Time              `bson:"retry_at,omitempty" json:"retry_at,omitempty"`
 }
 
 // StepExecution represents a single step execution (links to BV-BRC Task).
@@ -83,6 +100,7 @@ type StepExecution struct {
 	Inputs        map[string]interface{} `bson:"inputs,omitempty" json:"inputs,omitempty"`
 	Outputs       map[string]interface{} `bson:"outputs,omitempty" json:"outputs,omitempty"`
 	ErrorMessage  string                 `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	FailureReason FailureReason          `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
 	CreatedAt     time.Time              `bson:"created_at" json:"created_at"`
 	StartedAt     *time.Time             `bson:"started_at,omitempty" json:"started_at,omitempty"`
 	CompletedAt   *time.Time             `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
@@ -122,12 +140,13 @@ type RunProgress struct {
 
 // StepExecutionSummary is a lightweight summary of a step execution.
 type StepExecutionSummary struct {
-	StepID       string     `bson:"step_id" json:"step_id"`
-	ScatterIndex []int      `bson:"scatter_index,omitempty" json:"scatter_index,omitempty"`
-	Status       StepStatus `bson:"status" json:"status"`
-	BVBRCTaskID  int64      `bson:"bvbrc_task_id,omitempty" json:"bvbrc_task_id,omitempty"`
-	StartedAt    *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
-	CompletedAt  *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	StepID        string        `bson:"step_id" json:"step_id"`
+	ScatterIndex  []int         `bson:"scatter_index,omitempty" json:"scatter_index,omitempty"`
+	Status        StepStatus    `bson:"status" json:"status"`
+	FailureReason FailureReason `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
+	BVBRCTaskID   int64         `bson:"bvbrc_task_id,omitempty" json:"bvbrc_task_id,omitempty"`
+	StartedAt     *time.Time    `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt   *time.Time    `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
 }
 
 // ValidationResult represents CWL document validation results.
@@ -149,11 +168,12 @@ type FileInfo struct {
 
 // SubmitRequest represents a workflow submission request.
 type SubmitRequest struct {
-	Workflow   interface{}            `json:"workflow"`             // CWL document or workflow_id
-	Inputs     map[string]interface{} `json:"inputs"`               // Job inputs
-	OutputPath string                 `json:"output_path"`          // Output path in Workspace
-	Name       string                 `json:"name,omitempty"`       // Optional workflow name
-	Tags       []string               `json:"tags,omitempty"`       // Optional tags
+	Workflow   interface{}            `json:"workflow"`              // CWL document or workflow_id
+	Inputs     map[string]interface{} `json:"inputs"`                // Job inputs
+	OutputPath string                 `json:"output_path"`           // Output path in Workspace
+	Name       string                 `json:"name,omitempty"`        // Optional workflow name
+	Tags       []string               `json:"tags,omitempty"`        // Optional tags
+	EntryPoint string                 `json:"entry_point,omitempty"` // Process to run from a packed $graph document
 }
 
 // SubmitResponse represents the response to a workflow submission.
@@ -342,6 +342,9 @@ func (s *Store) UpdateStepExecution(ctx context.Context, id primitive.ObjectID,
 	if update.ErrorMessage != "" {
 		updateDoc["error_message"] = update.ErrorMessage
 	}
+	if update.FailureReason != "" {
+		updateDoc["failure_reason"] = update.FailureReason
+	}
 	if update.SetStarted {
 		now := time.Now()
 		updateDoc["started_at"] = now
@@ -368,21 +371,24 @@ type StepExecutionUpdate struct {
 	BVBRCTaskID  int64
 	Outputs      map[string]interface{}
 	ErrorMessage string
-	SetStarted   bool
-	SetCompleted bool
-	IncrRetry    bool
+	// FailureReason classifies a failure, such as FailureTimeout.
+	FailureReason FailureReason
+	SetStarted    bool
+	SetCompleted  bool
+	IncrRetry     bool
 }
 
 // ListStepExecutions lists step executions for a workflow run.
 func (s *Store) ListStepExecutions(ctx context.Context, workflowRunID string) ([]StepExecutionSummary, error) {
 	opts := options.Find().
 		SetProjection(bson.M{
-			"step_id":       1,
-			"scatter_index": 1,
-			"status":        1,
-			"bvbrc_task_id": 1,
-			"started_at":    1,
-			"completed_at":  1,
+			"step_id":        1,
+			"scatter_index":  1,
+			"status":         1,
+			"failure_reason": 1,
+			"bvbrc_task_id":  1,
+			"started_at":     1,
+			"completed_at":   1,
 		})
 
 	cursor, err := s.stepExecutions.Find(ctx, bson.M{"workflow_run_id": workflowRunID}, opts)
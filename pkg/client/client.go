@@ -226,6 +226,7 @@ type SubmitRequest struct {
 	Inputs     map[string]interface{} `json:"inputs"`
 	OutputPath string                 `json:"output_path,omitempty"`
 	Name       string                 `json:"name,omitempty"`
+	EntryPoint string                 `json:"entry_point,omitempty"`
 }
 
 // SubmitResponse is the response from workflow submission.